@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/credential"
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+// newCredentials 按 CREDENTIAL_PROVIDER 创建调用腾讯云API的凭证来源：
+//
+//	static    SECRET_ID、SECRET_KEY，可选 SECRET_TOKEN（默认）
+//	env       TENCENTCLOUD_SECRET_ID、TENCENTCLOUD_SECRET_KEY、TENCENTCLOUD_SESSION_TOKEN
+//	file      CREDENTIAL_FILE 指定的JSON文件，修改后自动重新读取
+//	sts       以 SECRET_ID、SECRET_KEY 扮演 STS_ROLE_ARN 角色
+//	metadata  CVM实例绑定的角色，可选 CVM_ROLE_NAME、METADATA_ENDPOINT
+func newCredentials() (tencentcloud.CredentialProvider, error) {
+	static := credential.NewStatic(os.Getenv("SECRET_ID"), os.Getenv("SECRET_KEY"), os.Getenv("SECRET_TOKEN"))
+
+	switch provider := os.Getenv("CREDENTIAL_PROVIDER"); provider {
+	case "", "static":
+		if static.SecretID == "" || static.SecretKey == "" {
+			return nil, errors.New("缺少必要环境变量 SECRET_ID, SECRET_KEY")
+		}
+		return static, nil
+	case "env":
+		return credential.Env{}, nil
+	case "file":
+		path := os.Getenv("CREDENTIAL_FILE")
+		if path == "" {
+			return nil, errors.New("CREDENTIAL_PROVIDER=file 需要配置 CREDENTIAL_FILE")
+		}
+		return credential.NewFile(path), nil
+	case "sts":
+		roleArn := os.Getenv("STS_ROLE_ARN")
+		if roleArn == "" || static.SecretID == "" || static.SecretKey == "" {
+			return nil, errors.New("CREDENTIAL_PROVIDER=sts 需要配置 STS_ROLE_ARN, SECRET_ID, SECRET_KEY")
+		}
+		sessionName := os.Getenv("STS_ROLE_SESSION_NAME")
+		if sessionName == "" {
+			sessionName = "adp-openai-gateway"
+		}
+		p, err := credential.NewAssumeRole(static, roleArn, sessionName, envDuration("STS_DURATION", time.Hour))
+		if err != nil {
+			return nil, fmt.Errorf("STS_DURATION 无效: %w", err)
+		}
+		return p, nil
+	case "metadata":
+		return credential.NewMetadata(os.Getenv("METADATA_ENDPOINT"), os.Getenv("CVM_ROLE_NAME")), nil
+	default:
+		return nil, fmt.Errorf("未知的凭证来源: %s", provider)
+	}
+}
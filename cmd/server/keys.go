@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/auth"
+)
+
+const keysUsage = `用法: adp-openai-gateway keys <命令> [参数]
+
+命令:
+  create <name> [--admin] [--models m1,m2] [--rpm N] [--max-streams N] [--daily N]
+  list
+  revoke <name>
+  rotate <name>
+
+通用参数:
+  --file PATH   Key配置文件，默认为 API_KEYS_FILE
+`
+
+// runKeys 执行 keys 子命令，返回进程退出码
+// 服务运行中会自动重新加载配置文件，无需重启
+func runKeys(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("keys "+cmd, flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, keysUsage) }
+	file := fs.String("file", os.Getenv("API_KEYS_FILE"), "")
+	admin := fs.Bool("admin", false, "")
+	models := fs.String("models", "", "")
+	rpm := fs.Int("rpm", 0, "")
+	maxStreams := fs.Int("max-streams", 0, "")
+	daily := fs.Int("daily", 0, "")
+
+	// 允许名称写在参数前面：keys create alice --rpm 60
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if name == "" && fs.NArg() > 0 {
+		name = fs.Arg(0)
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "错误: 未配置 API_KEYS_FILE，请通过 --file 指定Key配置文件")
+		return 2
+	}
+
+	store, err := auth.LoadStore(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 1
+	}
+
+	if cmd != "list" && name == "" {
+		fmt.Fprintf(os.Stderr, "错误: keys %s 需要指定Key名称\n", cmd)
+		return 2
+	}
+
+	switch cmd {
+	case "create":
+		secret, _, err := store.Create(auth.Key{
+			Name:          name,
+			Admin:         *admin,
+			Models:        splitList(*models),
+			RPM:           *rpm,
+			MaxStreams:    *maxStreams,
+			DailyRequests: *daily,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			return 1
+		}
+		printSecret(name, secret)
+	case "rotate":
+		secret, _, err := store.Rotate(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			return 1
+		}
+		printSecret(name, secret)
+	case "revoke":
+		if _, err := store.Revoke(name); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			return 1
+		}
+		fmt.Printf("已停用API Key: %s\n", name)
+	case "list":
+		listKeys(store)
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+	return 0
+}
+
+// printSecret 输出新生成的Key明文
+func printSecret(name, secret string) {
+	fmt.Printf("API Key %s:\n\n  %s\n\n请妥善保存，明文不会再次显示\n", name, secret)
+}
+
+// listKeys 以表格形式列出Key
+// 用量由运行中的服务定期写入，可能有几秒延迟
+func listKeys(store *auth.Store) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tENABLED\tADMIN\tCREATED\tLAST USED\tREQUESTS\tMODELS\tLIMITS")
+	for _, k := range store.List() {
+		usage := store.Usage(k.Name)
+		models := strings.Join(k.Models, ",")
+		if models == "" {
+			models = "*"
+		}
+		fmt.Fprintf(w, "%s\t%t\t%t\t%s\t%s\t%d\t%s\t%s\n",
+			k.Name, k.Enabled, k.Admin, formatTime(k.CreatedAt), formatTime(usage.LastUsedAt),
+			usage.Requests, models, formatLimits(k))
+	}
+	w.Flush()
+}
+
+// formatLimits 限制的简写，如 rpm=60,streams=2
+func formatLimits(k auth.Key) string {
+	var limits []string
+	if k.RPM > 0 {
+		limits = append(limits, fmt.Sprintf("rpm=%d", k.RPM))
+	}
+	if k.MaxStreams > 0 {
+		limits = append(limits, fmt.Sprintf("streams=%d", k.MaxStreams))
+	}
+	if k.DailyRequests > 0 {
+		limits = append(limits, fmt.Sprintf("daily=%d", k.DailyRequests))
+	}
+	if len(limits) == 0 {
+		return "-"
+	}
+	return strings.Join(limits, ",")
+}
+
+// formatTime 本地时间，零值显示为 -
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// splitList 解析逗号分隔的列表
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
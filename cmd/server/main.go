@@ -1,44 +1,99 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
 	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/auth"
 	"github.com/brinkmai/adp-openai-gateway/internal/handler"
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+	"github.com/brinkmai/adp-openai-gateway/internal/metrics"
+	"github.com/brinkmai/adp-openai-gateway/internal/session"
+	"github.com/brinkmai/adp-openai-gateway/internal/tenant"
 )
 
+var logger = logging.Component("gateway")
+
 func main() {
 	// 加载配置
-	if err := godotenv.Load(".env"); err != nil {
-		log.Println("[Gateway] 未找到.env，使用环境变量")
+	envErr := godotenv.Load(".env")
+
+	// Key管理子命令
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:]))
+	}
+	if err := setupLogging(); err != nil {
+		fatal(err)
+	}
+	if envErr != nil {
+		logger.Info("未找到.env，使用环境变量")
 	}
 
 	// 验证配置
-	secretId := os.Getenv("SECRET_ID")
-	secretKey := os.Getenv("SECRET_KEY")
 	botAppKey := os.Getenv("ADP_BOT_APP_KEY")
-
-	if secretId == "" || secretKey == "" || botAppKey == "" {
-		log.Fatal("[Gateway] 错误: 缺少必要环境变量 SECRET_ID, SECRET_KEY, ADP_BOT_APP_KEY")
+	if botAppKey == "" {
+		fatal(errors.New("缺少必要环境变量 ADP_BOT_APP_KEY"))
+	}
+	credentials, err := newCredentials()
+	if err != nil {
+		fatal(err)
 	}
 
 	// 初始化客户端
-	client := adp.NewClient(secretId, secretKey, botAppKey)
-	openaiHandler := handler.NewOpenAIHandler(client)
+	client := adp.NewClient(credentials, botAppKey)
+
+	// 图片和文档需要通过ADP存储凭证上传，依赖应用的BotBizId
+	imageInput := os.Getenv("ADP_IMAGE_INPUT") == "true"
+	if imageInput && os.Getenv("ADP_BOT_BIZ_ID") == "" {
+		fatal(errors.New("开启 ADP_IMAGE_INPUT 需要配置 ADP_BOT_BIZ_ID"))
+	}
+	setupUploads(client, os.Getenv("ADP_BOT_BIZ_ID"), imageInput)
+
+	// 请求自带ADP凭证时使用独立的客户端
+	var tenants *tenant.Pool
+	if os.Getenv("ADP_BYO_CREDENTIALS") == "true" {
+		tenants = tenant.NewPool(credentials,
+			envInt("ADP_BYO_MAX_TENANTS", 32), envDuration("ADP_BYO_IDLE_TTL", 10*time.Minute),
+			func(c *adp.Client, creds tenant.Credentials) {
+				setupUploads(c, creds.BotBizID, imageInput)
+			})
+		logger.Info("已允许请求携带自有ADP凭证")
+	}
+	citations, err := handler.ParseCitationMode(os.Getenv("CITATION_MODE"))
+	if err != nil {
+		fatal(err)
+	}
+	models, err := handler.LoadModels(os.Getenv("MODELS_FILE"))
+	if err != nil {
+		fatal(err)
+	}
+	sessions := session.NewStore(envDuration("SESSION_TTL", 24*time.Hour))
+	openaiHandler := handler.NewOpenAIHandler(client, handler.Config{
+		JSONRetries: envInt("JSON_REPAIR_RETRIES", 2),
+		MaxChoices:  envInt("MAX_CHOICES", 4),
+		Citations:   citations,
+
+		AllowedVariables: handler.ParseAllowlist(os.Getenv("ADP_ALLOWED_VARIABLES")),
+		AllowedLabels:    handler.ParseAllowlist(os.Getenv("ADP_ALLOWED_LABELS")),
+		Models:           models,
+		Sessions:         sessions,
+	})
+	ollamaHandler := handler.NewOllamaHandler(client, models)
 
 	// 设置Gin
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
-	r.Use(gin.Recovery())
-	r.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return ""
-	}))
+	r.Use(logging.Middleware())
+	r.Use(gin.RecoveryWithWriter(logging.NewWriter(os.Stderr)))
 
 	// 路由
 	r.GET("/health", func(c *gin.Context) {
@@ -47,9 +102,58 @@ func main() {
 			"service": "adp-openai-gateway-go",
 		})
 	})
+	// Prometheus指标，不经过API Key鉴权，配置 METRICS_TOKEN 后需要携带该Token
+	r.GET("/metrics", gin.WrapH(metrics.Handler(os.Getenv("METRICS_TOKEN"))))
+
+	v1 := r.Group("/v1")
+	// Ollama兼容接口
+	api := r.Group("/api")
+
+	// 调用ADP的接口都需要鉴权，未配置Key文件时不校验
+	var keys *auth.Store
+	if keysFile := os.Getenv("API_KEYS_FILE"); keysFile != "" {
+		keys, err = auth.LoadStore(keysFile)
+		if err != nil {
+			fatal(err)
+		}
+		logger.Info("已启用API Key鉴权", "keys", keys.Len())
+		v1.Use(auth.Middleware(keys))
+		api.Use(auth.Middleware(keys))
 
-	r.GET("/v1/models", openaiHandler.GetModels)
-	r.POST("/v1/chat/completions", openaiHandler.ChatCompletions)
+		// 命令行修改Key后自动生效
+		go keys.Watch(envDuration("API_KEYS_RELOAD_INTERVAL", 5*time.Second))
+
+		adminHandler := auth.NewAdminHandler(keys)
+		admin := r.Group("/admin", auth.Middleware(keys), auth.RequireAdmin())
+		admin.GET("/keys", adminHandler.ListKeys)
+		admin.POST("/keys", adminHandler.CreateKey)
+		admin.POST("/keys/:name/revoke", adminHandler.RevokeKey)
+		admin.POST("/keys/:name/rotate", adminHandler.RotateKey)
+	} else {
+		logger.Warn("未配置 API_KEYS_FILE，不校验API Key，请勿监听公网地址")
+	}
+	v1.Use(tenant.Middleware(tenants))
+	api.Use(tenant.Middleware(tenants))
+
+	// 模型白名单和限流只作用于生成类接口，未启用鉴权时不生效
+	limiter := auth.NewLimiter()
+	v1.GET("/models", openaiHandler.GetModels)
+	v1.POST("/chat/completions", auth.Limits(limiter, handler.PeekRequest), openaiHandler.ChatCompletions)
+	v1.POST("/completions", auth.Limits(limiter, handler.PeekRequest), openaiHandler.Completions)
+	v1.POST("/feedback", openaiHandler.Feedback)
+	v1.POST("/sessions", openaiHandler.CreateSession)
+	v1.GET("/sessions/:id", openaiHandler.GetSession)
+	v1.POST("/sessions/:id/reset", openaiHandler.ResetSession)
+	v1.DELETE("/sessions/:id", openaiHandler.DeleteSession)
+	v1.GET("/sessions/:id/messages", openaiHandler.ListSessionMessages)
+	v1.POST("/files", openaiHandler.UploadFile)
+	v1.GET("/files", openaiHandler.ListFiles)
+	v1.GET("/files/:id", openaiHandler.GetFile)
+	v1.DELETE("/files/:id", openaiHandler.DeleteFile)
+
+	api.GET("/tags", ollamaHandler.Tags)
+	api.POST("/chat", auth.Limits(limiter, handler.PeekOllamaRequest), ollamaHandler.Chat)
+	api.POST("/generate", auth.Limits(limiter, handler.PeekOllamaRequest), ollamaHandler.Generate)
 
 	// 获取端口
 	port := os.Getenv("PORT")
@@ -62,20 +166,99 @@ func main() {
 	}
 	addr := host + ":" + port
 
-	log.Printf("[Gateway] ADP-OpenAI Gateway (Go) 运行在 %s", addr)
-	log.Printf("[Gateway] API端点: http://%s/v1/chat/completions", addr)
+	logger.Info("ADP-OpenAI Gateway (Go) 已启动", "addr", addr, "endpoint", "http://"+addr+"/v1/chat/completions")
 
 	// 优雅关闭
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 		<-sigCh
-		log.Println("[Gateway] 收到关闭信号，正在关闭...")
+		logger.Info("收到关闭信号，正在关闭...")
 		client.Disconnect()
+		sessions.Close()
+		if tenants != nil {
+			tenants.Close()
+		}
+		if keys != nil {
+			if err := keys.FlushUsage(); err != nil {
+				logger.Error("保存API Key用量失败", "error", err)
+			}
+		}
 		os.Exit(0)
 	}()
 
 	if err := r.Run(addr); err != nil {
-		log.Fatalf("[Gateway] 启动失败: %v", err)
+		fatal(fmt.Errorf("启动失败: %w", err))
+	}
+}
+
+// setupLogging 按 LOG_FORMAT、LOG_LEVEL、LOG_LEVELS、LOG_CONTENT 配置日志
+func setupLogging() error {
+	level, err := logging.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return err
+	}
+	levels, err := logging.ParseLevels(os.Getenv("LOG_LEVELS"))
+	if err != nil {
+		return err
+	}
+	format := os.Getenv("LOG_FORMAT")
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("未知的日志格式: %s", format)
+	}
+	contentMode, err := logging.ParseContentMode(os.Getenv("LOG_CONTENT"))
+	if err != nil {
+		return err
+	}
+
+	logging.Setup(os.Stderr, logging.Config{Format: format, Level: level, Levels: levels})
+	logging.SetContentMode(contentMode)
+	return nil
+}
+
+// fatal 记录错误并退出
+func fatal(err error) {
+	logger.Error("错误: " + err.Error())
+	os.Exit(1)
+}
+
+// setupUploads 配置文档上传和图片输入，botBizID为空时不支持
+func setupUploads(client *adp.Client, botBizID string, imageInput bool) {
+	if botBizID == "" {
+		return
+	}
+	uploader := adp.NewUploader(client.TokenService(), botBizID)
+	uploader.Endpoint = os.Getenv("ADP_STORAGE_ENDPOINT")
+	client.SetUploader(uploader)
+	if imageInput {
+		client.EnableImageInput()
+	}
+}
+
+// envInt 读取整数环境变量，未设置或格式错误时使用默认值
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Warn("环境变量格式错误，使用默认值", "key", key, "default", def)
+		return def
+	}
+	return n
+}
+
+// envDuration 读取时长类型的环境变量（如 30m、24h），未设置或格式错误时返回默认值
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		logger.Warn("环境变量格式错误，使用默认值", "key", key, "default", def.String())
+		return def
 	}
+	return d
 }
@@ -1,26 +1,45 @@
 package adp
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+	"github.com/brinkmai/adp-openai-gateway/internal/metrics"
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
 	"github.com/brinkmai/adp-openai-gateway/internal/token"
 )
 
+var logger = logging.Component("adp")
+
 // Client ADP WebSocket客户端
 type Client struct {
 	tokenService    *token.Service
 	conn            *websocket.Conn
 	pendingRequests sync.Map
-	isAuthenticated bool
+	isAuthenticated atomic.Bool
+	// tokenExpire 当前连接鉴权所用Token的过期时间
+	tokenExpire     time.Time
+	// connected 是否曾经建立过连接，用于区分首次连接和重连
+	connected       bool
+	// draining 已被新连接替换、等待进行中的请求结束后关闭的旧连接
+	// drainMu 保护draining和PendingRequest.conn，与mu同时持有时先获取mu
+	draining        map[*websocket.Conn]bool
+	drainMu         sync.Mutex
 	mu              sync.Mutex
+	uploader        *Uploader
+	imageInput      bool
+	files           fileStore
+	docParseURL     string
+	wsURL           string
 }
 
 // PendingRequest 等待中的请求
@@ -31,12 +50,37 @@ type PendingRequest struct {
 	OnChunk       func(chunk Chunk)
 	FullContent   string
 	LastContent   string
+
+	// ctx 带有请求日志字段（request_id、adp_request_id、session_id）
+	ctx context.Context
+
+	// conn 发送请求的连接，停止生成需要通过同一连接发送，由Client.drainMu保护
+	conn *websocket.Conn
+
+	// recordID ADP回复的消息ID，停止生成时需要
+	recordID  string
+	recordMu  sync.Mutex
+	cancelled atomic.Bool
+
+	// 参考来源和推荐问题可能在最终回复之后才到达
+	references      []Reference
+	quotes          []QuoteInfo
+	questions       []string
+	waitRecommended bool
+	finalReceived   bool
+	refMu           sync.Mutex
+	completeOnce    sync.Once
 }
 
 // ChatResult 聊天结果
 type ChatResult struct {
-	Content   string
-	RequestID string
+	Content    string
+	RequestID  string
+	RecordID   string // ADP消息ID，评价消息时使用
+	References []Reference
+	Quotes     []QuoteInfo
+	// RecommendedQuestions 推荐的追问
+	RecommendedQuestions []string
 }
 
 // Chunk 流式数据块
@@ -44,12 +88,35 @@ type Chunk struct {
 	Type    string // content, thought, done
 	Content string
 	IsEnd   bool
+	// RecordID ADP消息ID，在content和done时携带
+	RecordID string
+
+	// done时Content为完整回复，References、Quotes 和 RecommendedQuestions 仅在done时携带
+	References           []Reference
+	Quotes               []QuoteInfo
+	RecommendedQuestions []string
 }
 
 // Message OpenAI格式消息
 type Message struct {
-	Role    string `json:"role"`
-	Content any    `json:"content"` // string 或 []ContentPart
+	Role       string     `json:"role"`
+	Content    any        `json:"content"` // string 或 []ContentPart
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // assistant发起的工具调用
+	ToolCallID string     `json:"tool_call_id,omitempty"` // role=tool时对应的调用ID
+}
+
+// ToolCall 工具调用
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 工具调用的函数及参数（JSON字符串）
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // ContentPart 多模态内容部分
@@ -57,6 +124,7 @@ type ContentPart struct {
 	Type     string    `json:"type"`
 	Text     string    `json:"text,omitempty"`
 	ImageURL *ImageURL `json:"image_url,omitempty"`
+	File     *FilePart `json:"file,omitempty"`
 }
 
 // ImageURL 图片URL
@@ -71,46 +139,101 @@ type ChatOptions struct {
 	OnChunk        func(chunk Chunk)
 	IncludeThought bool
 	Timeout        time.Duration
+	// Context 取消时通知ADP停止生成，Chat返回ctx.Err()
+	Context context.Context
+
+	// VisitorBizID 访客ID
+	VisitorBizID string
+	// CustomVariables 自定义参数，工作流和知识库检索范围可据此分支
+	CustomVariables map[string]string
+	// VisitorLabels 访客标签
+	VisitorLabels []VisitorLabel
+
+	// SearchNetwork 联网搜索开关：enable、disable，空值使用应用配置
+	SearchNetwork string
+	// ModelName 覆盖应用配置的模型
+	ModelName string
+	// StreamingThrottle 流式回复的推送频率，0使用默认值
+	StreamingThrottle int
+	// WaitRecommended 最终回复后等待推荐问题事件，应用未开启推荐问题时会增加延迟
+	WaitRecommended bool
+	// FileOwner 请求所属的API Key标识，file_id只能引用它上传的文件
+	FileOwner string
+}
+
+// VisitorLabel 访客标签
+type VisitorLabel struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
 }
 
 // NewClient 创建ADP客户端
-func NewClient(secretId, secretKey, botAppKey string) *Client {
+func NewClient(credentials tencentcloud.CredentialProvider, botAppKey string) *Client {
 	return &Client{
-		tokenService: token.NewService(secretId, secretKey, botAppKey),
+		tokenService: token.NewService(credentials, botAppKey),
+		docParseURL:  defaultDocParseURL,
+		wsURL:        defaultWSURL,
 	}
 }
 
-// ensureConnected 确保WebSocket连接
-func (c *Client) ensureConnected() error {
+// defaultWSURL ADP对话WebSocket地址
+const defaultWSURL = "wss://wss.lke.cloud.tencent.com/v1/qbot/chat/conn/?EIO=4&transport=websocket"
+
+// SetEndpoints 覆盖云API和WebSocket地址（如本地测试），需在首次请求前调用
+func (c *Client) SetEndpoints(apiEndpoint, wsURL string) {
+	c.tokenService.SetEndpoint(apiEndpoint)
+	c.wsURL = wsURL
+}
+
+// ensureConnected 确保WebSocket连接，ctx用于日志
+func (c *Client) ensureConnected(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.conn != nil && c.isAuthenticated {
-		log.Println("[ADPClient] WebSocket已连接，复用现有连接")
-		return nil
+	reason := "initial"
+	if c.connected {
+		reason = "reconnect"
+	}
+	if c.conn != nil && c.isAuthenticated.Load() {
+		reason = "token_expired"
+		if time.Now().Before(c.tokenExpire) {
+			logger.DebugContext(ctx, "复用现有WebSocket连接")
+			return nil
+		}
+		logger.InfoContext(ctx, "连接使用的Token已过期，重新鉴权")
 	}
 
-	// 关闭旧连接
+	// 新请求改用新连接，旧连接上进行中的回复不中断
 	if c.conn != nil {
-		c.conn.Close()
+		c.retire(c.conn)
 		c.conn = nil
-		c.isAuthenticated = false
+		c.isAuthenticated.Store(false)
+	}
+
+	if err := c.connect(ctx, reason); err != nil {
+		wsConnectErrors.Inc()
+		return err
 	}
+	c.connected = true
+	wsConnects.Inc(reason)
+	return nil
+}
 
-	log.Println("[ADPClient] ========== 建立WebSocket连接 ==========")
-	wsToken, err := c.tokenService.GetWsToken()
+// connect 建立WebSocket连接并完成鉴权，调用方需持有c.mu
+func (c *Client) connect(ctx context.Context, reason string) error {
+	wsToken, tokenExpire, err := c.tokenService.GetWsToken()
 	if err != nil {
 		return fmt.Errorf("获取Token失败: %w", err)
 	}
 
-	wsURL := "wss://wss.lke.cloud.tencent.com/v1/qbot/chat/conn/?EIO=4&transport=websocket"
-	log.Printf("[ADPClient] WebSocket URL: %s", wsURL)
+	logger.InfoContext(ctx, "建立WebSocket连接", "url", c.wsURL, "reason", reason)
 
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	conn, _, err := websocket.DefaultDialer.Dial(c.wsURL, nil)
 	if err != nil {
 		return fmt.Errorf("WebSocket连接失败: %w", err)
 	}
 	c.conn = conn
+	c.tokenExpire = tokenExpire
 
 	// 等待握手和鉴权
 	authDone := make(chan error, 1)
@@ -124,24 +247,24 @@ func (c *Client) ensureConnected() error {
 			}
 
 			msg := string(message)
-			log.Printf("[ADPClient] 收到消息: %s", truncate(msg, 200))
+			logger.Debug("收到消息", "frame", logging.Content(msg, 200))
 
 			if msg == "0" || strings.HasPrefix(msg, "0{") {
 				// 服务器握手响应，发送鉴权
 				authMsg := fmt.Sprintf(`40{"token":"%s"}`, wsToken)
-				log.Printf("[ADPClient] 发送鉴权消息")
+				logger.Debug("发送鉴权消息")
 				if err := conn.WriteMessage(websocket.TextMessage, []byte(authMsg)); err != nil {
 					authDone <- fmt.Errorf("发送鉴权失败: %w", err)
 					return
 				}
 			} else if msg == "40" || strings.HasPrefix(msg, "40{") {
 				// 鉴权成功
-				log.Println("[ADPClient] 鉴权成功！")
-				c.isAuthenticated = true
+				logger.InfoContext(ctx, "WebSocket鉴权成功", "token_expire", tokenExpire)
+				c.isAuthenticated.Store(true)
 				authDone <- nil
 				
 				// 继续处理后续消息
-				c.handleMessages()
+				c.handleMessages(conn)
 				return
 			} else if strings.HasPrefix(msg, "44") {
 				// 错误消息
@@ -161,27 +284,118 @@ func (c *Client) ensureConnected() error {
 }
 
 // handleMessages 处理WebSocket消息
-func (c *Client) handleMessages() {
+func (c *Client) handleMessages(conn *websocket.Conn) {
 	for {
-		_, message, err := c.conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("[ADPClient] 读取消息错误: %v", err)
-			c.isAuthenticated = false
+			c.connectionLost(conn, "read_error", err)
 			return
 		}
 
 		msg := string(message)
-		log.Printf("[ADPClient] 收到消息: %s", truncate(msg, 200))
+		logger.Debug("收到消息", "frame", logging.Content(msg, 200))
 
 		if msg == "2" {
 			// 心跳ping，回复pong
-			c.conn.WriteMessage(websocket.TextMessage, []byte("3"))
+			c.mu.Lock()
+			conn.WriteMessage(websocket.TextMessage, []byte("3"))
+			c.mu.Unlock()
 		} else if strings.HasPrefix(msg, "42") {
 			c.handleSocketIOMessage(msg)
+		} else if msg == "41" {
+			// 服务器断开（如Token失效）
+			c.connectionLost(conn, "server_disconnect", fmt.Errorf("服务器断开连接"))
+			return
+		} else if strings.HasPrefix(msg, "44") {
+			c.connectionLost(conn, "server_error", fmt.Errorf("连接错误: %s", msg[2:]))
+			return
 		}
 	}
 }
 
+// connectionLost 连接断开后重置状态，并让通过该连接发送的请求立即失败
+// 主动关闭的连接（已断开或排空的旧连接）不再处理
+func (c *Client) connectionLost(conn *websocket.Conn, kind string, reason error) {
+	c.mu.Lock()
+	current := c.conn == conn
+	if current {
+		c.conn = nil
+		c.isAuthenticated.Store(false)
+	}
+	c.mu.Unlock()
+	c.drainMu.Lock()
+	draining := c.draining[conn]
+	delete(c.draining, conn)
+	c.drainMu.Unlock()
+	conn.Close()
+
+	if current || draining {
+		logger.Warn("WebSocket连接断开", "reason", kind, "error", reason)
+		wsDisconnects.Inc(kind)
+		c.failPending(conn, fmt.Errorf("ADP连接已断开: %w", reason))
+	}
+}
+
+// failPending 以err结束通过conn发送的请求，conn为nil时结束所有等待中的请求
+func (c *Client) failPending(conn *websocket.Conn, err error) {
+	c.pendingRequests.Range(func(key, value any) bool {
+		req := value.(*PendingRequest)
+		c.drainMu.Lock()
+		match := conn == nil || req.conn == conn
+		c.drainMu.Unlock()
+		if !match {
+			return true
+		}
+		select {
+		case req.ErrorCh <- err:
+		default:
+		}
+		c.removePending(key)
+		return true
+	})
+}
+
+// retire 不再通过conn发送新请求，已发送的请求结束后关闭
+func (c *Client) retire(conn *websocket.Conn) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if !c.pendingOn(conn) {
+		conn.Close()
+		return
+	}
+	if c.draining == nil {
+		c.draining = make(map[*websocket.Conn]bool)
+	}
+	c.draining[conn] = true
+	logger.Info("旧连接在进行中的请求结束后关闭")
+}
+
+// closeDrained 关闭已没有进行中请求的旧连接
+func (c *Client) closeDrained() {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	for conn := range c.draining {
+		if !c.pendingOn(conn) {
+			delete(c.draining, conn)
+			conn.Close()
+			logger.Debug("旧连接上的请求已全部结束，关闭连接")
+		}
+	}
+}
+
+// pendingOn 是否有通过conn发送的请求仍在等待回复，调用方需持有c.drainMu
+func (c *Client) pendingOn(conn *websocket.Conn) bool {
+	pending := false
+	c.pendingRequests.Range(func(key, value any) bool {
+		if value.(*PendingRequest).conn == conn {
+			pending = true
+			return false
+		}
+		return true
+	})
+	return pending
+}
+
 // handleSocketIOMessage 处理Socket.IO格式消息
 func (c *Client) handleSocketIOMessage(msg string) {
 	content := msg[2:]
@@ -194,47 +408,105 @@ func (c *Client) handleSocketIOMessage(msg string) {
 
 	var parsed []json.RawMessage
 	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		log.Printf("[ADPClient] 解析消息失败: %v", err)
+		logger.Warn("解析消息失败", "error", err)
 		return
 	}
 
 	if len(parsed) < 2 {
-		log.Printf("[ADPClient] 消息格式不正确")
+		logger.Warn("消息格式不正确")
 		return
 	}
 
 	var eventName string
 	if err := json.Unmarshal(parsed[0], &eventName); err != nil {
-		log.Printf("[ADPClient] 解析事件名失败: %v", err)
+		logger.Warn("解析事件名失败", "error", err)
 		return
 	}
 
-	log.Printf("[ADPClient] 收到事件[%s]: %s", eventName, truncate(string(parsed[1]), 200))
+	logger.Debug("收到事件", "event", eventName, "data", logging.Content(string(parsed[1]), 200))
 
 	switch eventName {
 	case "reply":
 		c.handleEvent("reply", parsed[1])
 	case "thought":
 		c.handleEvent("thought", parsed[1])
+	case "reference":
+		c.handleReference(parsed[1])
+	case "recommended_question":
+		c.handleRecommendedQuestion(parsed[1])
 	case "error":
-		log.Printf("[ADPClient] 服务器返回错误: %s", string(parsed[1]))
+		logger.Warn("服务器返回错误", "data", string(parsed[1]))
+		metrics.UpstreamError("adp", errorCode(parsed[1]))
+		c.handleError(parsed[1])
 	}
 }
 
+// handleError 以ADP返回的错误结束对应的请求，未携带request_id时无法确定请求，只记录日志
+func (c *Client) handleError(data json.RawMessage) {
+	var wrapper struct {
+		Message string `json:"message"`
+		Error   *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Payload struct {
+			RequestID string `json:"request_id"`
+			Message   string `json:"message"`
+			Error     *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil || wrapper.Payload.RequestID == "" {
+		return
+	}
+
+	requestID := wrapper.Payload.RequestID
+	v, ok := c.pendingRequests.Load(requestID)
+	if !ok {
+		logger.Debug("未找到错误对应的请求", "adp_request_id", requestID)
+		return
+	}
+	req := v.(*PendingRequest)
+
+	message := "未知错误"
+	switch {
+	case wrapper.Payload.Error != nil && wrapper.Payload.Error.Message != "":
+		message = wrapper.Payload.Error.Message
+	case wrapper.Payload.Message != "":
+		message = wrapper.Payload.Message
+	case wrapper.Error != nil && wrapper.Error.Message != "":
+		message = wrapper.Error.Message
+	case wrapper.Message != "":
+		message = wrapper.Message
+	}
+
+	// 已取消的请求不会再收到最终回复，直接清理
+	if !req.cancelled.Load() {
+		select {
+		case req.ErrorCh <- fmt.Errorf("ADP返回错误(%s): %s", errorCode(data), message):
+		default:
+		}
+	}
+	c.removePending(requestID)
+}
+
 // handleEvent 处理ADP事件
 func (c *Client) handleEvent(eventType string, data json.RawMessage) {
 	var wrapper struct {
 		Payload struct {
 			RequestID string `json:"request_id"`
+			RecordID  string `json:"record_id"`
 			Content   string `json:"content"`
 			CanRating bool   `json:"can_rating"`
 			IsFinal   bool   `json:"is_final"`
 			Thought   string `json:"thought"`
+
+			QuoteInfos []QuoteInfo `json:"quote_infos"`
 		} `json:"payload"`
 	}
 
 	if err := json.Unmarshal(data, &wrapper); err != nil {
-		log.Printf("[ADPClient] 解析事件数据失败: %v", err)
+		logger.Warn("解析事件数据失败", "error", err)
 		return
 	}
 
@@ -248,7 +520,7 @@ func (c *Client) handleEvent(eventType string, data json.RawMessage) {
 	} else {
 		// 如果找不到，尝试用第一个pending request
 		c.pendingRequests.Range(func(key, value any) bool {
-			log.Printf("[ADPClient] 使用fallback请求: %v", key)
+			logger.Debug("使用fallback请求", "adp_request_id", key)
 			req = value.(*PendingRequest)
 			requestID = key.(string)
 			return false
@@ -256,21 +528,35 @@ func (c *Client) handleEvent(eventType string, data json.RawMessage) {
 	}
 
 	if req == nil {
-		log.Printf("[ADPClient] 未找到请求: %s", payload.RequestID)
+		logger.Debug("未找到请求", "adp_request_id", payload.RequestID)
+		return
+	}
+
+	// 已取消的请求丢弃后续事件，收到最终回复后清理
+	if req.cancelled.Load() {
+		if payload.IsFinal {
+			c.removePending(requestID)
+		}
 		return
 	}
 
-	log.Printf("[ADPClient] 处理事件: %s, can_rating: %v, is_final: %v, content长度: %d",
-		eventType, payload.CanRating, payload.IsFinal, len(payload.Content))
+	logger.DebugContext(req.ctx, "处理事件", "event", eventType,
+		"can_rating", payload.CanRating, "is_final", payload.IsFinal, "content_length", len(payload.Content))
 
 	switch eventType {
 	case "reply":
 		// 忽略 can_rating=false 的回显消息
 		if !payload.CanRating {
-			log.Println("[ADPClient] 跳过回显消息（can_rating=false）")
+			logger.DebugContext(req.ctx, "跳过回显消息（can_rating=false）")
 			return
 		}
 
+		if payload.RecordID != "" {
+			req.recordMu.Lock()
+			req.recordID = payload.RecordID
+			req.recordMu.Unlock()
+		}
+
 		if req.Stream && req.OnChunk != nil {
 			if payload.Content != "" && payload.Content != req.LastContent {
 				newContent := payload.Content
@@ -279,11 +565,12 @@ func (c *Client) handleEvent(eventType string, data json.RawMessage) {
 				}
 				
 				if newContent != "" {
-					log.Printf("[ADPClient] 发送增量内容: %s...", truncate(newContent, 50))
+					logger.DebugContext(req.ctx, "发送增量内容", "content", logging.Content(newContent, 50))
 					req.OnChunk(Chunk{
-						Type:    "content",
-						Content: newContent,
-						IsEnd:   payload.IsFinal,
+						Type:     "content",
+						Content:  newContent,
+						IsEnd:    payload.IsFinal,
+						RecordID: payload.RecordID,
 					})
 				}
 				req.LastContent = payload.Content
@@ -293,20 +580,29 @@ func (c *Client) handleEvent(eventType string, data json.RawMessage) {
 		if payload.Content != "" {
 			req.FullContent = payload.Content
 		}
+		if len(payload.QuoteInfos) > 0 {
+			req.refMu.Lock()
+			req.quotes = payload.QuoteInfos
+			req.refMu.Unlock()
+		}
 
 		// can_rating=true 且 is_final=true 时结束
 		if payload.CanRating && payload.IsFinal {
-			log.Printf("[ADPClient] 收到最终响应，完成请求，内容: %s...", truncate(payload.Content, 50))
-			
-			if !req.Stream {
-				req.ResultCh <- &ChatResult{
-					Content:   req.FullContent,
-					RequestID: requestID,
-				}
-			} else if req.OnChunk != nil {
-				req.OnChunk(Chunk{Type: "done"})
+			logger.InfoContext(req.ctx, "收到最终回复", "content", logging.Content(payload.Content, 50))
+
+			// 参考来源或推荐问题尚未到达时稍作等待
+			req.refMu.Lock()
+			req.finalReceived = true
+			wait := req.extrasWait()
+			req.refMu.Unlock()
+			if wait > 0 {
+				logger.DebugContext(req.ctx, "等待参考来源或推荐问题")
+				time.AfterFunc(wait, func() {
+					c.complete(requestID, req)
+				})
+				return
 			}
-			c.pendingRequests.Delete(requestID)
+			c.complete(requestID, req)
 		}
 
 	case "thought":
@@ -321,68 +617,100 @@ func (c *Client) handleEvent(eventType string, data json.RawMessage) {
 
 // Chat 发送聊天请求
 func (c *Client) Chat(messages []Message, opts ChatOptions) (*ChatResult, error) {
-	if err := c.ensureConnected(); err != nil {
-		return nil, err
-	}
-
+	// 获取最后一条消息内容（图片会先上传，校验失败时不必建立连接）
+	lastMsg := messages[len(messages)-1]
 	requestID := uuid.New().String()
 	sessionID := opts.SessionID
 	if sessionID == "" {
 		sessionID = uuid.New().String()
 	}
 
-	// 获取最后一条消息内容
-	lastMsg := messages[len(messages)-1]
-	var content string
-	
-	switch v := lastMsg.Content.(type) {
-	case string:
-		content = v
-	case []interface{}:
-		for _, part := range v {
-			if p, ok := part.(map[string]interface{}); ok {
-				if p["type"] == "text" {
-					if text, ok := p["text"].(string); ok {
-						content += text
-					}
-				}
-			}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = logging.With(ctx, "adp_request_id", requestID, "session_id", sessionID)
+
+	content, files, err := c.buildContent(ctx, opts.FileOwner, lastMsg.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.ensureConnected(ctx); err != nil {
+		return nil, err
+	}
+
+	// 文档需要在当前会话中解析后才能通过file_infos引用
+	fileInfos := make([]*FileInfo, 0, len(files))
+	for _, f := range files {
+		info, err := c.parseDocument(ctx, f, sessionID)
+		if err != nil {
+			return nil, err
 		}
+		fileInfos = append(fileInfos, info)
 	}
 
 	// 构建消息
+	sendPayload := map[string]interface{}{
+		"session_id": sessionID,
+		"request_id": requestID,
+		"content":    content,
+	}
+	if len(fileInfos) > 0 {
+		sendPayload["file_infos"] = fileInfos
+	}
+	if opts.VisitorBizID != "" {
+		sendPayload["visitor_biz_id"] = opts.VisitorBizID
+	}
+	if len(opts.CustomVariables) > 0 {
+		sendPayload["custom_variables"] = opts.CustomVariables
+	}
+	if len(opts.VisitorLabels) > 0 {
+		sendPayload["visitor_labels"] = opts.VisitorLabels
+	}
+	if opts.SearchNetwork != "" {
+		sendPayload["search_network"] = opts.SearchNetwork
+	}
+	if opts.ModelName != "" {
+		sendPayload["model_name"] = opts.ModelName
+	}
+	if opts.StreamingThrottle > 0 {
+		sendPayload["streaming_throttle"] = opts.StreamingThrottle
+	}
 	payload := map[string]interface{}{
-		"payload": map[string]interface{}{
-			"session_id": sessionID,
-			"request_id": requestID,
-			"content":    content,
-		},
+		"payload": sendPayload,
 	}
 
-	log.Println("[ADPClient] ========== 发送聊天请求 ==========")
-	log.Printf("[ADPClient] request_id: %s", requestID)
-	log.Printf("[ADPClient] session_id: %s", sessionID)
-	log.Printf("[ADPClient] 消息内容: %s", truncate(content, 100))
-	log.Printf("[ADPClient] Stream模式: %v", opts.Stream)
+	logger.InfoContext(ctx, "发送聊天请求", "stream", opts.Stream, "content", logging.Content(content, 100))
 
 	req := &PendingRequest{
 		ResultCh: make(chan *ChatResult, 1),
 		ErrorCh:  make(chan error, 1),
 		Stream:   opts.Stream,
 		OnChunk:  opts.OnChunk,
+		ctx:      ctx,
+
+		waitRecommended: opts.WaitRecommended,
 	}
-	c.pendingRequests.Store(requestID, req)
+	c.addPending(requestID, req)
 
 	// 发送消息
 	payloadBytes, _ := json.Marshal(payload)
 	msg := fmt.Sprintf(`42["send",%s]`, string(payloadBytes))
-	log.Printf("[ADPClient] 发送消息: %s", truncate(msg, 250))
+	logger.DebugContext(ctx, "发送消息", "frame", logging.Content(msg, 250))
 
 	c.mu.Lock()
-	err := c.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	if c.conn == nil {
+		err = fmt.Errorf("连接已断开")
+	} else {
+		c.drainMu.Lock()
+		req.conn = c.conn
+		c.drainMu.Unlock()
+		err = c.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	}
 	c.mu.Unlock()
 	if err != nil {
-		c.pendingRequests.Delete(requestID)
+		c.removePending(requestID)
 		return nil, fmt.Errorf("发送消息失败: %w", err)
 	}
 
@@ -392,23 +720,19 @@ func (c *Client) Chat(messages []Message, opts ChatOptions) (*ChatResult, error)
 		timeout = 120 * time.Second
 	}
 
-	if opts.Stream {
-		// 流式模式，等待done
+	// 流式模式在done之后同样会收到结果
+	select {
+	case <-time.After(timeout):
+		c.removePending(requestID)
+		return nil, fmt.Errorf("请求超时")
+	case <-ctx.Done():
 		select {
-		case <-time.After(timeout):
-			c.pendingRequests.Delete(requestID)
-			return nil, fmt.Errorf("请求超时")
-		case err := <-req.ErrorCh:
-			return nil, err
 		case result := <-req.ResultCh:
 			return result, nil
+		default:
 		}
-	}
-
-	select {
-	case <-time.After(timeout):
-		c.pendingRequests.Delete(requestID)
-		return nil, fmt.Errorf("请求超时")
+		c.cancelRequest(requestID, req)
+		return nil, ctx.Err()
 	case err := <-req.ErrorCh:
 		return nil, err
 	case result := <-req.ResultCh:
@@ -416,15 +740,118 @@ func (c *Client) Chat(messages []Message, opts ChatOptions) (*ChatResult, error)
 	}
 }
 
-// Disconnect 断开连接
-func (c *Client) Disconnect() {
+// cancelRequest 取消请求并通知ADP停止生成
+func (c *Client) cancelRequest(requestID string, req *PendingRequest) {
+	req.cancelled.Store(true)
+
+	// 最终回复可能不再到达，超时后兜底清理
+	time.AfterFunc(30*time.Second, func() {
+		c.removePending(requestID)
+	})
+
+	req.recordMu.Lock()
+	recordID := req.recordID
+	req.recordMu.Unlock()
+	if recordID == "" {
+		logger.InfoContext(req.ctx, "请求已取消，尚未收到回复")
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"payload": map[string]interface{}{
+			"record_id": recordID,
+		},
+	})
+	msg := fmt.Sprintf(`42["stop_generation",%s]`, string(payload))
+	logger.InfoContext(req.ctx, "停止生成", "record_id", recordID)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
-	if c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
-		c.isAuthenticated = false
+	c.drainMu.Lock()
+	conn := req.conn
+	open := conn != nil && (conn == c.conn || c.draining[conn])
+	c.drainMu.Unlock()
+	if !open {
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		logger.WarnContext(req.ctx, "发送停止生成失败", "error", err)
+	}
+}
+
+// buildContent 将消息内容转换为ADP的content字符串，并返回其中引用的文档
+func (c *Client) buildContent(ctx context.Context, owner string, content any) (string, []*File, error) {
+	var parts []ContentPart
+	switch v := content.(type) {
+	case string:
+		return v, nil, nil
+	case []ContentPart:
+		parts = v
+	case []interface{}:
+		// 通过JSON往返转换为结构化的ContentPart
+		raw, _ := json.Marshal(v)
+		if err := json.Unmarshal(raw, &parts); err != nil {
+			return "", nil, &InputError{Message: "invalid content parts"}
+		}
+	}
+
+	var sb strings.Builder
+	var files []*File
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			sb.WriteString(part.Text)
+		case "image_url":
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				return "", nil, &InputError{Message: "image_url.url is required"}
+			}
+			imageURL, err := c.uploadImage(ctx, part.ImageURL.URL)
+			if err != nil {
+				return "", nil, err
+			}
+			// ADP多模态应用通过content中的markdown图片读取图片
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(fmt.Sprintf("![](%s)\n", imageURL))
+		case "file":
+			f, err := c.resolveFile(ctx, owner, part.File)
+			if err != nil {
+				return "", nil, err
+			}
+			files = append(files, f)
+		}
+	}
+	return sb.String(), files, nil
+}
+
+// TokenService 返回客户端使用的Token服务
+func (c *Client) TokenService() *token.Service {
+	return c.tokenService
+}
+
+// Disconnect 断开连接并停止Token后台刷新
+func (c *Client) Disconnect() {
+	c.tokenService.Stop()
+
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.isAuthenticated.Store(false)
+	c.mu.Unlock()
+	c.drainMu.Lock()
+	draining := c.draining
+	c.draining = nil
+	c.drainMu.Unlock()
+
+	for old := range draining {
+		old.Close()
+	}
+	if conn != nil || len(draining) > 0 {
+		if conn != nil {
+			conn.Close()
+		}
+		c.failPending(nil, fmt.Errorf("ADP连接已断开"))
 	}
 }
 
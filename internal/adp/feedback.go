@@ -0,0 +1,31 @@
+package adp
+
+import (
+	"context"
+	"fmt"
+)
+
+// 消息评价分数
+const (
+	RatingUp   = 1
+	RatingDown = 2
+)
+
+// RateMessage 评价ADP回复，评价会同步到ADP控制台
+// reasons仅在点踩时有效
+func (c *Client) RateMessage(ctx context.Context, recordID string, score int, reasons []string) error {
+	payload := map[string]interface{}{
+		"BotAppKey": c.tokenService.BotAppKey(),
+		"RecordId":  recordID,
+		"Score":     score,
+	}
+	if score == RatingDown && len(reasons) > 0 {
+		payload["Reasons"] = reasons
+	}
+
+	logger.InfoContext(ctx, "评价消息", "record_id", recordID, "score", score)
+	if err := c.tokenService.Call(ctx, "RateMsgRecord", payload, nil); err != nil {
+		return fmt.Errorf("评价消息失败: %w", err)
+	}
+	return nil
+}
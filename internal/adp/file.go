@@ -0,0 +1,378 @@
+package adp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxFileSize 单个文档大小上限
+const MaxFileSize = 50 << 20
+
+// defaultDocParseURL ADP实时文档解析接口
+const defaultDocParseURL = "https://wss.lke.cloud.tencent.com/v1/qbot/chat/docParse"
+
+// documentTypes 支持的文档后缀及上传时使用的Content-Type
+var documentTypes = map[string]string{
+	"pdf":  "application/pdf",
+	"doc":  "application/msword",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"xls":  "application/vnd.ms-excel",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"ppt":  "application/vnd.ms-powerpoint",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"txt":  "text/plain",
+	"md":   "text/markdown",
+	"csv":  "text/csv",
+}
+
+// 文件记录只保存在内存中，按数量和时间限制，避免无限增长
+const (
+	// fileTTL 上传的文件保留多久
+	fileTTL = 24 * time.Hour
+	// maxFilesPerOwner 每个API Key最多保留的文件数
+	maxFilesPerOwner = 100
+	// maxFiles 所有API Key合计最多保留的文件数
+	maxFiles = 10000
+)
+
+// ErrFileNotSupported 网关未配置文件上传
+var ErrFileNotSupported = errors.New("file inputs are not enabled on this gateway")
+
+// FileInfo send载荷中file_infos的元素
+type FileInfo struct {
+	FileName string `json:"file_name"`
+	FileSize string `json:"file_size"`
+	FileURL  string `json:"file_url"`
+	FileType string `json:"file_type"`
+	DocID    string `json:"doc_id"`
+}
+
+// File 已上传到ADP存储的文档
+type File struct {
+	ID        string
+	Name      string
+	Type      string
+	Purpose   string
+	CreatedAt int64
+	Upload    *UploadResult
+	// Owner 上传文件的API Key标识，只有同一个Key可以访问
+	Owner     string
+	ExpiresAt time.Time
+
+	// docIDs 文档解析结果与会话绑定，session_id -> doc_id
+	docIDs sync.Map
+}
+
+// FilePart 内容中的文件引用，file_id与file_data二选一
+type FilePart struct {
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"` // data URL
+	Filename string `json:"filename,omitempty"`
+}
+
+// SetUploader 设置上传器，图片和文档输入都依赖它
+func (c *Client) SetUploader(uploader *Uploader) {
+	c.uploader = uploader
+}
+
+// fileStore 按API Key隔离的文件记录
+type fileStore struct {
+	mu    sync.Mutex
+	files map[string]*File
+}
+
+// add 保存文件，owner的文件数或总数达到上限时返回错误
+func (s *fileStore) add(f *File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string]*File)
+	}
+	s.purge()
+
+	if len(s.files) >= maxFiles {
+		return errors.New("文件数已达上限")
+	}
+	owned := 0
+	for _, other := range s.files {
+		if other.Owner == f.Owner {
+			owned++
+		}
+	}
+	if owned >= maxFilesPerOwner {
+		return &InputError{Message: fmt.Sprintf("file limit of %d reached, delete unused files first", maxFilesPerOwner)}
+	}
+	s.files[f.ID] = f
+	return nil
+}
+
+// get 查找owner的有效文件
+func (s *fileStore) get(owner, id string) (*File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok || f.Owner != owner {
+		return nil, false
+	}
+	if time.Now().After(f.ExpiresAt) {
+		delete(s.files, id)
+		return nil, false
+	}
+	return f, true
+}
+
+// list 列出owner的有效文件，按上传时间排序
+func (s *fileStore) list(owner string) []*File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purge()
+	var files []*File
+	for _, f := range s.files {
+		if f.Owner == owner {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt < files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files
+}
+
+// remove 删除owner的文件
+func (s *fileStore) remove(owner, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok || f.Owner != owner {
+		return false
+	}
+	delete(s.files, id)
+	return true
+}
+
+// purge 清理过期文件，调用方需持有锁
+func (s *fileStore) purge() {
+	now := time.Now()
+	for id, f := range s.files {
+		if now.After(f.ExpiresAt) {
+			delete(s.files, id)
+		}
+	}
+}
+
+// UploadFile 校验并上传文档，返回owner可在后续请求中通过ID引用的文件
+func (c *Client) UploadFile(ctx context.Context, owner, name string, data []byte, purpose string) (*File, error) {
+	f, err := c.uploadDocument(ctx, name, data, purpose)
+	if err != nil {
+		return nil, err
+	}
+	f.Owner = owner
+	f.ExpiresAt = time.Now().Add(fileTTL)
+	if err := c.files.add(f); err != nil {
+		return nil, err
+	}
+
+	logger.InfoContext(ctx, "文件上传成功", "file_id", f.ID, "name", name)
+	return f, nil
+}
+
+// uploadDocument 校验并上传文档，不保存文件记录
+func (c *Client) uploadDocument(ctx context.Context, name string, data []byte, purpose string) (*File, error) {
+	if c.uploader == nil {
+		return nil, ErrFileNotSupported
+	}
+
+	if len(data) == 0 {
+		return nil, &InputError{Message: "file is empty"}
+	}
+	if len(data) > MaxFileSize {
+		return nil, &InputError{Message: fmt.Sprintf("file exceeds the %d MB size limit", MaxFileSize>>20)}
+	}
+
+	fileType := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	contentType, ok := documentTypes[fileType]
+	if !ok {
+		return nil, &InputError{Message: fmt.Sprintf("unsupported file type %q, expected pdf, doc(x), xls(x), ppt(x), txt, md or csv", fileType)}
+	}
+
+	result, err := c.uploader.Upload(ctx, data, fileType, contentType, false)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		ID:        "file-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Name:      name,
+		Type:      fileType,
+		Purpose:   purpose,
+		CreatedAt: time.Now().Unix(),
+		Upload:    result,
+	}
+	return f, nil
+}
+
+// GetFile 按ID查找owner上传的文件
+func (c *Client) GetFile(owner, id string) (*File, bool) {
+	return c.files.get(owner, id)
+}
+
+// ListFiles 列出owner上传的文件
+func (c *Client) ListFiles(owner string) []*File {
+	return c.files.list(owner)
+}
+
+// DeleteFile 删除owner的文件记录，COS上的对象由ADP按生命周期清理
+func (c *Client) DeleteFile(owner, id string) bool {
+	return c.files.remove(owner, id)
+}
+
+// resolveFile 将内容中的文件引用解析为已上传的文件
+// file_id只能引用owner上传的文件，file_data只在本次请求中使用，不保存文件记录
+func (c *Client) resolveFile(ctx context.Context, owner string, part *FilePart) (*File, error) {
+	if part == nil {
+		return nil, &InputError{Message: "file is required"}
+	}
+
+	if part.FileID != "" {
+		f, ok := c.GetFile(owner, part.FileID)
+		if !ok {
+			return nil, &InputError{Message: fmt.Sprintf("file %q not found", part.FileID)}
+		}
+		return f, nil
+	}
+
+	if part.FileData == "" {
+		return nil, &InputError{Message: "file.file_id or file.file_data is required"}
+	}
+	comma := strings.Index(part.FileData, ",")
+	if !strings.HasPrefix(part.FileData, "data:") || comma < 0 || !strings.HasSuffix(part.FileData[:comma], ";base64") {
+		return nil, &InputError{Message: "file.file_data must be a base64 data URL"}
+	}
+	data, err := base64.StdEncoding.DecodeString(part.FileData[comma+1:])
+	if err != nil {
+		return nil, &InputError{Message: "invalid base64 file data"}
+	}
+	if part.Filename == "" {
+		return nil, &InputError{Message: "file.filename is required with file_data"}
+	}
+	return c.uploadDocument(ctx, part.Filename, data, "user_data")
+}
+
+// parseDocument 在会话中解析文档，返回send载荷使用的file_info
+func (c *Client) parseDocument(ctx context.Context, f *File, sessionID string) (*FileInfo, error) {
+	info := &FileInfo{
+		FileName: f.Name,
+		FileSize: strconv.Itoa(f.Upload.Size),
+		FileURL:  f.Upload.URL,
+		FileType: f.Type,
+	}
+
+	if v, ok := f.docIDs.Load(sessionID); ok {
+		info.DocID = v.(string)
+		return info, nil
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"session_id":  sessionID,
+		"request_id":  uuid.New().String(),
+		"bot_app_key": c.tokenService.BotAppKey(),
+		"cos_bucket":  f.Upload.Bucket,
+		"file_type":   f.Type,
+		"file_name":   f.Name,
+		"cos_url":     f.Upload.Key,
+		"cos_hash":    f.Upload.Hash,
+		"e_tag":       f.Upload.ETag,
+		"size":        strconv.Itoa(f.Upload.Size),
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.docParseURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("创建解析请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	logger.InfoContext(ctx, "解析文档", "file_id", f.ID)
+
+	client := &http.Client{Timeout: 3 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("文档解析请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("文档解析请求失败: HTTP %d", resp.StatusCode)
+	}
+
+	// 解析进度以SSE推送，直到返回doc_id或失败
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var event struct {
+			Type    string `json:"type"`
+			Payload struct {
+				DocID        string `json:"doc_id"`
+				Status       string `json:"status"`
+				ErrorMessage string `json:"error_message"`
+				Progress     struct {
+					Progress int `json:"progress"`
+				} `json:"progress"`
+			} `json:"payload"`
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line[5:])), &event); err != nil {
+			logger.WarnContext(ctx, "解析文档进度失败", "error", err)
+			continue
+		}
+
+		if event.Error != nil {
+			return nil, fmt.Errorf("文档解析失败: %d - %s", event.Error.Code, event.Error.Message)
+		}
+
+		payload := event.Payload
+		switch payload.Status {
+		case "FAILED", "CANCELLED":
+			return nil, &InputError{Message: fmt.Sprintf("failed to parse document %q: %s", f.Name, payload.ErrorMessage)}
+		case "SUCCESS":
+			if payload.DocID != "" {
+				f.docIDs.Store(sessionID, payload.DocID)
+				info.DocID = payload.DocID
+				logger.InfoContext(ctx, "文档解析完成", "file_id", f.ID, "doc_id", payload.DocID)
+				return info, nil
+			}
+		default:
+			logger.DebugContext(ctx, "文档解析进度", "file_id", f.ID, "progress", payload.Progress.Progress)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取解析结果失败: %w", err)
+	}
+	return nil, fmt.Errorf("文档解析未返回结果")
+}
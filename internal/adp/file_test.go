@@ -0,0 +1,78 @@
+package adp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestFile(id, owner string, expiresAt time.Time) *File {
+	return &File{ID: id, Owner: owner, ExpiresAt: expiresAt, Upload: &UploadResult{}}
+}
+
+func TestFileStoreOwnerIsolation(t *testing.T) {
+	var s fileStore
+	expires := time.Now().Add(time.Hour)
+	for _, f := range []*File{newTestFile("file-a", "key:a", expires), newTestFile("file-b", "key:b", expires)} {
+		if err := s.add(f); err != nil {
+			t.Fatalf("add %s: %v", f.ID, err)
+		}
+	}
+
+	if _, ok := s.get("key:a", "file-a"); !ok {
+		t.Error("owner cannot get its own file")
+	}
+	if _, ok := s.get("key:b", "file-a"); ok {
+		t.Error("other owner can get the file")
+	}
+	if files := s.list("key:a"); len(files) != 1 || files[0].ID != "file-a" {
+		t.Errorf("list(key:a) = %v", files)
+	}
+	if s.remove("key:b", "file-a") {
+		t.Error("other owner can delete the file")
+	}
+	if !s.remove("key:a", "file-a") {
+		t.Error("owner cannot delete its own file")
+	}
+	if _, ok := s.get("key:a", "file-a"); ok {
+		t.Error("deleted file is still returned")
+	}
+}
+
+func TestFileStoreExpiry(t *testing.T) {
+	var s fileStore
+	if err := s.add(newTestFile("file-old", "", time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.get("", "file-old"); ok {
+		t.Error("expired file is returned")
+	}
+	if len(s.files) != 0 {
+		t.Errorf("expired file was not removed, %d left", len(s.files))
+	}
+}
+
+func TestFileStoreLimits(t *testing.T) {
+	var s fileStore
+	expires := time.Now().Add(time.Hour)
+	for i := 0; i < maxFilesPerOwner; i++ {
+		if err := s.add(newTestFile(fmt.Sprintf("file-%d", i), "key:a", expires)); err != nil {
+			t.Fatalf("add %d: %v", i, err)
+		}
+	}
+
+	var inputErr *InputError
+	if err := s.add(newTestFile("file-extra", "key:a", expires)); !errors.As(err, &inputErr) {
+		t.Fatalf("add over owner limit: err = %v, want InputError", err)
+	}
+	if err := s.add(newTestFile("file-other", "key:b", expires)); err != nil {
+		t.Fatalf("other owner is limited: %v", err)
+	}
+
+	// 过期文件不占用名额
+	s.files["file-0"].ExpiresAt = time.Now().Add(-time.Second)
+	if err := s.add(newTestFile("file-extra", "key:a", expires)); err != nil {
+		t.Fatalf("add after expiry: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package adp
+
+import (
+	"context"
+	"fmt"
+)
+
+// msgRecordTypeAPI GetMsgRecord的Type：API访客
+const msgRecordTypeAPI = 5
+
+// MessageRecord ADP会话中的一条消息记录
+type MessageRecord struct {
+	RecordID   string `json:"RecordId"`
+	SessionID  string `json:"SessionId"`
+	Content    string `json:"Content"`
+	IsFromSelf bool   `json:"IsFromSelf"` // true 为访客发送，false 为应用回复
+	FromName   string `json:"FromName"`
+	Timestamp  string `json:"Timestamp"` // 秒级时间戳
+	Score      int    `json:"Score"`     // 评价：0 未评价，1 点赞，2 点踩
+}
+
+// MessageRecords 查询会话的消息记录
+// lastRecordID为空时从最新的消息开始，否则返回该记录之前的消息
+func (c *Client) MessageRecords(ctx context.Context, sessionID string, count int, lastRecordID string) ([]MessageRecord, error) {
+	payload := map[string]interface{}{
+		"Type":      msgRecordTypeAPI,
+		"Count":     count,
+		"SessionId": sessionID,
+		"BotAppKey": c.tokenService.BotAppKey(),
+	}
+	if lastRecordID != "" {
+		payload["LastRecordId"] = lastRecordID
+	}
+
+	var result struct {
+		Records []MessageRecord `json:"Records"`
+	}
+	if err := c.tokenService.Call(ctx, "GetMsgRecord", payload, &result); err != nil {
+		return nil, fmt.Errorf("查询消息记录失败: %w", err)
+	}
+
+	logger.InfoContext(ctx, "查询消息记录", "session_id", sessionID, "count", len(result.Records))
+	return result.Records, nil
+}
@@ -0,0 +1,179 @@
+package adp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxImageSize 单张图片大小上限
+const maxImageSize = 10 << 20
+
+// imageTypes 支持的图片类型及对应的文件后缀
+var imageTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+	"image/bmp":  "bmp",
+}
+
+// ErrImageNotSupported 当前应用未开启图片输入
+var ErrImageNotSupported = errors.New("image inputs are not supported by this model")
+
+// InputError 请求内容不合法，Message会直接返回给调用方
+type InputError struct {
+	Message string
+}
+
+func (e *InputError) Error() string {
+	return e.Message
+}
+
+// EnableImageInput 开启图片输入，图片会通过上传器上传后以markdown形式发送给ADP
+func (c *Client) EnableImageInput() {
+	c.imageInput = true
+}
+
+// uploadImage 读取并校验图片，上传到ADP存储后返回URL
+func (c *Client) uploadImage(ctx context.Context, rawURL string) (string, error) {
+	if !c.imageInput || c.uploader == nil {
+		return "", ErrImageNotSupported
+	}
+
+	data, err := loadImage(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > maxImageSize {
+		return "", &InputError{Message: fmt.Sprintf("image exceeds the %d MB size limit", maxImageSize>>20)}
+	}
+
+	contentType := http.DetectContentType(data)
+	fileType, ok := imageTypes[contentType]
+	if !ok {
+		return "", &InputError{Message: fmt.Sprintf("unsupported image type %q, expected png, jpeg, gif, webp or bmp", contentType)}
+	}
+
+	result, err := c.uploader.Upload(ctx, data, fileType, contentType, true)
+	if err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+// loadImage 解析data URL或下载http(s)图片
+func loadImage(ctx context.Context, rawURL string) ([]byte, error) {
+	if strings.HasPrefix(rawURL, "data:") {
+		comma := strings.Index(rawURL, ",")
+		if comma < 0 || !strings.HasSuffix(rawURL[:comma], ";base64") {
+			return nil, &InputError{Message: "image data URL must be base64 encoded"}
+		}
+		data, err := base64.StdEncoding.DecodeString(rawURL[comma+1:])
+		if err != nil {
+			return nil, &InputError{Message: "invalid base64 image data"}
+		}
+		return data, nil
+	}
+
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		return nil, &InputError{Message: "image_url must be a data URL or an http(s) URL"}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, &InputError{Message: "invalid image_url"}
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		// 不把上游的错误细节返回给客户端，避免借此探测内网
+		logger.WarnContext(ctx, "下载图片失败", "url", rawURL, "error", err)
+		if errors.Is(err, errBlockedAddress) {
+			return nil, &InputError{Message: "image_url must point to a public address"}
+		}
+		return nil, &InputError{Message: "failed to download image"}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.WarnContext(ctx, "下载图片失败", "url", rawURL, "status", resp.StatusCode)
+		return nil, &InputError{Message: "failed to download image"}
+	}
+
+	// 多读一个字节用于判断是否超限
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取图片失败: %w", err)
+	}
+	return data, nil
+}
+
+// maxImageRedirects 下载图片时最多跟随的重定向次数
+const maxImageRedirects = 3
+
+// errBlockedAddress 图片地址解析到了非公网地址
+var errBlockedAddress = errors.New("目标地址不是公网地址")
+
+// imageClient 下载客户端提供的图片URL
+// 在建立连接时检查解析后的IP，重定向和DNS变化同样受限，防止访问元数据服务和内网
+var imageClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		// 不走代理，否则检查的是代理地址
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				return checkPublicAddress(address)
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxImageRedirects {
+			return errors.New("重定向次数过多")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("不支持重定向到 %s", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// checkPublicAddress 拒绝回环、私有、链路本地（含元数据服务169.254.0.0/16）等非公网地址
+func checkPublicAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+	}
+	return nil
+}
+
+// sharedAddressSpace 运营商级NAT地址段 100.64.0.0/10，部分云厂商用于内部服务
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() &&
+		!ip.IsPrivate() &&
+		!ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,84 @@
+package adp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{"8.8.8.8:443", true},
+		{"[2001:4860:4860::8888]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"10.0.0.8:80", false},
+		{"172.16.5.4:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.0.23:80", false}, // metadata.tencentyun.com
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:80", false},
+		{"0.0.0.0:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[fe80::1]:80", false},
+		{"[fd00::1]:80", false},
+		{"224.0.0.1:80", false},
+	}
+	for _, tt := range tests {
+		err := checkPublicAddress(tt.address)
+		if public := err == nil; public != tt.public {
+			t.Errorf("checkPublicAddress(%s) = %v, want public=%v", tt.address, err, tt.public)
+		}
+		if err != nil && !errors.Is(err, errBlockedAddress) {
+			t.Errorf("checkPublicAddress(%s) error %v is not errBlockedAddress", tt.address, err)
+		}
+	}
+}
+
+func TestLoadImageRejectsInternalHosts(t *testing.T) {
+	var hits int
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+
+	_, err := loadImage(context.Background(), internal.URL+"/latest/meta-data/")
+	var inputErr *InputError
+	if !errors.As(err, &inputErr) || inputErr.Message != "image_url must point to a public address" {
+		t.Fatalf("err = %v, want blocked address input error", err)
+	}
+	if hits != 0 {
+		t.Fatalf("internal server was reached %d times", hits)
+	}
+}
+
+func TestLoadImageDataURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr string
+	}{
+		{url: "data:image/png;base64,aGVsbG8=", want: "hello"},
+		{url: "data:image/png,hello", wantErr: "image data URL must be base64 encoded"},
+		{url: "data:image/png;base64,!!", wantErr: "invalid base64 image data"},
+		{url: "file:///etc/passwd", wantErr: "image_url must be a data URL or an http(s) URL"},
+	}
+	for _, tt := range tests {
+		data, err := loadImage(context.Background(), tt.url)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("loadImage(%q) err = %v, want %q", tt.url, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || string(data) != tt.want {
+			t.Errorf("loadImage(%q) = %q, %v", tt.url, data, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package adp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/metrics"
+)
+
+var (
+	pendingGauge = metrics.NewGauge("adp_gateway_adp_pending_requests",
+		"ADP chat requests waiting for a reply.")
+	wsConnects = metrics.NewCounter("adp_gateway_adp_ws_connects_total",
+		"Authenticated ADP WebSocket connections by reason: initial, reconnect or token_expired.", "reason")
+	wsConnectErrors = metrics.NewCounter("adp_gateway_adp_ws_connect_errors_total",
+		"Failed attempts to connect and authenticate the ADP WebSocket.")
+	wsDisconnects = metrics.NewCounter("adp_gateway_adp_ws_disconnects_total",
+		"ADP WebSocket connections lost by reason: read_error, server_disconnect or server_error.", "reason")
+)
+
+// addPending 登记等待回复的请求
+func (c *Client) addPending(requestID string, req *PendingRequest) {
+	c.pendingRequests.Store(requestID, req)
+	pendingGauge.Inc()
+}
+
+// removePending 移除等待回复的请求，重复移除不影响计数
+// 旧连接上的最后一个请求结束后关闭旧连接
+func (c *Client) removePending(requestID any) {
+	if _, ok := c.pendingRequests.LoadAndDelete(requestID); ok {
+		pendingGauge.Dec()
+		c.closeDrained()
+	}
+}
+
+// errorCode 提取ADP错误事件中的错误码，格式不确定时依次尝试常见的位置
+func errorCode(data json.RawMessage) string {
+	var event struct {
+		Code  any `json:"code"`
+		Error *struct {
+			Code any `json:"code"`
+		} `json:"error"`
+		Payload *struct {
+			Code  any `json:"code"`
+			Error *struct {
+				Code any `json:"code"`
+			} `json:"error"`
+		} `json:"payload"`
+	}
+	if json.Unmarshal(data, &event) != nil {
+		return "unknown"
+	}
+	var code any
+	switch {
+	case event.Payload != nil && event.Payload.Error != nil && event.Payload.Error.Code != nil:
+		code = event.Payload.Error.Code
+	case event.Payload != nil && event.Payload.Code != nil:
+		code = event.Payload.Code
+	case event.Error != nil && event.Error.Code != nil:
+		code = event.Error.Code
+	case event.Code != nil:
+		code = event.Code
+	default:
+		return "unknown"
+	}
+	if f, ok := code.(float64); ok {
+		return fmt.Sprintf("%.0f", f)
+	}
+	return fmt.Sprint(code)
+}
@@ -0,0 +1,42 @@
+package adp
+
+import (
+	"encoding/json"
+)
+
+// handleRecommendedQuestion 处理推荐问题事件
+func (c *Client) handleRecommendedQuestion(data json.RawMessage) {
+	var wrapper struct {
+		Payload struct {
+			RequestID string   `json:"request_id"`
+			Questions []string `json:"questions"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		logger.Warn("解析推荐问题失败", "error", err)
+		return
+	}
+
+	requestID := wrapper.Payload.RequestID
+	v, ok := c.pendingRequests.Load(requestID)
+	if !ok {
+		logger.Debug("未找到推荐问题对应的请求", "adp_request_id", requestID)
+		return
+	}
+	req := v.(*PendingRequest)
+	if req.cancelled.Load() {
+		return
+	}
+
+	logger.DebugContext(req.ctx, "收到推荐问题", "count", len(wrapper.Payload.Questions))
+
+	req.refMu.Lock()
+	// 非nil表示已收到，即使没有推荐问题也不再等待
+	req.questions = append(make([]string, 0, len(wrapper.Payload.Questions)), wrapper.Payload.Questions...)
+	ready := req.finalReceived && !req.awaitingExtras()
+	req.refMu.Unlock()
+
+	if ready {
+		c.complete(requestID, req)
+	}
+}
@@ -0,0 +1,125 @@
+package adp
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// referenceWait 回复中带有引用标记时，等待reference事件的最长时间
+const referenceWait = 2 * time.Second
+
+// recommendWait 需要推荐问题时，等待推荐问题事件的最长时间
+const recommendWait = 3 * time.Second
+
+// Reference 知识库回答的参考来源
+type Reference struct {
+	ID      string `json:"id"`
+	Type    int    `json:"type"` // 1 问答, 2 文档片段, 4 联网搜索
+	URL     string `json:"url"`
+	Name    string `json:"name"`
+	DocName string `json:"doc_name"`
+}
+
+// Title 返回来源的展示名称
+func (r Reference) Title() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.DocName
+}
+
+// QuoteInfo 回复中的引用标记，Position为标记所在的字符位置，Index从1开始对应References
+type QuoteInfo struct {
+	Index    int `json:"index"`
+	Position int `json:"position"`
+}
+
+// handleReference 处理reference事件
+func (c *Client) handleReference(data json.RawMessage) {
+	var wrapper struct {
+		Payload struct {
+			RequestID  string      `json:"request_id"`
+			References []Reference `json:"references"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		logger.Warn("解析参考来源失败", "error", err)
+		return
+	}
+
+	requestID := wrapper.Payload.RequestID
+	v, ok := c.pendingRequests.Load(requestID)
+	if !ok {
+		logger.Debug("未找到参考来源对应的请求", "adp_request_id", requestID)
+		return
+	}
+	req := v.(*PendingRequest)
+	if req.cancelled.Load() {
+		return
+	}
+
+	logger.DebugContext(req.ctx, "收到参考来源", "count", len(wrapper.Payload.References))
+
+	req.refMu.Lock()
+	req.references = append(req.references, wrapper.Payload.References...)
+	ready := req.finalReceived && !req.awaitingExtras()
+	req.refMu.Unlock()
+
+	// 最终回复已到达，附加事件也已齐全
+	if ready {
+		c.complete(requestID, req)
+	}
+}
+
+// awaitingExtras 是否还在等待最终回复之后的附加事件，调用方需持有refMu
+func (req *PendingRequest) awaitingExtras() bool {
+	if len(req.quotes) > 0 && len(req.references) == 0 {
+		return true
+	}
+	return req.waitRecommended && req.questions == nil
+}
+
+// extrasWait 最终回复到达后等待附加事件的时长，调用方需持有refMu
+func (req *PendingRequest) extrasWait() time.Duration {
+	wait := time.Duration(0)
+	if len(req.quotes) > 0 && len(req.references) == 0 {
+		wait = referenceWait
+	}
+	if req.waitRecommended && req.questions == nil && recommendWait > wait {
+		wait = recommendWait
+	}
+	return wait
+}
+
+// complete 投递最终结果并清理请求，只执行一次
+func (c *Client) complete(requestID string, req *PendingRequest) {
+	req.completeOnce.Do(func() {
+		req.refMu.Lock()
+		references, quotes, questions := req.references, req.quotes, req.questions
+		req.refMu.Unlock()
+		req.recordMu.Lock()
+		recordID := req.recordID
+		req.recordMu.Unlock()
+
+		// 先投递结果再通知done，调用方在done后取消ctx时Chat仍能拿到结果
+		req.ResultCh <- &ChatResult{
+			Content:              req.FullContent,
+			RequestID:            requestID,
+			RecordID:             recordID,
+			References:           references,
+			Quotes:               quotes,
+			RecommendedQuestions: questions,
+		}
+		if req.Stream && req.OnChunk != nil {
+			req.OnChunk(Chunk{
+				Type:                 "done",
+				Content:              req.FullContent,
+				RecordID:             recordID,
+				References:           references,
+				Quotes:               quotes,
+				RecommendedQuestions: questions,
+			})
+		}
+		c.removePending(requestID)
+	})
+}
@@ -0,0 +1,163 @@
+package adp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/token"
+)
+
+// StorageCredential DescribeStorageCredential返回的临时上传凭证
+type StorageCredential struct {
+	Credentials struct {
+		Token        string `json:"Token"`
+		TmpSecretId  string `json:"TmpSecretId"`
+		TmpSecretKey string `json:"TmpSecretKey"`
+	} `json:"Credentials"`
+	ExpiredTime int64  `json:"ExpiredTime"`
+	StartTime   int64  `json:"StartTime"`
+	Bucket      string `json:"Bucket"`
+	Region      string `json:"Region"`
+	FilePath    string `json:"FilePath"`
+	Type        string `json:"Type"`
+	CorpUUID    string `json:"CorpUUID"`
+	ImagePath   string `json:"ImagePath"`
+	UploadPath  string `json:"UploadPath"`
+}
+
+// UploadResult 上传结果
+type UploadResult struct {
+	URL    string // 完整访问地址
+	Bucket string
+	Key    string // 对象路径，以/开头
+	ETag   string
+	Hash   string // x-cos-hash-crc64ecma
+	Size   int
+}
+
+// Uploader 通过ADP存储凭证将文件上传到COS
+type Uploader struct {
+	// Endpoint 覆盖COS地址（如 http://127.0.0.1:9000），为空时使用 https://{Bucket}.cos.{Region}.myqcloud.com
+	Endpoint string
+
+	credential CredentialFunc
+	httpClient *http.Client
+}
+
+// CredentialFunc 获取上传凭证
+type CredentialFunc func(ctx context.Context, fileType string, isPublic bool) (*StorageCredential, error)
+
+// NewUploader 创建通过DescribeStorageCredential获取凭证的上传器
+func NewUploader(tokenService *token.Service, botBizID string) *Uploader {
+	return NewUploaderWithCredential(func(ctx context.Context, fileType string, isPublic bool) (*StorageCredential, error) {
+		var cred StorageCredential
+		err := tokenService.Call(ctx, "DescribeStorageCredential", map[string]interface{}{
+			"BotBizId": botBizID,
+			"FileType": fileType,
+			"IsPublic": isPublic,
+			"TypeKey":  "realtime",
+		}, &cred)
+		if err != nil {
+			return nil, fmt.Errorf("获取存储凭证失败: %w", err)
+		}
+		return &cred, nil
+	})
+}
+
+// NewUploaderWithCredential 使用指定的凭证来源创建上传器
+func NewUploaderWithCredential(credential CredentialFunc) *Uploader {
+	return &Uploader{
+		credential: credential,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Upload 上传文件，返回可供ADP访问的对象信息
+func (u *Uploader) Upload(ctx context.Context, data []byte, fileType, contentType string, isPublic bool) (*UploadResult, error) {
+	cred, err := u.credential(ctx, fileType, isPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	host := fmt.Sprintf("%s.cos.%s.myqcloud.com", cred.Bucket, cred.Region)
+	base := "https://" + host
+	if u.Endpoint != "" {
+		base = strings.TrimRight(u.Endpoint, "/")
+		if parsed, err := url.Parse(base); err == nil {
+			host = parsed.Host
+		}
+	}
+	key := "/" + strings.TrimLeft(cred.UploadPath, "/")
+	objectURL := base + key
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", objectURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("创建上传请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", cosAuthorization(cred.Credentials.TmpSecretId, cred.Credentials.TmpSecretKey,
+		"put", key, host, time.Now(), cred.ExpiredTime))
+	if cred.Credentials.Token != "" {
+		req.Header.Set("x-cos-security-token", cred.Credentials.Token)
+	}
+
+	logger.InfoContext(ctx, "上传文件", "key", key, "size", len(data))
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("上传文件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("上传文件失败: HTTP %d %s", resp.StatusCode, truncate(string(body), 200))
+	}
+
+	return &UploadResult{
+		URL:    objectURL,
+		Bucket: cred.Bucket,
+		Key:    key,
+		ETag:   strings.Trim(resp.Header.Get("ETag"), `"`),
+		Hash:   resp.Header.Get("x-cos-hash-crc64ecma"),
+		Size:   len(data),
+	}, nil
+}
+
+// cosAuthorization 生成COS请求签名（q-sign-algorithm=sha1），只签host头
+func cosAuthorization(secretId, secretKey, method, path, host string, now time.Time, expiredTime int64) string {
+	start := now.Unix()
+	end := expiredTime
+	if end <= start {
+		end = start + 3600
+	}
+	keyTime := fmt.Sprintf("%d;%d", start, end)
+
+	signKey := hex.EncodeToString(hmacSHA1([]byte(secretKey), []byte(keyTime)))
+	httpString := fmt.Sprintf("%s\n%s\n\nhost=%s\n", method, path, url.QueryEscape(host))
+	stringToSign := fmt.Sprintf("sha1\n%s\n%s\n", keyTime, sha1Hex([]byte(httpString)))
+	signature := hex.EncodeToString(hmacSHA1([]byte(signKey), []byte(stringToSign)))
+
+	return fmt.Sprintf("q-sign-algorithm=sha1&q-ak=%s&q-sign-time=%s&q-key-time=%s&q-header-list=host&q-url-param-list=&q-signature=%s",
+		secretId, keyTime, keyTime, signature)
+}
+
+func hmacSHA1(key, data []byte) []byte {
+	h := hmac.New(sha1.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func sha1Hex(data []byte) string {
+	h := sha1.Sum(data)
+	return hex.EncodeToString(h[:])
+}
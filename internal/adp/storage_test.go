@@ -0,0 +1,112 @@
+package adp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploaderUpload(t *testing.T) {
+	var got struct {
+		method, path, contentType, token, auth string
+		body                                   string
+	}
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got.method = r.Method
+		got.path = r.URL.Path
+		got.contentType = r.Header.Get("Content-Type")
+		got.token = r.Header.Get("x-cos-security-token")
+		got.auth = r.Header.Get("Authorization")
+		got.body = string(body)
+		w.Header().Set("ETag", `"abc123"`)
+		w.Header().Set("x-cos-hash-crc64ecma", "42")
+	}))
+	defer storage.Close()
+
+	var gotType string
+	var gotPublic bool
+	uploader := NewUploaderWithCredential(func(ctx context.Context, fileType string, isPublic bool) (*StorageCredential, error) {
+		gotType, gotPublic = fileType, isPublic
+		cred := &StorageCredential{Bucket: "bucket-1250000000", Region: "ap-guangzhou", UploadPath: "corp/doc/a.pdf"}
+		cred.Credentials.TmpSecretId = "AKIDtest"
+		cred.Credentials.TmpSecretKey = "secret"
+		cred.Credentials.Token = "session-token"
+		return cred, nil
+	})
+	uploader.Endpoint = storage.URL + "/"
+
+	result, err := uploader.Upload(context.Background(), []byte("%PDF-1.4"), "pdf", "application/pdf", false)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	if gotType != "pdf" || gotPublic {
+		t.Errorf("credential called with (%q, %v), want (pdf, false)", gotType, gotPublic)
+	}
+	if got.method != http.MethodPut || got.path != "/corp/doc/a.pdf" {
+		t.Errorf("request = %s %s, want PUT /corp/doc/a.pdf", got.method, got.path)
+	}
+	if got.contentType != "application/pdf" || got.body != "%PDF-1.4" {
+		t.Errorf("content type %q body %q", got.contentType, got.body)
+	}
+	if got.token != "session-token" {
+		t.Errorf("x-cos-security-token = %q", got.token)
+	}
+	if !strings.HasPrefix(got.auth, "q-sign-algorithm=sha1&q-ak=AKIDtest&") || !strings.Contains(got.auth, "q-header-list=host&") {
+		t.Errorf("Authorization = %q", got.auth)
+	}
+
+	want := UploadResult{
+		URL:    storage.URL + "/corp/doc/a.pdf",
+		Bucket: "bucket-1250000000",
+		Key:    "/corp/doc/a.pdf",
+		ETag:   "abc123",
+		Hash:   "42",
+		Size:   8,
+	}
+	if *result != want {
+		t.Errorf("result = %+v, want %+v", *result, want)
+	}
+}
+
+func TestUploaderUploadErrors(t *testing.T) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer storage.Close()
+
+	credErr := errors.New("no credential")
+	tests := []struct {
+		name    string
+		cred    CredentialFunc
+		wantErr string
+	}{
+		{
+			name:    "credential error",
+			cred:    func(context.Context, string, bool) (*StorageCredential, error) { return nil, credErr },
+			wantErr: "no credential",
+		},
+		{
+			name: "storage rejects upload",
+			cred: func(context.Context, string, bool) (*StorageCredential, error) {
+				return &StorageCredential{UploadPath: "a.png"}, nil
+			},
+			wantErr: "HTTP 403",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader := NewUploaderWithCredential(tt.cred)
+			uploader.Endpoint = storage.URL
+			_, err := uploader.Upload(context.Background(), []byte("x"), "png", "image/png", true)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin 只允许管理员Key通过，需放在Middleware之后
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := FromContext(c)
+		if !ok || !key.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorBody("This API key is not allowed to manage API keys.", "invalid_request_error", "insufficient_permissions"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// AdminHandler /admin/keys 管理接口
+type AdminHandler struct {
+	store *Store
+}
+
+// NewAdminHandler 创建管理接口
+func NewAdminHandler(store *Store) *AdminHandler {
+	return &AdminHandler{store: store}
+}
+
+// CreateKeyRequest 创建Key的请求
+type CreateKeyRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Admin         bool     `json:"admin"`
+	Models        []string `json:"models"`
+	RPM           int      `json:"rpm"`
+	MaxStreams    int      `json:"max_streams"`
+	DailyRequests int      `json:"daily_requests"`
+}
+
+// ListKeys GET /admin/keys
+func (h *AdminHandler) ListKeys(c *gin.Context) {
+	keys := h.store.List()
+	data := make([]gin.H, 0, len(keys))
+	for _, k := range keys {
+		data = append(data, h.keyObject(k))
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
+
+// CreateKey POST /admin/keys，明文只在响应中返回一次
+func (h *AdminHandler) CreateKey(c *gin.Context) {
+	var req CreateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_request"))
+		return
+	}
+
+	secret, key, err := h.store.Create(Key{
+		Name:          req.Name,
+		Admin:         req.Admin,
+		Models:        req.Models,
+		RPM:           req.RPM,
+		MaxStreams:    req.MaxStreams,
+		DailyRequests: req.DailyRequests,
+	})
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "创建API Key失败", "error", err)
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_request"))
+		return
+	}
+	logger.InfoContext(c.Request.Context(), "已创建API Key", "name", key.Name)
+
+	obj := h.keyObject(key)
+	obj["key"] = secret
+	c.JSON(http.StatusOK, obj)
+}
+
+// RevokeKey POST /admin/keys/:name/revoke
+func (h *AdminHandler) RevokeKey(c *gin.Context) {
+	key, err := h.store.Revoke(c.Param("name"))
+	if err != nil {
+		h.storeError(c, err)
+		return
+	}
+	logger.InfoContext(c.Request.Context(), "已停用API Key", "name", key.Name)
+	c.JSON(http.StatusOK, h.keyObject(key))
+}
+
+// RotateKey POST /admin/keys/:name/rotate，返回新的明文
+func (h *AdminHandler) RotateKey(c *gin.Context) {
+	secret, key, err := h.store.Rotate(c.Param("name"))
+	if err != nil {
+		h.storeError(c, err)
+		return
+	}
+	logger.InfoContext(c.Request.Context(), "已轮换API Key", "name", key.Name)
+
+	obj := h.keyObject(key)
+	obj["key"] = secret
+	c.JSON(http.StatusOK, obj)
+}
+
+// keyObject Key的接口表示，不包含摘要
+func (h *AdminHandler) keyObject(k Key) gin.H {
+	usage := h.store.Usage(k.Name)
+	return gin.H{
+		"object":         "api_key",
+		"name":           k.Name,
+		"enabled":        k.Enabled,
+		"admin":          k.Admin,
+		"models":         k.Models,
+		"rpm":            k.RPM,
+		"max_streams":    k.MaxStreams,
+		"daily_requests": k.DailyRequests,
+		"created_at":     unixOrNil(k.CreatedAt),
+		"last_used_at":   unixOrNil(usage.LastUsedAt),
+		"usage":          gin.H{"requests": usage.Requests},
+	}
+}
+
+// storeError 返回修改Key失败的错误
+func (h *AdminHandler) storeError(c *gin.Context, err error) {
+	if errors.Is(err, ErrKeyNotFound) {
+		c.JSON(http.StatusNotFound, errorBody("No API key found with name '"+c.Param("name")+"'.", "invalid_request_error", "key_not_found"))
+		return
+	}
+	logger.ErrorContext(c.Request.Context(), "修改API Key失败", "error", err)
+	c.JSON(http.StatusInternalServerError, errorBody("Failed to update API keys.", "server_error", "internal_error"))
+}
+
+// unixOrNil 零值时间返回null
+func unixOrNil(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
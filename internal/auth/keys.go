@@ -0,0 +1,449 @@
+// Package auth 实现网关客户端的API Key鉴权
+//
+// Key只以SHA-256摘要的形式保存在配置文件中，明文只在创建时出现一次
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyPrefix 生成的Key明文前缀
+const keyPrefix = "sk-adp-"
+
+// ErrKeyNotFound Key不存在
+var ErrKeyNotFound = errors.New("API Key不存在")
+
+// Key API Key配置
+type Key struct {
+	Name      string    `json:"name"`
+	SHA256    string    `json:"sha256"` // Key明文的SHA-256（十六进制）
+	Enabled   bool      `json:"enabled"`
+	Admin     bool      `json:"admin,omitempty"` // 允许调用 /admin 接口
+	CreatedAt time.Time `json:"created_at,omitempty"`
+
+	// 以下限制为空或0时不限制
+	Models        []string `json:"models,omitempty"`         // 允许使用的模型ID
+	RPM           int      `json:"rpm,omitempty"`            // 每分钟请求数
+	MaxStreams    int      `json:"max_streams,omitempty"`    // 同时进行的流式请求数
+	DailyRequests int      `json:"daily_requests,omitempty"` // 每天请求数
+}
+
+// AllowsModel 是否允许使用模型
+func (k Key) AllowsModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, m := range k.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage Key的累计用量
+type Usage struct {
+	Requests   int64     `json:"requests"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// keysFile Key配置文件格式
+type keysFile struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Store API Key存储
+// Key定义保存在配置文件中，用量单独保存在同目录的 *.usage.json，
+// 服务运行时只写用量文件，命令行和管理接口修改Key时才写配置文件
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	keys    []*Key
+	byHash  map[string]*Key
+	modTime time.Time
+
+	usageMu    sync.Mutex
+	usage      map[string]*Usage
+	usageDirty bool
+}
+
+// HashKey 计算Key的摘要
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadStore 从配置文件加载API Key，文件不存在时为空
+func LoadStore(path string) (*Store, error) {
+	s := &Store{path: path, usage: make(map[string]*Usage)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.loadUsage(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load 读取并校验配置文件
+func (s *Store) load() error {
+	var file keysFile
+	var modTime time.Time
+	info, err := os.Stat(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("读取API Key配置失败: %w", err)
+	default:
+		modTime = info.ModTime()
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return fmt.Errorf("读取API Key配置失败: %w", err)
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("解析API Key配置失败: %w", err)
+		}
+	}
+
+	byHash, err := index(file.Keys)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = file.Keys
+	s.byHash = byHash
+	s.modTime = modTime
+	s.mu.Unlock()
+	return nil
+}
+
+// index 校验Key并按摘要建立索引
+func index(keys []*Key) (map[string]*Key, error) {
+	byHash := make(map[string]*Key, len(keys))
+	names := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Name == "" {
+			return nil, errors.New("API Key缺少name")
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("API Key名称重复: %s", k.Name)
+		}
+		names[k.Name] = true
+
+		hash := strings.ToLower(k.SHA256)
+		if len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("API Key %s 的sha256格式错误", k.Name)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("API Key %s 的sha256格式错误", k.Name)
+		}
+		if k.RPM < 0 || k.MaxStreams < 0 || k.DailyRequests < 0 {
+			return nil, fmt.Errorf("API Key %s 的限制不能为负数", k.Name)
+		}
+		k.SHA256 = hash
+		byHash[hash] = k
+	}
+	return byHash, nil
+}
+
+// Lookup 按明文查找Key，返回副本
+func (s *Store) Lookup(key string) (Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k, ok := s.byHash[HashKey(key)]
+	if !ok {
+		return Key{}, false
+	}
+	return *k, true
+}
+
+// Len 返回Key数量
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+// List 返回所有Key，按名称排序
+func (s *Store) List() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, *k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// Create 创建Key，返回明文（只出现这一次）
+func (s *Store) Create(spec Key) (string, Key, error) {
+	if spec.Name == "" || strings.ContainsAny(spec.Name, "/ \t\n") {
+		return "", Key{}, fmt.Errorf("API Key名称不能为空或包含空白和斜杠: %q", spec.Name)
+	}
+	secret, err := generateKey()
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	k := spec
+	err = s.locked(func() error {
+		for _, existing := range s.keys {
+			if existing.Name == spec.Name {
+				return fmt.Errorf("API Key名称已存在: %s", spec.Name)
+			}
+		}
+
+		// 复制一份，修改失败时不影响内存中的Key
+		keys := make([]*Key, len(s.keys), len(s.keys)+1)
+		copy(keys, s.keys)
+		k.SHA256 = HashKey(secret)
+		k.Enabled = true
+		k.CreatedAt = time.Now().UTC().Truncate(time.Second)
+		return s.update(append(keys, &k))
+	})
+	if err != nil {
+		return "", Key{}, err
+	}
+	return secret, k, nil
+}
+
+// Revoke 停用Key，保留记录和用量
+func (s *Store) Revoke(name string) (Key, error) {
+	return s.modify(name, func(k *Key) error {
+		k.Enabled = false
+		return nil
+	})
+}
+
+// Rotate 为Key生成新的明文，旧的明文立即失效，名称、限制和用量保持不变
+func (s *Store) Rotate(name string) (string, Key, error) {
+	secret, err := generateKey()
+	if err != nil {
+		return "", Key{}, err
+	}
+	k, err := s.modify(name, func(k *Key) error {
+		k.SHA256 = HashKey(secret)
+		return nil
+	})
+	if err != nil {
+		return "", Key{}, err
+	}
+	return secret, k, nil
+}
+
+// modify 修改指定的Key并保存
+func (s *Store) modify(name string, fn func(k *Key) error) (Key, error) {
+	var target *Key
+	err := s.locked(func() error {
+		keys := make([]*Key, len(s.keys))
+		for i, k := range s.keys {
+			copied := *k
+			keys[i] = &copied
+			if k.Name == name {
+				target = keys[i]
+			}
+		}
+		if target == nil {
+			return ErrKeyNotFound
+		}
+		if err := fn(target); err != nil {
+			return err
+		}
+		return s.update(keys)
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	return *target, nil
+}
+
+// locked 持有配置文件锁并重新读取配置后执行fn，fn执行时同时持有写锁
+// 先读入其他进程（命令行或另一实例）的修改，写入前其他进程无法修改，避免覆盖
+func (s *Store) locked(fn func() error) error {
+	unlock, err := lockFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+// update 校验并写入配置文件，成功后替换内存中的Key，调用方需持有写锁
+func (s *Store) update(keys []*Key) error {
+	byHash, err := index(keys)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(keysFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化API Key配置失败: %w", err)
+	}
+	if err := writeFileAtomic(s.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("保存API Key配置失败: %w", err)
+	}
+
+	s.keys = keys
+	s.byHash = byHash
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Reload 配置文件被修改时重新加载，返回是否发生了重新加载
+func (s *Store) Reload() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	s.mu.RLock()
+	unchanged := info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	return true, s.load()
+}
+
+// Watch 定期检查配置文件变化并保存用量
+func (s *Store) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if reloaded, err := s.Reload(); err != nil {
+			// 格式错误时保留原有Key
+			logger.Error("重新加载API Key配置失败", "error", err)
+		} else if reloaded {
+			logger.Info("已重新加载API Key配置", "keys", s.Len())
+		}
+		if err := s.FlushUsage(); err != nil {
+			logger.Error("保存用量失败", "error", err)
+		}
+	}
+}
+
+// RecordUse 记录一次请求
+func (s *Store) RecordUse(name string) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+
+	u, ok := s.usage[name]
+	if !ok {
+		u = &Usage{}
+		s.usage[name] = u
+	}
+	u.Requests++
+	u.LastUsedAt = time.Now().UTC().Truncate(time.Second)
+	s.usageDirty = true
+}
+
+// Usage 返回Key的累计用量
+func (s *Store) Usage(name string) Usage {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+
+	if u, ok := s.usage[name]; ok {
+		return *u
+	}
+	return Usage{}
+}
+
+// usagePath 用量文件路径：keys.json -> keys.usage.json
+func (s *Store) usagePath() string {
+	ext := filepath.Ext(s.path)
+	return strings.TrimSuffix(s.path, ext) + ".usage" + ext
+}
+
+// loadUsage 读取用量文件，文件不存在时为空
+func (s *Store) loadUsage() error {
+	data, err := os.ReadFile(s.usagePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取用量失败: %w", err)
+	}
+
+	usage := make(map[string]*Usage)
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return fmt.Errorf("解析用量失败: %w", err)
+	}
+	s.usageMu.Lock()
+	s.usage = usage
+	s.usageMu.Unlock()
+	return nil
+}
+
+// FlushUsage 有新的用量时写入用量文件
+func (s *Store) FlushUsage() error {
+	s.usageMu.Lock()
+	if !s.usageDirty {
+		s.usageMu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s.usage, "", "  ")
+	s.usageDirty = false
+	s.usageMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.usagePath(), append(data, '\n'))
+}
+
+// generateKey 生成Key明文
+func generateKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成API Key失败: %w", err)
+	}
+	return keyPrefix + hex.EncodeToString(b), nil
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免读到写了一半的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := LoadStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("LoadStore: %v", err)
+	}
+	return s
+}
+
+func TestHashKey(t *testing.T) {
+	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" // SHA-256("abc")
+	if got := HashKey("abc"); got != want {
+		t.Fatalf("HashKey = %q, want %q", got, want)
+	}
+}
+
+func TestCreateAndLookup(t *testing.T) {
+	s := newTestStore(t)
+	secret, key, err := s.Create(Key{Name: "team-a", RPM: 60, Models: []string{"adp-default"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !strings.HasPrefix(secret, keyPrefix) || !key.Enabled || key.SHA256 != HashKey(secret) {
+		t.Fatalf("Create = %q, %+v", secret, key)
+	}
+
+	got, ok := s.Lookup(secret)
+	if !ok || got.Name != "team-a" || got.RPM != 60 {
+		t.Fatalf("Lookup = %+v, %v", got, ok)
+	}
+	if _, ok := s.Lookup(secret + "x"); ok {
+		t.Error("Lookup accepts a wrong key")
+	}
+	if _, ok := s.Lookup(key.SHA256); ok {
+		t.Error("Lookup accepts the stored hash as a key")
+	}
+
+	// 配置文件只保存摘要
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Error("keys file contains the plaintext key")
+	}
+
+	if _, _, err := s.Create(Key{Name: "team-a"}); err == nil {
+		t.Error("Create accepts a duplicate name")
+	}
+	if _, _, err := s.Create(Key{Name: "bad name"}); err == nil {
+		t.Error("Create accepts a name with spaces")
+	}
+}
+
+func TestRevokeAndRotate(t *testing.T) {
+	s := newTestStore(t)
+	secret, _, err := s.Create(Key{Name: "team-a", DailyRequests: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Revoke("team-a"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if k, ok := s.Lookup(secret); !ok || k.Enabled {
+		t.Fatalf("revoked key = %+v, %v, want found and disabled", k, ok)
+	}
+
+	newSecret, k, err := s.Rotate("team-a")
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if newSecret == secret || k.DailyRequests != 100 || k.Name != "team-a" {
+		t.Fatalf("Rotate = %q, %+v", newSecret, k)
+	}
+	if _, ok := s.Lookup(secret); ok {
+		t.Error("old key still valid after rotate")
+	}
+	if _, ok := s.Lookup(newSecret); !ok {
+		t.Error("new key not found after rotate")
+	}
+
+	if _, err := s.Revoke("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Revoke(missing) = %v, want ErrKeyNotFound", err)
+	}
+	if _, _, err := s.Rotate("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Rotate(missing) = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestReloadOnModTime(t *testing.T) {
+	s := newTestStore(t)
+	if _, _, err := s.Create(Key{Name: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := s.Reload(); err != nil || reloaded {
+		t.Fatalf("Reload without changes = %v, %v", reloaded, err)
+	}
+
+	// 模拟另一个进程写入新的Key
+	const secret = "sk-adp-external"
+	data, _ := json.Marshal(keysFile{Keys: []*Key{{Name: "external", SHA256: HashKey(secret), Enabled: true}}})
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(s.path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := s.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Reload = %v, %v, want reloaded", reloaded, err)
+	}
+	if _, ok := s.Lookup(secret); !ok {
+		t.Error("externally added key not found after reload")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len = %d, want 1", s.Len())
+	}
+
+	// 格式错误时保留原有Key
+	if err := os.WriteFile(s.path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := future.Add(time.Minute)
+	os.Chtimes(s.path, later, later)
+	if _, err := s.Reload(); err == nil {
+		t.Fatal("Reload accepts an invalid file")
+	}
+	if _, ok := s.Lookup(secret); !ok {
+		t.Error("keys lost after a failed reload")
+	}
+}
+
+func TestConcurrentWritersKeepAllKeys(t *testing.T) {
+	// 两个Store使用同一文件，模拟命令行和运行中的网关同时修改
+	path := filepath.Join(t.TempDir(), "keys.json")
+	a, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("LoadStore: %v", err)
+	}
+	b, _ := LoadStore(path)
+
+	const perStore = 10
+	errs := make(chan error, 2*perStore)
+	for _, s := range []*Store{a, b} {
+		for i := 0; i < perStore; i++ {
+			go func(s *Store, name string) {
+				_, _, err := s.Create(Key{Name: name})
+				errs <- err
+			}(s, fmt.Sprintf("%p-%d", s, i))
+		}
+	}
+	for i := 0; i < 2*perStore; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	c, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("LoadStore: %v", err)
+	}
+	if c.Len() != 2*perStore {
+		t.Errorf("file has %d keys, want %d", c.Len(), 2*perStore)
+	}
+	if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
+
+func TestCreateWaitsForLock(t *testing.T) {
+	s := newTestStore(t)
+	other, _ := LoadStore(s.path)
+
+	// 另一个进程持有锁期间写入的Key不能被覆盖
+	unlock, err := lockFile(s.path)
+	if err != nil {
+		t.Fatalf("lockFile: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := s.Create(Key{Name: "waiting"})
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Create finished while the file was locked: %v", err)
+	default:
+	}
+	keys := []*Key{{Name: "from-other", SHA256: HashKey("x"), Enabled: true}}
+	other.mu.Lock()
+	err = other.update(keys)
+	other.mu.Unlock()
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	reloaded, _ := LoadStore(s.path)
+	if reloaded.Len() != 2 {
+		t.Errorf("file has %v, want both keys", reloaded.List())
+	}
+}
+
+func TestStaleLockIsRemoved(t *testing.T) {
+	s := newTestStore(t)
+	lock := s.path + ".lock"
+	if err := os.WriteFile(lock, []byte("1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStale)
+	os.Chtimes(lock, old, old)
+
+	if _, _, err := s.Create(Key{Name: "after-crash"}); err != nil {
+		t.Fatalf("Create with stale lock: %v", err)
+	}
+}
+
+func TestIndexValidation(t *testing.T) {
+	hash := HashKey("k")
+	tests := []struct {
+		name string
+		keys []*Key
+	}{
+		{"missing name", []*Key{{SHA256: hash}}},
+		{"duplicate name", []*Key{{Name: "a", SHA256: hash}, {Name: "a", SHA256: HashKey("k2")}}},
+		{"short hash", []*Key{{Name: "a", SHA256: "abc"}}},
+		{"non-hex hash", []*Key{{Name: "a", SHA256: strings.Repeat("z", 64)}}},
+		{"negative limit", []*Key{{Name: "a", SHA256: hash, RPM: -1}}},
+	}
+	for _, tt := range tests {
+		if _, err := index(tt.keys); err == nil {
+			t.Errorf("%s: index accepted invalid keys", tt.name)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBodySize 限流中间件读取的请求体上限，需要容纳base64编码的文档和图片
+var maxBodySize int64 = 100 << 20
+
+// RequestInfo 限流需要的请求信息，由各协议从请求体中解析
+type RequestInfo struct {
+	Model  string
+	Stream bool
+	// Units 请求消耗的请求数额度，一个请求发起多次ADP对话（如n>1）时大于1，0按1计
+	Units int
+}
+
+// LimitError 超出限制
+type LimitError struct {
+	Status     int
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Limiter 按Key执行RPM、每日请求数和并发流式请求数限制
+// 状态只保存在内存中，按Key名称区分
+type Limiter struct {
+	mu     sync.Mutex
+	states map[string]*keyState
+	now    func() time.Time
+}
+
+// keyState 单个Key的用量
+type keyState struct {
+	recent   []time.Time // 最近一分钟内的请求时间
+	day      string
+	dayCount int
+	streams  int
+}
+
+// NewLimiter 创建限流器
+func NewLimiter() *Limiter {
+	return &Limiter{
+		states: make(map[string]*keyState),
+		now:    time.Now,
+	}
+}
+
+// Acquire 检查并占用units个请求数额度，headers为需要返回的x-ratelimit-*响应头（被拒绝时同样需要返回）
+// 流式请求占用一个并发额度，在结束后需要调用release归还
+func (l *Limiter) Acquire(key Key, units int, stream bool) (headers map[string]string, release func(), err *LimitError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if units < 1 {
+		units = 1
+	}
+
+	now := l.now()
+	st, ok := l.states[key.Name]
+	if !ok {
+		st = &keyState{}
+		l.states[key.Name] = st
+	}
+
+	// 清理一分钟之前的记录
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(st.recent) && !st.recent[i].After(cutoff) {
+		i++
+	}
+	st.recent = st.recent[i:]
+
+	today := now.Format("2006-01-02")
+	if st.day != today {
+		st.day = today
+		st.dayCount = 0
+	}
+
+	y, m, d := now.Date()
+	resetDay := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Sub(now)
+	resetMinute := time.Minute
+	if len(st.recent) > 0 {
+		resetMinute = st.recent[0].Add(time.Minute).Sub(now)
+	}
+
+	switch {
+	case key.DailyRequests > 0 && st.dayCount+units > key.DailyRequests:
+		err = &LimitError{
+			Status:     http.StatusTooManyRequests,
+			Code:       "rate_limit_exceeded",
+			Message:    fmt.Sprintf("Rate limit reached for requests per day: limit %d.", key.DailyRequests),
+			RetryAfter: resetDay,
+		}
+	case key.RPM > 0 && len(st.recent)+units > key.RPM:
+		err = &LimitError{
+			Status:     http.StatusTooManyRequests,
+			Code:       "rate_limit_exceeded",
+			Message:    fmt.Sprintf("Rate limit reached for requests per minute: limit %d.", key.RPM),
+			RetryAfter: resetMinute,
+		}
+	case stream && key.MaxStreams > 0 && st.streams >= key.MaxStreams:
+		err = &LimitError{
+			Status:     http.StatusTooManyRequests,
+			Code:       "concurrency_limit_exceeded",
+			Message:    fmt.Sprintf("Too many concurrent streaming requests: limit %d.", key.MaxStreams),
+			RetryAfter: time.Second,
+		}
+	}
+
+	release = func() {}
+	if err == nil {
+		for i := 0; i < units; i++ {
+			st.recent = append(st.recent, now)
+		}
+		st.dayCount += units
+		if stream && key.MaxStreams > 0 {
+			st.streams++
+			var once sync.Once
+			release = func() {
+				once.Do(func() {
+					l.mu.Lock()
+					st.streams--
+					l.mu.Unlock()
+				})
+			}
+		}
+	}
+
+	// 剩余额度按本次请求之后计算
+	headers = make(map[string]string)
+	if key.RPM > 0 {
+		setHeaders(headers, "", key.RPM, key.RPM-len(st.recent), resetMinute)
+	}
+	if key.DailyRequests > 0 {
+		setHeaders(headers, "-day", key.DailyRequests, key.DailyRequests-st.dayCount, resetDay)
+	}
+	return headers, release, err
+}
+
+// setHeaders 写入OpenAI风格的x-ratelimit-*响应头，suffix区分分钟和每日限制
+func setHeaders(headers map[string]string, suffix string, limit, remaining int, reset time.Duration) {
+	headers["x-ratelimit-limit-requests"+suffix] = strconv.Itoa(limit)
+	headers["x-ratelimit-remaining-requests"+suffix] = strconv.Itoa(remaining)
+	headers["x-ratelimit-reset-requests"+suffix] = reset.Round(time.Millisecond).String()
+}
+
+// Limits 模型白名单和限流中间件，需要放在鉴权中间件之后
+// parse从请求体中解析模型和是否流式，请求体会被还原供后续处理
+func Limits(limiter *Limiter, parse func(body []byte) RequestInfo) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := FromContext(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, errorBody(
+				fmt.Sprintf("Request body exceeds the %d MB size limit.", maxBodySize>>20), "invalid_request_error", "request_too_large"))
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorBody("Invalid request body", "invalid_request_error", "invalid_request"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		info := parse(body)
+
+		if !key.AllowsModel(info.Model) {
+			logger.WarnContext(c.Request.Context(), "Key无权使用模型", "model", info.Model)
+			c.AbortWithStatusJSON(http.StatusNotFound, errorBody(
+				fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", info.Model),
+				"invalid_request_error", "model_not_found"))
+			return
+		}
+
+		headers, release, limitErr := limiter.Acquire(key, info.Units, info.Stream)
+		for k, v := range headers {
+			c.Header(k, v)
+		}
+		if limitErr != nil {
+			logger.WarnContext(c.Request.Context(), "Key超出限制", "code", limitErr.Code)
+			c.Header("retry-after", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+			c.AbortWithStatusJSON(limitErr.Status, errorBody(limitErr.Message, "requests", limitErr.Code))
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestLimiter 返回使用可控时钟的限流器
+func newTestLimiter(start time.Time) (*Limiter, *time.Time) {
+	l := NewLimiter()
+	now := start
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterRPM(t *testing.T) {
+	l, now := newTestLimiter(time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC))
+	key := Key{Name: "k", RPM: 2}
+
+	for i := 0; i < 2; i++ {
+		headers, _, err := l.Acquire(key, 1, false)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if want := []string{"1", "0"}[i]; headers["x-ratelimit-remaining-requests"] != want {
+			t.Errorf("request %d remaining = %q, want %q", i, headers["x-ratelimit-remaining-requests"], want)
+		}
+		*now = now.Add(10 * time.Second)
+	}
+
+	_, _, err := l.Acquire(key, 1, false)
+	if err == nil || err.Code != "rate_limit_exceeded" {
+		t.Fatalf("third request err = %v, want rate_limit_exceeded", err)
+	}
+	// 第一条请求在50秒后移出窗口
+	if err.RetryAfter != 40*time.Second {
+		t.Errorf("RetryAfter = %v, want 40s", err.RetryAfter)
+	}
+
+	*now = now.Add(40 * time.Second)
+	if _, _, err := l.Acquire(key, 1, false); err != nil {
+		t.Fatalf("request after window: %v", err)
+	}
+	if _, _, err := l.Acquire(key, 1, false); err == nil {
+		t.Fatal("window should still hold the second and third requests")
+	}
+}
+
+func TestLimiterDailyReset(t *testing.T) {
+	l, now := newTestLimiter(time.Date(2026, 1, 2, 23, 0, 0, 0, time.UTC))
+	key := Key{Name: "k", DailyRequests: 2}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := l.Acquire(key, 1, false); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		*now = now.Add(10 * time.Minute)
+	}
+	headers, _, err := l.Acquire(key, 1, false)
+	if err == nil {
+		t.Fatal("daily limit not enforced")
+	}
+	if err.RetryAfter != 40*time.Minute {
+		t.Errorf("RetryAfter = %v, want 40m", err.RetryAfter)
+	}
+	if headers["x-ratelimit-remaining-requests-day"] != "0" {
+		t.Errorf("remaining = %q, want 0", headers["x-ratelimit-remaining-requests-day"])
+	}
+
+	*now = time.Date(2026, 1, 3, 0, 0, 1, 0, time.UTC)
+	headers, _, err = l.Acquire(key, 1, false)
+	if err != nil {
+		t.Fatalf("request after midnight: %v", err)
+	}
+	if headers["x-ratelimit-remaining-requests-day"] != "1" {
+		t.Errorf("remaining after reset = %q, want 1", headers["x-ratelimit-remaining-requests-day"])
+	}
+}
+
+func TestLimiterStreams(t *testing.T) {
+	l, _ := newTestLimiter(time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC))
+	key := Key{Name: "k", MaxStreams: 1}
+
+	_, release, err := l.Acquire(key, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Acquire(key, 1, true); err == nil || err.Code != "concurrency_limit_exceeded" {
+		t.Fatalf("second stream err = %v, want concurrency_limit_exceeded", err)
+	}
+	// 非流式请求不占用并发额度
+	if _, _, err := l.Acquire(key, 1, false); err != nil {
+		t.Fatalf("non-stream request: %v", err)
+	}
+
+	// 重复调用release只归还一次
+	release()
+	release()
+	_, release2, err := l.Acquire(key, 1, true)
+	if err != nil {
+		t.Fatalf("stream after release: %v", err)
+	}
+	if _, _, err := l.Acquire(key, 1, true); err == nil {
+		t.Fatal("double release freed more than one slot")
+	}
+	release2()
+}
+
+func TestLimitsReleasesStreams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, _ := newTestLimiter(time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC))
+	key := Key{Name: "k", MaxStreams: 1}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set(contextKey, key) })
+	r.POST("/ok", Limits(l, parseTestRequest), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.POST("/fail", Limits(l, parseTestRequest), func(c *gin.Context) {
+		c.String(http.StatusBadGateway, "upstream failed")
+	})
+
+	for _, path := range []string{"/ok", "/fail", "/ok", "/fail"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"stream":true}`)))
+		if w.Code == http.StatusTooManyRequests {
+			t.Fatalf("%s: stream slot was not released by the previous request", path)
+		}
+	}
+	if st := l.states["k"]; st.streams != 0 {
+		t.Errorf("streams = %d, want 0", st.streams)
+	}
+}
+
+func TestLimitsRejects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, _ := newTestLimiter(time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC))
+	key := Key{Name: "k", Models: []string{"allowed"}, RPM: 1}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set(contextKey, key) })
+	r.POST("/", Limits(l, parseTestRequest), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"model not allowed", `{"model":"other"}`, http.StatusNotFound},
+		{"allowed", `{"model":"allowed"}`, http.StatusOK},
+		{"rpm exceeded", `{"model":"allowed"}`, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusTooManyRequests && w.Header().Get("retry-after") != "60" {
+			t.Errorf("%s: retry-after = %q, want 60", tt.name, w.Header().Get("retry-after"))
+		}
+	}
+}
+
+func TestLimiterUnits(t *testing.T) {
+	l, _ := newTestLimiter(time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC))
+	key := Key{Name: "k", RPM: 3, DailyRequests: 4}
+
+	headers, _, err := l.Acquire(key, 2, false)
+	if err != nil {
+		t.Fatalf("n=2: %v", err)
+	}
+	if headers["x-ratelimit-remaining-requests"] != "1" || headers["x-ratelimit-remaining-requests-day"] != "2" {
+		t.Errorf("headers = %v, want 1 remaining per minute and 2 per day", headers)
+	}
+	// 剩余额度不足以容纳整个请求时拒绝，且不占用额度
+	if _, _, err := l.Acquire(key, 2, false); err == nil || err.Code != "rate_limit_exceeded" {
+		t.Fatalf("n=2 over RPM err = %v, want rate_limit_exceeded", err)
+	}
+	if _, _, err := l.Acquire(key, 0, false); err != nil {
+		t.Fatalf("units 0 counts as 1: %v", err)
+	}
+	if st := l.states["k"]; len(st.recent) != 3 || st.dayCount != 3 {
+		t.Errorf("recent = %d, dayCount = %d, want 3 and 3", len(st.recent), st.dayCount)
+	}
+}
+
+func TestLimitsBodySize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(size int64) { maxBodySize = size }(maxBodySize)
+	maxBodySize = 32
+
+	l, _ := newTestLimiter(time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC))
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set(contextKey, Key{Name: "k"}) })
+	r.POST("/", Limits(l, parseTestRequest), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{`{"model":"m"}`, http.StatusOK},
+		{`{"model":"` + strings.Repeat("m", 64) + `"}`, http.StatusRequestEntityTooLarge},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%d byte body: status = %d, want %d", len(tt.body), w.Code, tt.status)
+		}
+	}
+}
+
+// parseTestRequest 解析测试请求体中的model和stream
+func parseTestRequest(body []byte) RequestInfo {
+	var info struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	json.Unmarshal(body, &info)
+	return RequestInfo{Model: info.Model, Stream: info.Stream}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// lockTimeout 等待其他进程释放配置文件锁的最长时间
+	lockTimeout = 15 * time.Second
+	// lockStale 锁文件超过这个时间未释放视为持有者已退出
+	lockStale = 10 * time.Second
+	// lockRetry 重试获取锁的间隔
+	lockRetry = 20 * time.Millisecond
+)
+
+// lockFile 以独占方式创建 path.lock 作为跨进程的写锁，返回释放函数
+// 命令行和运行中的网关（或多个实例）可能同时修改配置文件，读取和写入都需要在锁内完成
+func lockFile(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("创建锁文件失败: %w", err)
+		}
+
+		// 持有者异常退出时锁文件不会被删除
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockStale {
+			logger.Warn("删除过期的锁文件", "path", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("等待锁文件超时，如果没有其他进程在修改API Key，请删除 %s", lockPath)
+		}
+		time.Sleep(lockRetry)
+	}
+}
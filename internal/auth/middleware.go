@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+)
+
+var logger = logging.Component("auth")
+
+// contextKey 鉴权通过后保存Key的gin上下文键
+const contextKey = "auth.key"
+
+// Middleware Bearer Token鉴权中间件，错误响应与OpenAI一致
+func Middleware(store *Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+		if token == "" {
+			unauthorized(c, "You didn't provide an API key. You need to provide your API key in an Authorization header using Bearer auth (i.e. Authorization: Bearer YOUR_KEY).", "missing_api_key")
+			return
+		}
+
+		key, ok := store.Lookup(token)
+		if !ok {
+			logger.WarnContext(c.Request.Context(), "无效的API Key", "api_key", maskKey(token))
+			unauthorized(c, "Incorrect API key provided: "+maskKey(token)+".", "invalid_api_key")
+			return
+		}
+		if !key.Enabled {
+			logger.WarnContext(c.Request.Context(), "API Key已停用", "api_key", key.Name)
+			unauthorized(c, "The API key provided has been disabled.", "invalid_api_key")
+			return
+		}
+
+		store.RecordUse(key.Name)
+		c.Set(contextKey, key)
+		logging.AddRequestAttrs(c, "api_key", key.Name)
+		c.Next()
+	}
+}
+
+// FromContext 返回鉴权通过的Key，未启用鉴权时返回false
+func FromContext(c *gin.Context) (Key, bool) {
+	v, ok := c.Get(contextKey)
+	if !ok {
+		return Key{}, false
+	}
+	key, ok := v.(Key)
+	return key, ok
+}
+
+// bearerToken 解析Authorization请求头
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// maskKey 日志和错误信息中只保留Key的首尾
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:3] + "****" + key[len(key)-4:]
+}
+
+// unauthorized 返回401
+func unauthorized(c *gin.Context, message, code string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody(message, "invalid_request_error", code))
+}
+
+// errorBody OpenAI格式的错误响应
+func errorBody(message, errType, code string) gin.H {
+	return gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    code,
+		},
+	}
+}
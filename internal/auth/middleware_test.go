@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestStore(t)
+	active, _, err := s.Create(Key{Name: "active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled, _, err := s.Create(Key{Name: "disabled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Revoke("disabled"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(Middleware(s))
+	r.GET("/v1/models", func(c *gin.Context) {
+		key, _ := FromContext(c)
+		c.String(http.StatusOK, key.Name)
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		status   int
+		wantCode string
+		wantBody string
+	}{
+		{name: "missing", status: http.StatusUnauthorized, wantCode: "missing_api_key"},
+		{name: "not bearer", header: "Basic " + active, status: http.StatusUnauthorized, wantCode: "missing_api_key"},
+		{name: "unknown", header: "Bearer sk-adp-unknown-key", status: http.StatusUnauthorized, wantCode: "invalid_api_key"},
+		{name: "disabled", header: "Bearer " + disabled, status: http.StatusUnauthorized, wantCode: "invalid_api_key"},
+		{name: "valid", header: "Bearer " + active, status: http.StatusOK, wantBody: "active"},
+		{name: "case-insensitive scheme", header: "bearer " + active, status: http.StatusOK, wantBody: "active"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body, tt.wantBody)
+			}
+			if tt.wantCode != "" {
+				var body struct {
+					Error struct {
+						Code string `json:"code"`
+					} `json:"error"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &body)
+				if body.Error.Code != tt.wantCode {
+					t.Errorf("code = %q, want %q", body.Error.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+
+	if u := s.Usage("active"); u.Requests != 2 {
+		t.Errorf("active usage = %d, want 2", u.Requests)
+	}
+	if u := s.Usage("disabled"); u.Requests != 0 {
+		t.Errorf("disabled usage = %d, want 0", u.Requests)
+	}
+}
+
+func TestMaskKey(t *testing.T) {
+	tests := map[string]string{
+		"short":                 "****",
+		"sk-adp-0123456789abcd": "sk-****abcd",
+	}
+	for key, want := range tests {
+		if got := maskKey(key); got != want {
+			t.Errorf("maskKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+// File 从JSON文件读取凭证，文件修改后自动重新读取，适用于由外部进程（如Vault Agent）定期轮换的凭证：
+//
+//	{"secret_id": "...", "secret_key": "...", "token": "...", "expiration": "2026-01-02T15:04:05Z"}
+//
+// token和expiration为可选项
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cred    tencentcloud.Credential
+}
+
+// NewFile 创建文件凭证
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Credential 实现tencentcloud.CredentialProvider
+func (f *File) Credential() (tencentcloud.Credential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return tencentcloud.Credential{}, fmt.Errorf("读取凭证文件失败: %w", err)
+	}
+	if f.cred.SecretID == "" || !info.ModTime().Equal(f.modTime) {
+		if err := f.load(info.ModTime()); err != nil {
+			return tencentcloud.Credential{}, err
+		}
+	}
+	if expired(f.cred, time.Now()) {
+		return tencentcloud.Credential{}, fmt.Errorf("凭证文件中的凭证已于 %s 过期", f.cred.ExpiresAt.Format(time.RFC3339))
+	}
+	return f.cred, nil
+}
+
+// load 读取凭证文件，调用方需持有锁
+func (f *File) load(modTime time.Time) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("读取凭证文件失败: %w", err)
+	}
+	var file struct {
+		SecretID   string    `json:"secret_id"`
+		SecretKey  string    `json:"secret_key"`
+		Token      string    `json:"token"`
+		Expiration time.Time `json:"expiration"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("解析凭证文件失败: %w", err)
+	}
+	if file.SecretID == "" || file.SecretKey == "" {
+		return errors.New("凭证文件缺少secret_id或secret_key")
+	}
+
+	f.cred = tencentcloud.Credential{
+		SecretID:  file.SecretID,
+		SecretKey: file.SecretKey,
+		Token:     file.Token,
+		ExpiresAt: file.Expiration,
+	}
+	f.modTime = modTime
+	return nil
+}
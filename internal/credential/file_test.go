@@ -0,0 +1,56 @@
+package credential
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCredentialFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	mod := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeCredentialFile(t, path, `{"secret_id":"id-1","secret_key":"key-1"}`, mod)
+
+	f := NewFile(path)
+	cred, err := f.Credential()
+	if err != nil || cred.SecretID != "id-1" {
+		t.Fatalf("Credential = %q, %v; want id-1", cred.SecretID, err)
+	}
+
+	// 修改时间变化后重新读取
+	writeCredentialFile(t, path, `{"secret_id":"id-2","secret_key":"key-2","token":"tok"}`, mod.Add(time.Minute))
+	cred, err = f.Credential()
+	if err != nil || cred.SecretID != "id-2" || cred.Token != "tok" {
+		t.Fatalf("Credential after rotation = %+v, %v", cred, err)
+	}
+
+	// 新文件无效时返回错误，不使用旧凭证
+	writeCredentialFile(t, path, `{"secret_id":"id-3"}`, mod.Add(2*time.Minute))
+	if _, err := f.Credential(); err == nil {
+		t.Fatal("expected error for file without secret_key")
+	}
+}
+
+func TestFileExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	expiration := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	writeCredentialFile(t, path, `{"secret_id":"id","secret_key":"key","expiration":"`+expiration+`"}`, time.Now())
+
+	if _, err := NewFile(path).Credential(); err == nil {
+		t.Fatal("expected error for expired credential")
+	}
+	if _, err := NewFile(filepath.Join(t.TempDir(), "missing.json")).Credential(); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
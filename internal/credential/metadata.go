@@ -0,0 +1,102 @@
+package credential
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+// DefaultMetadataEndpoint CVM实例元数据服务地址
+const DefaultMetadataEndpoint = "http://metadata.tencentyun.com/latest"
+
+// Metadata 从CVM实例元数据获取实例绑定角色的临时凭证
+type Metadata struct {
+	endpoint   string
+	roleName   string
+	httpClient *http.Client
+	cache      *cache
+}
+
+// NewMetadata 创建实例角色凭证
+// endpoint为空时使用DefaultMetadataEndpoint（测试时可指向本地服务），roleName为空时自动查询实例绑定的角色
+func NewMetadata(endpoint, roleName string) *Metadata {
+	if endpoint == "" {
+		endpoint = DefaultMetadataEndpoint
+	}
+	p := &Metadata{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		roleName:   roleName,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+	p.cache = &cache{name: "Metadata", fetch: p.fetch, now: time.Now}
+	return p
+}
+
+// Credential 实现tencentcloud.CredentialProvider
+func (p *Metadata) Credential() (tencentcloud.Credential, error) {
+	return p.cache.get()
+}
+
+// fetch 查询实例角色的临时凭证
+func (p *Metadata) fetch() (tencentcloud.Credential, error) {
+	role := p.roleName
+	if role == "" {
+		body, err := p.get("/meta-data/cam/security-credentials/")
+		if err != nil {
+			return tencentcloud.Credential{}, err
+		}
+		role = strings.TrimSpace(strings.SplitN(string(body), "\n", 2)[0])
+		if role == "" {
+			return tencentcloud.Credential{}, fmt.Errorf("实例未绑定CAM角色")
+		}
+	}
+
+	body, err := p.get("/meta-data/cam/security-credentials/" + role)
+	if err != nil {
+		return tencentcloud.Credential{}, err
+	}
+	var result struct {
+		TmpSecretId  string `json:"TmpSecretId"`
+		TmpSecretKey string `json:"TmpSecretKey"`
+		Token        string `json:"Token"`
+		ExpiredTime  int64  `json:"ExpiredTime"`
+		Code         string `json:"Code"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return tencentcloud.Credential{}, fmt.Errorf("解析实例角色凭证失败: %w", err)
+	}
+	if result.Code != "" && result.Code != "Success" {
+		return tencentcloud.Credential{}, fmt.Errorf("获取实例角色凭证失败: %s", result.Code)
+	}
+	if result.TmpSecretId == "" || result.TmpSecretKey == "" {
+		return tencentcloud.Credential{}, fmt.Errorf("实例角色 %s 的凭证为空", role)
+	}
+	return tencentcloud.Credential{
+		SecretID:  result.TmpSecretId,
+		SecretKey: result.TmpSecretKey,
+		Token:     result.Token,
+		ExpiresAt: expiresAt(result.ExpiredTime),
+	}, nil
+}
+
+// get 请求元数据服务
+func (p *Metadata) get(path string) ([]byte, error) {
+	resp, err := p.httpClient.Get(p.endpoint + path)
+	if err != nil {
+		return nil, fmt.Errorf("请求实例元数据失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取实例元数据失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("请求实例元数据失败: HTTP %d", resp.StatusCode)
+	}
+	return body, nil
+}
@@ -0,0 +1,73 @@
+package credential
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// metadataServer 模拟CVM元数据服务，role为实例绑定的角色
+func metadataServer(t *testing.T, role, code string, expire time.Time) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/meta-data/cam/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[len("/latest/meta-data/cam/security-credentials/"):]
+		switch name {
+		case "":
+			if role == "" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintln(w, role)
+		case role:
+			fmt.Fprintf(w, `{"TmpSecretId":"tmp-id","TmpSecretKey":"tmp-key","Token":"tmp-token","ExpiredTime":%d,"Code":%q}`,
+				expire.Unix(), code)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMetadata(t *testing.T) {
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	srv := metadataServer(t, "gateway-role", "Success", expire)
+
+	for _, roleName := range []string{"", "gateway-role"} {
+		p := NewMetadata(srv.URL+"/latest/", roleName)
+		cred, err := p.Credential()
+		if err != nil {
+			t.Fatalf("role %q: %v", roleName, err)
+		}
+		if cred.SecretID != "tmp-id" || cred.SecretKey != "tmp-key" || cred.Token != "tmp-token" {
+			t.Errorf("role %q: credential = %+v", roleName, cred)
+		}
+		if !cred.ExpiresAt.Equal(expire) {
+			t.Errorf("role %q: ExpiresAt = %v, want %v", roleName, cred.ExpiresAt, expire)
+		}
+	}
+}
+
+func TestMetadataErrors(t *testing.T) {
+	expire := time.Now().Add(time.Hour)
+	tests := []struct {
+		name     string
+		role     string
+		code     string
+		roleName string
+	}{
+		{name: "no role bound", role: ""},
+		{name: "unknown role", role: "gateway-role", roleName: "other-role"},
+		{name: "error code", role: "gateway-role", code: "Failed"},
+	}
+	for _, tt := range tests {
+		srv := metadataServer(t, tt.role, tt.code, expire)
+		if _, err := NewMetadata(srv.URL+"/latest", tt.roleName).Credential(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package credential 实现tencentcloud.CredentialProvider
+//
+// 除固定的SecretId/SecretKey外，还支持临时凭证（STS扮演角色、CVM实例角色），
+// 临时凭证带有Token，并在过期前自动刷新
+package credential
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+var logger = logging.Component("credential")
+
+// refreshBefore 临时凭证在过期前多久刷新
+const refreshBefore = 5 * time.Minute
+
+// expiring 是否即将过期
+func expiring(c tencentcloud.Credential, now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.Add(refreshBefore).After(c.ExpiresAt)
+}
+
+// expired 是否已过期
+func expired(c tencentcloud.Credential, now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
+}
+
+// expiresAt 把接口返回的Unix时间戳转换为过期时间，0表示不过期
+func expiresAt(unix int64) time.Time {
+	if unix <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
+// Static 固定凭证
+type Static tencentcloud.Credential
+
+// NewStatic 创建固定凭证，token为空表示长期凭证
+func NewStatic(secretID, secretKey, token string) Static {
+	return Static{SecretID: secretID, SecretKey: secretKey, Token: token}
+}
+
+// Credential 实现tencentcloud.CredentialProvider
+func (s Static) Credential() (tencentcloud.Credential, error) {
+	if s.SecretID == "" || s.SecretKey == "" {
+		return tencentcloud.Credential{}, errors.New("未配置SecretId或SecretKey")
+	}
+	return tencentcloud.Credential(s), nil
+}
+
+// Env 从腾讯云SDK通用的环境变量读取凭证：
+// TENCENTCLOUD_SECRET_ID、TENCENTCLOUD_SECRET_KEY、TENCENTCLOUD_SESSION_TOKEN
+type Env struct{}
+
+// Credential 实现tencentcloud.CredentialProvider
+func (Env) Credential() (tencentcloud.Credential, error) {
+	cred := tencentcloud.Credential{
+		SecretID:  os.Getenv("TENCENTCLOUD_SECRET_ID"),
+		SecretKey: os.Getenv("TENCENTCLOUD_SECRET_KEY"),
+		Token:     os.Getenv("TENCENTCLOUD_SESSION_TOKEN"),
+	}
+	if cred.SecretID == "" || cred.SecretKey == "" {
+		return tencentcloud.Credential{}, errors.New("未设置环境变量 TENCENTCLOUD_SECRET_ID 或 TENCENTCLOUD_SECRET_KEY")
+	}
+	return cred, nil
+}
+
+// cache 缓存有过期时间的凭证，过期前refreshBefore重新获取
+// 即将过期时在后台刷新并继续返回旧凭证，只有没有可用凭证时才等待刷新完成；
+// 获取凭证需要网络请求，不在持有锁时进行，同一时间只有一个刷新
+type cache struct {
+	name  string
+	fetch func() (tencentcloud.Credential, error)
+	now   func() time.Time
+
+	mu         sync.Mutex
+	cred       tencentcloud.Credential
+	err        error         // 最近一次刷新的错误
+	refreshing chan struct{} // 刷新进行中时非nil，刷新结束后关闭
+}
+
+// get 返回缓存的凭证，需要时刷新
+func (c *cache) get() (tencentcloud.Credential, error) {
+	c.mu.Lock()
+	now := c.now()
+	if c.cred.SecretID != "" && !expiring(c.cred, now) {
+		defer c.mu.Unlock()
+		return c.cred, nil
+	}
+	done := c.refresh()
+	if c.cred.SecretID != "" && !expired(c.cred, now) {
+		defer c.mu.Unlock()
+		return c.cred, nil
+	}
+	c.mu.Unlock()
+
+	<-done
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil && (c.cred.SecretID == "" || expired(c.cred, c.now())) {
+		return tencentcloud.Credential{}, c.err
+	}
+	return c.cred, nil
+}
+
+// refresh 启动后台刷新，已有刷新进行中时复用，返回刷新结束时关闭的channel，调用方需持有锁
+func (c *cache) refresh() <-chan struct{} {
+	if c.refreshing != nil {
+		return c.refreshing
+	}
+	done := make(chan struct{})
+	c.refreshing = done
+
+	go func() {
+		defer close(done)
+		cred, err := c.fetch()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.refreshing = nil
+		c.err = err
+		if err != nil {
+			if c.cred.SecretID != "" && !expired(c.cred, c.now()) {
+				logger.Warn("刷新凭证失败，继续使用旧凭证", "provider", c.name, "error", err)
+			}
+			return
+		}
+		c.cred = cred
+		if cred.ExpiresAt.IsZero() {
+			logger.Info("已获取凭证", "provider", c.name)
+		} else {
+			logger.Info("已获取临时凭证", "provider", c.name, "expire", cred.ExpiresAt.Format(time.RFC3339))
+		}
+	}()
+	return done
+}
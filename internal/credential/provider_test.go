@@ -0,0 +1,182 @@
+package credential
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+// testCache 返回使用可控时钟的cache，fetch依次返回results中的结果
+func testCache(now *time.Time, results ...func() (tencentcloud.Credential, error)) (*cache, *int32) {
+	var calls int32
+	c := &cache{
+		name: "test",
+		now:  func() time.Time { return *now },
+	}
+	c.fetch = func() (tencentcloud.Credential, error) {
+		i := atomic.AddInt32(&calls, 1) - 1
+		if int(i) >= len(results) {
+			return tencentcloud.Credential{}, errors.New("unexpected fetch")
+		}
+		return results[i]()
+	}
+	return c, &calls
+}
+
+func credUntil(id string, t time.Time) func() (tencentcloud.Credential, error) {
+	return func() (tencentcloud.Credential, error) {
+		return tencentcloud.Credential{SecretID: id, SecretKey: "key", ExpiresAt: t}, nil
+	}
+}
+
+func fetchError() (tencentcloud.Credential, error) {
+	return tencentcloud.Credential{}, errors.New("metadata unavailable")
+}
+
+// waitRefresh 等待后台刷新结束
+func waitRefresh(c *cache) {
+	c.mu.Lock()
+	done := c.refreshing
+	c.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	c, calls := testCache(&now,
+		credUntil("first", now.Add(time.Hour)),
+		credUntil("second", now.Add(2*time.Hour)),
+	)
+
+	cred, err := c.get()
+	if err != nil || cred.SecretID != "first" {
+		t.Fatalf("get = %q, %v; want first", cred.SecretID, err)
+	}
+	if cred, _ := c.get(); cred.SecretID != "first" || *calls != 1 {
+		t.Fatalf("cached get = %q after %d fetches", cred.SecretID, *calls)
+	}
+
+	// 进入刷新窗口后先返回旧凭证，后台刷新完成后返回新凭证
+	now = now.Add(time.Hour - refreshBefore + time.Second)
+	if cred, _ := c.get(); cred.SecretID != "first" {
+		t.Fatalf("expiring get = %q, want first", cred.SecretID)
+	}
+	waitRefresh(c)
+	if cred, _ := c.get(); cred.SecretID != "second" {
+		t.Fatalf("refreshed get = %q, want second", cred.SecretID)
+	}
+	if *calls != 2 {
+		t.Errorf("fetch called %d times, want 2", *calls)
+	}
+}
+
+func TestCacheFallback(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	expiry := now.Add(time.Hour)
+	c, _ := testCache(&now, credUntil("first", expiry), fetchError, fetchError)
+
+	if _, err := c.get(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 刷新失败但旧凭证尚未过期时继续使用
+	now = expiry.Add(-time.Minute)
+	if cred, err := c.get(); err != nil || cred.SecretID != "first" {
+		t.Fatalf("get during failed refresh = %q, %v; want first", cred.SecretID, err)
+	}
+	waitRefresh(c)
+
+	// 旧凭证过期后返回刷新错误
+	now = expiry
+	if _, err := c.get(); err == nil {
+		t.Fatal("expired credential returned after failed refresh")
+	}
+}
+
+func TestCacheFetchesOutsideLock(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	c, calls := testCache(&now,
+		credUntil("first", now.Add(time.Hour)),
+		func() (tencentcloud.Credential, error) {
+			close(started)
+			<-release
+			return credUntil("second", now.Add(2*time.Hour))()
+		},
+	)
+	if _, err := c.get(); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Hour - time.Minute)
+	c.get()
+	<-started
+
+	// 刷新阻塞期间其他调用不等待
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cred, err := c.get(); err != nil || cred.SecretID != "first" {
+				t.Errorf("get during refresh = %q, %v; want first", cred.SecretID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+	waitRefresh(c)
+
+	if *calls != 2 {
+		t.Errorf("fetch called %d times, want 2", *calls)
+	}
+}
+
+func TestCacheWaitsWithoutCredential(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	c, calls := testCache(&now, func() (tencentcloud.Credential, error) {
+		time.Sleep(10 * time.Millisecond)
+		return credUntil("first", now.Add(time.Hour))()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cred, err := c.get(); err != nil || cred.SecretID != "first" {
+				t.Errorf("get = %q, %v; want first", cred.SecretID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if *calls != 1 {
+		t.Errorf("fetch called %d times, want 1", *calls)
+	}
+}
+
+func TestNewAssumeRoleDuration(t *testing.T) {
+	source := NewStatic("id", "key", "")
+	tests := []struct {
+		duration time.Duration
+		ok       bool
+	}{
+		{time.Hour, true},
+		{MaxAssumeRoleDuration, true},
+		{MaxAssumeRoleDuration + time.Second, false},
+		{refreshBefore, false},
+	}
+	for _, tt := range tests {
+		_, err := NewAssumeRole(source, "role", "session", tt.duration)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewAssumeRole(%s) err = %v, want ok=%v", tt.duration, err, tt.ok)
+		}
+	}
+}
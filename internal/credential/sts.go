@@ -0,0 +1,68 @@
+package credential
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+// MaxAssumeRoleDuration STS临时凭证的最长有效期
+const MaxAssumeRoleDuration = 12 * time.Hour
+
+// AssumeRole 通过STS扮演角色获取临时凭证
+type AssumeRole struct {
+	sts         *tencentcloud.Client
+	roleArn     string
+	sessionName string
+	duration    time.Duration
+	cache       *cache
+}
+
+// NewAssumeRole 创建STS扮演角色凭证，source为调用STS使用的凭证，duration为临时凭证有效期（5分钟到12小时）
+func NewAssumeRole(source tencentcloud.CredentialProvider, roleArn, sessionName string, duration time.Duration) (*AssumeRole, error) {
+	if duration <= refreshBefore || duration > MaxAssumeRoleDuration {
+		return nil, fmt.Errorf("临时凭证有效期需大于%d分钟且不超过%d小时", int(refreshBefore.Minutes()), int(MaxAssumeRoleDuration.Hours()))
+	}
+	p := &AssumeRole{
+		sts:         tencentcloud.NewClient("sts", "2018-08-13", source),
+		roleArn:     roleArn,
+		sessionName: sessionName,
+		duration:    duration,
+	}
+	p.cache = &cache{name: "AssumeRole", fetch: p.assume, now: time.Now}
+	return p, nil
+}
+
+// Credential 实现tencentcloud.CredentialProvider
+func (p *AssumeRole) Credential() (tencentcloud.Credential, error) {
+	return p.cache.get()
+}
+
+// assume 调用STS AssumeRole
+func (p *AssumeRole) assume() (tencentcloud.Credential, error) {
+	var result struct {
+		Credentials struct {
+			Token        string `json:"Token"`
+			TmpSecretId  string `json:"TmpSecretId"`
+			TmpSecretKey string `json:"TmpSecretKey"`
+		} `json:"Credentials"`
+		ExpiredTime int64 `json:"ExpiredTime"`
+	}
+	_, err := p.sts.Call("AssumeRole", map[string]any{
+		"RoleArn":         p.roleArn,
+		"RoleSessionName": p.sessionName,
+		"DurationSeconds": int(p.duration.Seconds()),
+	}, &result)
+	if err != nil {
+		return tencentcloud.Credential{}, fmt.Errorf("扮演角色失败: %w", err)
+	}
+
+	c := result.Credentials
+	return tencentcloud.Credential{
+		SecretID:  c.TmpSecretId,
+		SecretKey: c.TmpSecretKey,
+		Token:     c.Token,
+		ExpiresAt: expiresAt(result.ExpiredTime),
+	}, nil
+}
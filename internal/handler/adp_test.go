@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/credential"
+)
+
+// fakeADP 本地模拟的ADP服务，提供GetWsToken接口和对话WebSocket
+type fakeADP struct {
+	// frames 根据请求返回要依次发送的Socket.IO消息，返回nil或遇到空字符串时断开连接
+	frames func(requestID, content string) []string
+
+	mu       sync.Mutex
+	contents []string
+}
+
+// newFakeADP 启动模拟服务并返回连接到它的客户端
+func newFakeADP(t *testing.T, frames func(requestID, content string) []string) (*fakeADP, *adp.Client) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	f := &fakeADP{frames: frames}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Response":{"Token":"token","ExpiredTime":%d,"RequestId":"r"}}`, time.Now().Add(time.Hour).Unix())
+	})
+	mux.HandleFunc("/ws", f.serveWS)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := adp.NewClient(credential.NewStatic("id", "key", ""), "bot-app-key")
+	client.SetEndpoints(srv.URL, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws")
+	t.Cleanup(client.Disconnect)
+	return f, client
+}
+
+// sent 返回收到的消息内容
+func (f *fakeADP) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.contents...)
+}
+
+func (f *fakeADP) serveWS(w http.ResponseWriter, r *http.Request) {
+	var upgrader websocket.Upgrader
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`0{"sid":"sid"}`))
+	if _, msg, err := conn.ReadMessage(); err != nil || !strings.HasPrefix(string(msg), "40") {
+		return
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("40"))
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var event []json.RawMessage
+		if !strings.HasPrefix(string(msg), `42["send",`) || json.Unmarshal(msg[2:], &event) != nil || len(event) < 2 {
+			continue
+		}
+		var send struct {
+			Payload struct {
+				RequestID string `json:"request_id"`
+				Content   string `json:"content"`
+			} `json:"payload"`
+		}
+		json.Unmarshal(event[1], &send)
+		f.mu.Lock()
+		f.contents = append(f.contents, send.Payload.Content)
+		f.mu.Unlock()
+
+		frames := f.frames(send.Payload.RequestID, send.Payload.Content)
+		if frames == nil {
+			return
+		}
+		for _, frame := range frames {
+			if frame == "" {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+	}
+}
+
+// replyFrames 按增量片段生成ADP的reply事件，content为累计内容，最后一条is_final
+func replyFrames(requestID string, chunks ...string) []string {
+	var frames []string
+	content := ""
+	for i, chunk := range chunks {
+		content += chunk
+		payload, _ := json.Marshal(gin.H{"payload": gin.H{
+			"request_id": requestID,
+			"record_id":  "record-1",
+			"content":    content,
+			"can_rating": true,
+			"is_final":   i == len(chunks)-1,
+		}})
+		frames = append(frames, fmt.Sprintf(`42["reply",%s]`, payload))
+	}
+	return frames
+}
+
+// referenceFrame 生成ADP的reference事件，需在最终回复之前发送
+func referenceFrame(requestID string, refs ...adp.Reference) string {
+	payload, _ := json.Marshal(gin.H{"payload": gin.H{"request_id": requestID, "references": refs}})
+	return fmt.Sprintf(`42["reference",%s]`, payload)
+}
+
+// sseData 解析SSE响应中的data行，不含[DONE]
+func sseData(t *testing.T, body string) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var v map[string]any
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			t.Fatalf("invalid SSE data %q: %v", data, err)
+		}
+		events = append(events, v)
+	}
+	return events
+}
+
+func TestADPErrorEventFailsRequest(t *testing.T) {
+	_, client := newFakeADP(t, func(requestID, content string) []string {
+		return []string{fmt.Sprintf(`42["error",{"payload":{"request_id":%q,"error":{"code":460004,"message":"quota exceeded"}}}]`, requestID)}
+	})
+	h := NewOpenAIHandler(client, Config{Models: Models{defaultModel: {}}})
+	r := gin.New()
+	r.POST("/v1/chat/completions", h.ChatCompletions)
+
+	for _, stream := range []bool{false, true} {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+			strings.NewReader(fmt.Sprintf(`{"messages":[{"role":"user","content":"hi"}],"stream":%t}`, stream))))
+		// 请求应立即以错误结束，而不是等到超时
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("stream=%t: request took %v", stream, elapsed)
+		}
+		if w.Code == http.StatusOK || !strings.Contains(w.Body.String(), "460004") || !strings.Contains(w.Body.String(), "quota exceeded") {
+			t.Errorf("stream=%t: status = %d, body = %s, want the ADP error", stream, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// CitationMode 参考来源的输出方式
+type CitationMode string
+
+const (
+	// CitationAnnotations 以 message.annotations（url_citation）返回
+	CitationAnnotations CitationMode = "annotations"
+	// CitationFootnotes 在正文中插入 [n] 标记并在末尾附上来源列表，适用于无法展示annotations的客户端
+	CitationFootnotes CitationMode = "footnotes"
+	// CitationOff 不返回参考来源
+	CitationOff CitationMode = "off"
+)
+
+// ParseCitationMode 解析配置中的引用模式，空值为annotations
+func ParseCitationMode(s string) (CitationMode, error) {
+	switch mode := CitationMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return CitationAnnotations, nil
+	case CitationAnnotations, CitationFootnotes, CitationOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("未知的引用模式: %s", s)
+}
+
+// quotedReference 返回引用标记对应的来源，Index从1开始
+func quotedReference(refs []adp.Reference, q adp.QuoteInfo) (adp.Reference, bool) {
+	if q.Index < 1 || q.Index > len(refs) {
+		return adp.Reference{}, false
+	}
+	return refs[q.Index-1], true
+}
+
+// clampPosition 把引用位置限制在内容长度内（按字符计）
+func clampPosition(pos, length int) int {
+	if pos < 0 {
+		return 0
+	}
+	if pos > length {
+		return length
+	}
+	return pos
+}
+
+// urlCitations 构建url_citation类型的annotations
+// ADP只给出标记位置，没有被引用的文本范围，因此start_index与end_index相同；
+// 未被正文引用的来源标注在内容末尾。没有URL的来源（如问答对）无法表示为url_citation
+func urlCitations(content string, refs []adp.Reference, quotes []adp.QuoteInfo) []gin.H {
+	if len(refs) == 0 {
+		return nil
+	}
+	length := len([]rune(content))
+
+	annotation := func(ref adp.Reference, pos int) gin.H {
+		return gin.H{
+			"type": "url_citation",
+			"url_citation": gin.H{
+				"start_index": pos,
+				"end_index":   pos,
+				"url":         ref.URL,
+				"title":       ref.Title(),
+			},
+		}
+	}
+
+	annotations := make([]gin.H, 0, len(refs))
+	quoted := make(map[int]bool, len(quotes))
+	for _, q := range quotes {
+		ref, ok := quotedReference(refs, q)
+		if !ok {
+			continue
+		}
+		quoted[q.Index] = true
+		if ref.URL != "" {
+			annotations = append(annotations, annotation(ref, clampPosition(q.Position, length)))
+		}
+	}
+	for i, ref := range refs {
+		if !quoted[i+1] && ref.URL != "" {
+			annotations = append(annotations, annotation(ref, length))
+		}
+	}
+	return annotations
+}
+
+// renderFootnotes 渲染脚注：inline为true时在引用位置插入 [n]，并在末尾列出全部来源
+// 流式输出时正文已经发出，只能追加来源列表
+func renderFootnotes(content string, refs []adp.Reference, quotes []adp.QuoteInfo, inline bool) string {
+	if len(refs) == 0 {
+		return content
+	}
+
+	if inline && len(quotes) > 0 {
+		runes := []rune(content)
+		sorted := make([]adp.QuoteInfo, len(quotes))
+		copy(sorted, quotes)
+		// 从后往前插入，前面的位置不受影响
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Position > sorted[j].Position })
+
+		for _, q := range sorted {
+			if _, ok := quotedReference(refs, q); !ok {
+				continue
+			}
+			pos := clampPosition(q.Position, len(runes))
+			marker := []rune(fmt.Sprintf("[%d]", q.Index))
+			runes = append(runes[:pos], append(marker, runes[pos:]...)...)
+		}
+		content = string(runes)
+	}
+
+	return content + footnoteList(refs)
+}
+
+// footnoteList 来源列表
+func footnoteList(refs []adp.Reference) string {
+	var sb strings.Builder
+	sb.WriteString("\n\nReferences:\n")
+	for i, ref := range refs {
+		if ref.URL != "" {
+			sb.WriteString(fmt.Sprintf("[%d] [%s](%s)\n", i+1, ref.Title(), ref.URL))
+		} else {
+			sb.WriteString(fmt.Sprintf("[%d] %s\n", i+1, ref.Title()))
+		}
+	}
+	return sb.String()
+}
+
+// emitCitations 流式输出结束前输出参考来源
+// 回复被识别为工具调用时不输出
+func (h *OpenAIHandler) emitCitations(chunk adp.Chunk, tools *toolCallStream, emit func(string), write func(delta gin.H, finishReason any)) {
+	if len(chunk.References) == 0 || (tools != nil && tools.inBlock) {
+		return
+	}
+
+	switch h.cfg.Citations {
+	case CitationFootnotes:
+		emit(footnoteList(chunk.References))
+	case CitationAnnotations:
+		if annotations := urlCitations(chunk.Content, chunk.References, chunk.Quotes); len(annotations) > 0 {
+			write(gin.H{"annotations": annotations}, nil)
+		}
+	}
+}
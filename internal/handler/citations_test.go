@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+var testReferences = []adp.Reference{
+	{Name: "Guide", URL: "https://example.com/guide"},
+	{Name: "FAQ"}, // 问答对没有URL
+	{DocName: "manual.pdf", URL: "https://example.com/manual.pdf"},
+}
+
+func TestParseCitationMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CitationMode
+		wantErr bool
+	}{
+		{"", CitationAnnotations, false},
+		{"annotations", CitationAnnotations, false},
+		{" Footnotes ", CitationFootnotes, false},
+		{"OFF", CitationOff, false},
+		{"inline", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCitationMode(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseCitationMode(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestURLCitations(t *testing.T) {
+	content := "你好世界"
+	quotes := []adp.QuoteInfo{
+		{Index: 1, Position: 2},
+		{Index: 2, Position: 4},  // 没有URL，不能表示为url_citation
+		{Index: 9, Position: 1},  // 不存在的来源
+		{Index: 1, Position: 99}, // 超出内容长度
+	}
+	got := urlCitations(content, testReferences, quotes)
+
+	var positions []string
+	for _, a := range got {
+		c := a["url_citation"].(gin.H)
+		if c["start_index"] != c["end_index"] {
+			t.Errorf("annotation %v has a range", c)
+		}
+		positions = append(positions, fmt.Sprintf("%s@%d", c["title"], c["start_index"]))
+	}
+	// 被引用的来源在引用位置（按字符计），未被引用的来源在末尾
+	want := "Guide@2 Guide@4 manual.pdf@4"
+	if strings.Join(positions, " ") != want {
+		t.Errorf("annotations = %v, want %s", positions, want)
+	}
+
+	if got := urlCitations(content, nil, quotes); got != nil {
+		t.Errorf("urlCitations without references = %v", got)
+	}
+}
+
+func TestRenderFootnotes(t *testing.T) {
+	quotes := []adp.QuoteInfo{{Index: 2, Position: 4}, {Index: 1, Position: 2}, {Index: 7, Position: 1}}
+	list := "\n\nReferences:\n[1] [Guide](https://example.com/guide)\n[2] FAQ\n[3] [manual.pdf](https://example.com/manual.pdf)\n"
+
+	if got := renderFootnotes("你好世界", testReferences, quotes, true); got != "你好[1]世界[2]"+list {
+		t.Errorf("inline = %q", got)
+	}
+	if got := renderFootnotes("你好世界", testReferences, quotes, false); got != "你好世界"+list {
+		t.Errorf("list only = %q", got)
+	}
+	if got := renderFootnotes("你好世界", nil, quotes, true); got != "你好世界" {
+		t.Errorf("without references = %q", got)
+	}
+}
+
+// citationFrames 回复前分两次发送参考来源，最终回复带有引用标记
+func citationFrames(requestID, content string) []string {
+	frames := []string{
+		referenceFrame(requestID, testReferences[:1]...),
+		referenceFrame(requestID, testReferences[1:]...),
+	}
+	frames = append(frames, replyFrames(requestID, "你好", "世界")...)
+	payload, _ := json.Marshal(gin.H{"payload": gin.H{
+		"request_id":  requestID,
+		"record_id":   "record-1",
+		"content":     "你好世界",
+		"can_rating":  true,
+		"is_final":    true,
+		"quote_infos": []adp.QuoteInfo{{Index: 1, Position: 2}},
+	}})
+	// 最终回复带上引用标记
+	frames[len(frames)-1] = fmt.Sprintf(`42["reply",%s]`, payload)
+	return frames
+}
+
+func TestChatCitationModes(t *testing.T) {
+	_, client := newFakeADP(t, citationFrames)
+	list := "\n\nReferences:\n[1] [Guide](https://example.com/guide)\n[2] FAQ\n[3] [manual.pdf](https://example.com/manual.pdf)\n"
+
+	tests := []struct {
+		mode            CitationMode
+		stream          bool
+		wantContent     string
+		wantAnnotations int
+	}{
+		{CitationAnnotations, false, "你好世界", 2},
+		{CitationFootnotes, false, "你好[1]世界" + list, 0},
+		{CitationOff, false, "你好世界", 0},
+		{CitationAnnotations, true, "你好世界", 2},
+		// 流式输出时正文已经发出，只追加来源列表，编号与跨事件累计的来源顺序一致
+		{CitationFootnotes, true, "你好世界" + list, 0},
+		{CitationOff, true, "你好世界", 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s stream=%v", tt.mode, tt.stream), func(t *testing.T) {
+			h := NewOpenAIHandler(client, Config{Models: Models{defaultModel: {}}, Citations: tt.mode})
+			r := gin.New()
+			r.POST("/v1/chat/completions", h.ChatCompletions)
+			body := fmt.Sprintf(`{"messages":[{"role":"user","content":"hi"}],"stream":%v}`, tt.stream)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+			}
+
+			var content strings.Builder
+			annotations := 0
+			if tt.stream {
+				for _, event := range sseData(t, w.Body.String()) {
+					delta := event["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)
+					if text, ok := delta["content"].(string); ok {
+						content.WriteString(text)
+					}
+					if a, ok := delta["annotations"].([]any); ok {
+						annotations += len(a)
+					}
+				}
+			} else {
+				var resp struct {
+					Choices []struct {
+						Message struct {
+							Content     string `json:"content"`
+							Annotations []any  `json:"annotations"`
+						} `json:"message"`
+					} `json:"choices"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				content.WriteString(resp.Choices[0].Message.Content)
+				annotations = len(resp.Choices[0].Message.Annotations)
+			}
+
+			if content.String() != tt.wantContent {
+				t.Errorf("content = %q, want %q", content.String(), tt.wantContent)
+			}
+			if annotations != tt.wantAnnotations {
+				t.Errorf("annotations = %d, want %d", annotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
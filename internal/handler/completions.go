@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// CompletionRequest 文本补全请求（旧版 /v1/completions）
+type CompletionRequest struct {
+	Model  string `json:"model"`
+	Prompt any    `json:"prompt"` // string 或 []string
+	Suffix string `json:"suffix"`
+	Echo   bool   `json:"echo"`
+	Stream bool   `json:"stream"`
+
+	Stop      any  `json:"stop"`
+	MaxTokens *int `json:"max_tokens"`
+
+	User       string      `json:"user"`
+	ADPOptions *ADPOptions `json:"adp_options"`
+
+	stops   []string
+	visitor visitor
+	options ADPOptions
+	client  *adp.Client
+	ctx     context.Context
+	metrics *requestMetrics
+}
+
+// chatOptions 补充访客信息和ADP选项，未指定Context时使用请求的ctx
+func (r *CompletionRequest) chatOptions(opts adp.ChatOptions) adp.ChatOptions {
+	if opts.Context == nil {
+		opts.Context = r.ctx
+	}
+	return r.options.apply(r.visitor.apply(opts))
+}
+
+// limiter 返回网关侧的stop/max_tokens限制，每个prompt使用独立的限制器
+func (r *CompletionRequest) limiter() *outputLimiter {
+	maxTokens := 0
+	if r.MaxTokens != nil {
+		maxTokens = *r.MaxTokens
+	}
+	return newOutputLimiter(r.stops, maxTokens)
+}
+
+// Completions 处理文本补全请求
+func (h *OpenAIHandler) Completions(c *gin.Context) {
+	m := trackRequest("completions")
+	defer m.done(c)
+
+	var req CompletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorBody("Invalid request body", "invalid_request_error", "invalid_request"))
+		return
+	}
+	model := req.Model
+	if model == "" {
+		model = defaultModel
+	}
+	m.setModel(h.cfg.Models, model, req.Stream)
+	req.metrics = m
+	if !h.cfg.Models.exists(model) {
+		c.JSON(modelNotFound(model))
+		return
+	}
+
+	prompts, err := parsePrompts(req.Prompt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_prompt"))
+		return
+	}
+
+	stops, err := parseStop(req.Stop)
+	if err == nil {
+		err = validateMaxTokens("max_tokens", req.MaxTokens)
+	}
+	if err == nil {
+		req.options, err = h.cfg.Models.resolve(model, req.ADPOptions)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_parameter"))
+		return
+	}
+	req.stops = stops
+	req.visitor = h.parseVisitor(c.Request.Context(), c.Request.Header, req.User, nil)
+	req.client = clientFor(c, h.client)
+	req.ctx = c.Request.Context()
+
+	requestID := fmt.Sprintf("cmpl-%s", uuid.New().String())
+	created := time.Now().Unix()
+
+	if req.Stream {
+		h.handleCompletionStream(c, req, prompts, requestID, created, model)
+	} else {
+		h.handleCompletion(c, req, prompts, requestID, created, model)
+	}
+}
+
+func (h *OpenAIHandler) handleCompletion(c *gin.Context, req CompletionRequest, prompts []string, requestID string, created int64, model string) {
+	choices := make([]gin.H, 0, len(prompts))
+	for i, prompt := range prompts {
+		messages := completionMessages(prompt, req.Suffix)
+		var text string
+		finishReason := "stop"
+		var err error
+		var result *adp.ChatResult
+		if limiter := req.limiter(); limiter != nil {
+			result, finishReason, err = h.chatLimited(c.Request.Context(), req.client, messages, req.chatOptions(adp.ChatOptions{}), limiter)
+		} else {
+			result, err = req.client.Chat(messages, req.chatOptions(adp.ChatOptions{
+				Stream: false,
+			}))
+		}
+		if result != nil {
+			text = result.Content
+		}
+		if err != nil {
+			logger.WarnContext(c.Request.Context(), "补全请求失败", "error", err)
+			c.JSON(chatErrorResponse(err))
+			return
+		}
+
+		if req.Echo {
+			text = prompt + text
+		}
+		choices = append(choices, gin.H{
+			"text":          text,
+			"index":         i,
+			"logprobs":      nil,
+			"finish_reason": finishReason,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      requestID,
+		"object":  "text_completion",
+		"created": created,
+		"model":   model,
+		"choices": choices,
+		"usage": gin.H{
+			"prompt_tokens":     0,
+			"completion_tokens": 0,
+			"total_tokens":      0,
+		},
+	})
+}
+
+func (h *OpenAIHandler) handleCompletionStream(c *gin.Context, req CompletionRequest, prompts []string, requestID string, created int64, model string) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorBody("Streaming not supported", "api_error", ""))
+		return
+	}
+
+	// 处理函数返回后gin会回收c，closed之后的输出全部丢弃
+	var writeMu sync.Mutex
+	closed := false
+	writeChunk := func(index int, text string, finishReason any) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if closed {
+			return
+		}
+		data, _ := json.Marshal(gin.H{
+			"id":      requestID,
+			"object":  "text_completion",
+			"created": created,
+			"model":   model,
+			"choices": []gin.H{
+				{
+					"text":          text,
+					"index":         index,
+					"logprobs":      nil,
+					"finish_reason": finishReason,
+				},
+			},
+		})
+		c.Writer.Write([]byte(fmt.Sprintf("data: %s\n\n", data)))
+		flusher.Flush()
+	}
+
+	// 返回前等待仍在运行的生成协程退出
+	var wg sync.WaitGroup
+	defer func() {
+		writeMu.Lock()
+		closed = true
+		writeMu.Unlock()
+		wg.Wait()
+	}()
+
+	// 多个prompt依次生成，按index区分
+	for i, prompt := range prompts {
+		if req.Echo {
+			writeChunk(i, prompt, nil)
+		}
+
+		done := make(chan struct{})
+		errCh := make(chan error, 1)
+		limiter := req.limiter()
+		ctx, cancel := context.WithCancel(c.Request.Context())
+
+		wg.Add(1)
+		go func(index int, prompt string) {
+			defer wg.Done()
+			finished := false
+			finish := func(finishReason string) {
+				writeChunk(index, "", finishReason)
+				finished = true
+				close(done)
+			}
+
+			_, err := req.client.Chat(completionMessages(prompt, req.Suffix), req.chatOptions(adp.ChatOptions{
+				Stream:  true,
+				Context: ctx,
+				OnChunk: func(chunk adp.Chunk) {
+					if finished {
+						return
+					}
+
+					switch chunk.Type {
+					case "content":
+						req.metrics.firstToken()
+						text := chunk.Content
+						stopped := false
+						if limiter != nil {
+							text, stopped = limiter.Feed(text)
+						}
+						if text != "" {
+							writeChunk(index, text, nil)
+						}
+						if stopped {
+							finish(limiter.FinishReason())
+							cancel()
+						}
+					case "done":
+						if limiter != nil {
+							if text := limiter.Flush(); text != "" {
+								writeChunk(index, text, nil)
+							}
+						}
+						finish("stop")
+					}
+				},
+			}))
+
+			// 触发stop/max_tokens后主动取消导致的错误不算失败
+			if err != nil && !(finished && errors.Is(err, context.Canceled)) {
+				errCh <- err
+			}
+		}(i, prompt)
+
+		select {
+		case <-done:
+			cancel()
+		case err := <-errCh:
+			cancel()
+			logger.WarnContext(c.Request.Context(), "流式补全失败", "error", err)
+			// 之前的prompt已经输出时，在流中输出错误
+			writeMu.Lock()
+			writeStreamError(c, flusher, err)
+			closed = true
+			writeMu.Unlock()
+			return
+		case <-c.Request.Context().Done():
+			cancel()
+			return
+		}
+	}
+
+	writeMu.Lock()
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	flusher.Flush()
+	writeMu.Unlock()
+}
+
+// parsePrompts 解析prompt字段，支持字符串和字符串数组
+func parsePrompts(prompt any) ([]string, error) {
+	switch v := prompt.(type) {
+	case string:
+		if v == "" {
+			return nil, errors.New("prompt is required")
+		}
+		return []string{v}, nil
+	case []interface{}:
+		prompts := make([]string, 0, len(v))
+		for _, p := range v {
+			s, ok := p.(string)
+			if !ok {
+				return nil, errors.New("prompt must be a string or an array of strings; token arrays are not supported")
+			}
+			prompts = append(prompts, s)
+		}
+		if len(prompts) == 0 {
+			return nil, errors.New("prompt must not be empty")
+		}
+		return prompts, nil
+	}
+	return nil, errors.New("prompt is required")
+}
+
+// completionMessages 将补全请求转换为单轮对话
+// ADP不支持插入式补全，suffix只能作为提示附加在prompt后
+func completionMessages(prompt, suffix string) []adp.Message {
+	content := prompt
+	if suffix != "" {
+		content = fmt.Sprintf("%s\n\n(Continue the text above. Your output will be followed by the text below, so write only what belongs in between.)\n%s", prompt, suffix)
+	}
+	return []adp.Message{{Role: "user", Content: content}}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParsePrompts(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompt  string
+		want    []string
+		wantErr string
+	}{
+		{name: "string", prompt: `"hello"`, want: []string{"hello"}},
+		{name: "string array", prompt: `["a","b"]`, want: []string{"a", "b"}},
+		{name: "empty string", prompt: `""`, wantErr: "prompt is required"},
+		{name: "missing", prompt: `null`, wantErr: "prompt is required"},
+		{name: "empty array", prompt: `[]`, wantErr: "prompt must not be empty"},
+		{name: "token array", prompt: `[1,2,3]`, wantErr: "token arrays are not supported"},
+		{name: "array of token arrays", prompt: `[[1,2],[3]]`, wantErr: "token arrays are not supported"},
+		{name: "number", prompt: `1`, wantErr: "prompt is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompt any
+			json.Unmarshal([]byte(tt.prompt), &prompt)
+			got, err := parsePrompts(prompt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parsePrompts() err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("parsePrompts() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompletionMessagesSuffix(t *testing.T) {
+	if got := completionMessages("prompt", ""); len(got) != 1 || got[0].Content != "prompt" {
+		t.Errorf("without suffix = %+v", got)
+	}
+	got := completionMessages("prompt", "suffix")
+	content, _ := got[0].Content.(string)
+	if !strings.HasPrefix(content, "prompt\n\n") || !strings.HasSuffix(content, "\nsuffix") {
+		t.Errorf("with suffix = %q, want prompt first and suffix last", content)
+	}
+}
+
+// newCompletionsRouter 返回使用模拟ADP的补全路由，回复为"out-"加上消息的第一行
+func newCompletionsRouter(t *testing.T, fail string) (*fakeADP, *gin.Engine) {
+	f, client := newFakeADP(t, func(requestID, content string) []string {
+		prompt, _, _ := strings.Cut(content, "\n")
+		if prompt == fail {
+			return nil
+		}
+		return replyFrames(requestID, "out-", prompt)
+	})
+	h := NewOpenAIHandler(client, Config{Models: Models{defaultModel: {}}})
+	r := gin.New()
+	r.POST("/v1/completions", h.Completions)
+	return f, r
+}
+
+func TestCompletionsEchoAndSuffix(t *testing.T) {
+	f, r := newCompletionsRouter(t, "")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/completions",
+		strings.NewReader(`{"prompt":["a","b"],"echo":true,"suffix":"END"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Choices []struct {
+			Text         string `json:"text"`
+			Index        int    `json:"index"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Choices) != 2 {
+		t.Fatalf("choices = %+v, want 2", resp.Choices)
+	}
+	for i, want := range []string{"aout-a", "bout-b"} {
+		c := resp.Choices[i]
+		if c.Index != i || c.Text != want || c.FinishReason != "stop" {
+			t.Errorf("choice %d = %+v, want index %d text %q", i, c, i, want)
+		}
+	}
+	for _, content := range f.sent() {
+		if !strings.HasSuffix(content, "\nEND") {
+			t.Errorf("sent %q without suffix", content)
+		}
+	}
+}
+
+func TestCompletionsStreamIndexes(t *testing.T) {
+	_, r := newCompletionsRouter(t, "")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/completions",
+		strings.NewReader(`{"prompt":["a","b"],"echo":true,"stream":true}`)))
+	if !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+		t.Fatalf("stream does not end with [DONE]: %s", w.Body)
+	}
+
+	texts := make([]string, 2)
+	finished := make([]bool, 2)
+	last := 0
+	for _, event := range sseData(t, w.Body.String()) {
+		choice := event["choices"].([]any)[0].(map[string]any)
+		index := int(choice["index"].(float64))
+		if index < last {
+			t.Errorf("index %d after index %d, prompts must be streamed in order", index, last)
+		}
+		last = index
+		texts[index] += choice["text"].(string)
+		if choice["finish_reason"] == "stop" {
+			finished[index] = true
+		}
+	}
+	if texts[0] != "aout-a" || texts[1] != "bout-b" {
+		t.Errorf("texts = %q", texts)
+	}
+	if !finished[0] || !finished[1] {
+		t.Errorf("finished = %v, want both", finished)
+	}
+}
+
+func TestCompletionsStreamLaterPromptFails(t *testing.T) {
+	_, r := newCompletionsRouter(t, "b")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/completions",
+		strings.NewReader(`{"prompt":["a","b"],"stream":true}`)))
+
+	// 第一个prompt已经输出，错误以数据块的形式通知客户端，随后结束流
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 after partial output", w.Code)
+	}
+	if !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+		t.Fatalf("stream does not end with [DONE]: %s", w.Body)
+	}
+	events := sseData(t, w.Body.String())
+	if len(events) == 0 || events[len(events)-1]["error"] == nil {
+		t.Fatalf("last event is not an error: %s", w.Body)
+	}
+	if events[0]["choices"] == nil {
+		t.Errorf("first prompt was not streamed: %s", w.Body)
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// headerRecordID 返回ADP消息ID的响应头，n>1时按choice顺序以逗号分隔
+const headerRecordID = "X-ADP-Record-Id"
+
+// 消息ID只保存在内存中，按时间和数量限制
+const (
+	// recordTTL 回复生成后多久内可以评价
+	recordTTL = 24 * time.Hour
+	// maxRecords 最多保留的消息ID数，超出时丢弃最早的
+	maxRecords = 100000
+)
+
+// recordStore 记录ADP消息ID属于哪个API Key，只允许评价自己的回复
+type recordStore struct {
+	mu     sync.Mutex
+	owners map[string]string
+	queue  []recordEntry // 按登记时间排序，用于过期清理
+}
+
+type recordEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+func newRecordStore() *recordStore {
+	return &recordStore{owners: make(map[string]string)}
+}
+
+// add 登记消息ID，已登记的ID保持原来的owner
+func (s *recordStore) add(owner, recordID string) {
+	if recordID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.owners[recordID]; ok {
+		return
+	}
+	s.owners[recordID] = owner
+	s.queue = append(s.queue, recordEntry{id: recordID, expiresAt: time.Now().Add(recordTTL)})
+	s.purge()
+}
+
+// owns 消息是否由owner的请求生成
+func (s *recordStore) owns(owner, recordID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purge()
+	o, ok := s.owners[recordID]
+	return ok && o == owner
+}
+
+// purge 清理过期和超出数量的消息ID，调用方需持有锁
+func (s *recordStore) purge() {
+	now := time.Now()
+	for len(s.queue) > 0 && (now.After(s.queue[0].expiresAt) || len(s.owners) > maxRecords) {
+		delete(s.owners, s.queue[0].id)
+		s.queue = s.queue[1:]
+	}
+}
+
+// FeedbackRequest 消息评价请求
+type FeedbackRequest struct {
+	RecordID string `json:"record_id"`
+	Rating   string `json:"rating"` // up 或 down
+	Reason   string `json:"reason"` // 点踩原因
+}
+
+// Feedback 把用户对回复的评价提交到ADP
+func (h *OpenAIHandler) Feedback(c *gin.Context) {
+	var req FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorBody("Invalid request body", "invalid_request_error", "invalid_request"))
+		return
+	}
+
+	if req.RecordID == "" {
+		c.JSON(http.StatusBadRequest, errorBody("record_id is required", "invalid_request_error", "invalid_feedback"))
+		return
+	}
+
+	var score int
+	switch req.Rating {
+	case "up":
+		score = adp.RatingUp
+	case "down":
+		score = adp.RatingDown
+	default:
+		c.JSON(http.StatusBadRequest, errorBody(`rating must be "up" or "down"`, "invalid_request_error", "invalid_feedback"))
+		return
+	}
+
+	var reasons []string
+	if reason := strings.TrimSpace(req.Reason); reason != "" {
+		if score != adp.RatingDown {
+			c.JSON(http.StatusBadRequest, errorBody(`reason is only accepted with rating "down"`, "invalid_request_error", "invalid_feedback"))
+			return
+		}
+		reasons = []string{reason}
+	}
+
+	// 只能评价当前Key生成的回复
+	if !h.records.owns(requestOwner(c), req.RecordID) {
+		c.JSON(http.StatusNotFound, errorBody("No such record: "+req.RecordID, "invalid_request_error", "record_not_found"))
+		return
+	}
+
+	if err := clientFor(c, h.client).RateMessage(c.Request.Context(), req.RecordID, score, reasons); err != nil {
+		logger.WarnContext(c.Request.Context(), "提交评价失败", "error", err)
+		c.JSON(chatErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object":    "feedback",
+		"record_id": req.RecordID,
+		"rating":    req.Rating,
+	})
+}
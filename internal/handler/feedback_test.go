@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+func TestRecordStoreOwners(t *testing.T) {
+	s := newRecordStore()
+	s.add("key:a", "rec-1")
+	s.add("key:b", "rec-1") // 已登记的ID不会被其他Key占用
+
+	if !s.owns("key:a", "rec-1") {
+		t.Error("owner does not own its record")
+	}
+	if s.owns("key:b", "rec-1") {
+		t.Error("other key owns the record")
+	}
+	if s.owns("key:a", "rec-unknown") {
+		t.Error("unknown record is owned")
+	}
+}
+
+func TestRecordStoreExpiry(t *testing.T) {
+	s := newRecordStore()
+	s.add("", "rec-old")
+	s.queue[0].expiresAt = time.Now().Add(-time.Second)
+	if s.owns("", "rec-old") {
+		t.Error("expired record is still owned")
+	}
+	if len(s.owners) != 0 || len(s.queue) != 0 {
+		t.Errorf("expired record not purged: %d owners, %d queued", len(s.owners), len(s.queue))
+	}
+}
+
+func TestRecordStoreLimit(t *testing.T) {
+	s := newRecordStore()
+	for i := 0; i <= maxRecords; i++ {
+		s.add("", fmt.Sprintf("rec-%d", i))
+	}
+	if len(s.owners) != maxRecords {
+		t.Fatalf("len = %d, want %d", len(s.owners), maxRecords)
+	}
+	if s.owns("", "rec-0") {
+		t.Error("oldest record was not evicted")
+	}
+	if !s.owns("", fmt.Sprintf("rec-%d", maxRecords)) {
+		t.Error("newest record was evicted")
+	}
+}
+
+func TestUpstreamErrorResponse(t *testing.T) {
+	tests := []struct {
+		code   string
+		status int
+	}{
+		{"InvalidParameter", http.StatusBadRequest},
+		{"InvalidParameterValue.RecordId", http.StatusBadRequest},
+		{"ResourceNotFound", http.StatusBadRequest},
+		{"AuthFailure.SignatureExpire", http.StatusBadGateway},
+		{"InternalError", http.StatusBadGateway},
+		{"RequestLimitExceeded", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		status, _ := chatErrorResponse(fmt.Errorf("评价消息失败: %w", &tencentcloud.Error{Code: tt.code, Message: "msg"}))
+		if status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.code, status, tt.status)
+		}
+	}
+}
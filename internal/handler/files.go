@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// maxUploadSize 上传文件大小上限，测试时可以调小
+var maxUploadSize int64 = adp.MaxFileSize
+
+// uploadOverhead multipart边界、字段头和purpose等其他字段预留的大小
+const uploadOverhead = 1 << 20
+
+// UploadFile 上传文件（multipart/form-data，字段file和purpose）
+func (h *OpenAIHandler) UploadFile(c *gin.Context) {
+	// gin解析multipart时会把文件写入临时文件，先限制请求体大小
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+uploadOverhead)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(fileTooLarge())
+			return
+		}
+		c.JSON(http.StatusBadRequest, errorBody("file is required", "invalid_request_error", "invalid_file"))
+		return
+	}
+	if header.Size > maxUploadSize {
+		c.JSON(fileTooLarge())
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody("failed to read file", "invalid_request_error", "invalid_file"))
+		return
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxUploadSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody("failed to read file", "invalid_request_error", "invalid_file"))
+		return
+	}
+	if int64(len(data)) > maxUploadSize {
+		c.JSON(fileTooLarge())
+		return
+	}
+
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		purpose = "user_data"
+	}
+
+	file, err := clientFor(c, h.client).UploadFile(c.Request.Context(), requestOwner(c), header.Filename, data, purpose)
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "文件上传失败", "error", err)
+		c.JSON(chatErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+// ListFiles 列出已上传的文件
+func (h *OpenAIHandler) ListFiles(c *gin.Context) {
+	files := clientFor(c, h.client).ListFiles(requestOwner(c))
+	data := make([]gin.H, 0, len(files))
+	for _, f := range files {
+		data = append(data, fileObject(f))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// GetFile 获取文件信息
+func (h *OpenAIHandler) GetFile(c *gin.Context) {
+	file, ok := clientFor(c, h.client).GetFile(requestOwner(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, errorBody("No such file: "+c.Param("id"), "invalid_request_error", "file_not_found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+// DeleteFile 删除文件
+func (h *OpenAIHandler) DeleteFile(c *gin.Context) {
+	id := c.Param("id")
+	if !clientFor(c, h.client).DeleteFile(requestOwner(c), id) {
+		c.JSON(http.StatusNotFound, errorBody("No such file: "+id, "invalid_request_error", "file_not_found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "file",
+		"deleted": true,
+	})
+}
+
+// fileObject 转换为OpenAI文件对象
+func fileObject(f *adp.File) gin.H {
+	return gin.H{
+		"id":         f.ID,
+		"object":     "file",
+		"bytes":      f.Upload.Size,
+		"created_at": f.CreatedAt,
+		"filename":   f.Name,
+		"purpose":    f.Purpose,
+		"status":     "processed",
+	}
+}
+
+// fileTooLarge 文件超过大小上限
+func fileTooLarge() (int, gin.H) {
+	return http.StatusRequestEntityTooLarge, errorBody(fmt.Sprintf("file exceeds the %d MB size limit", maxUploadSize>>20),
+		"invalid_request_error", "file_too_large")
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUploadFileSizeLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old := maxUploadSize
+	maxUploadSize = 1024
+	t.Cleanup(func() { maxUploadSize = old })
+
+	h := &OpenAIHandler{}
+	r := gin.New()
+	r.POST("/v1/files", h.UploadFile)
+
+	tests := []struct {
+		name   string
+		size   int
+		status int
+		code   string
+	}{
+		// 超过文件上限但请求体仍在预留范围内，按文件大小拒绝
+		{"file over limit", 2048, http.StatusRequestEntityTooLarge, "file_too_large"},
+		// 请求体超过上限，解析multipart时即被截断
+		{"body over limit", int(uploadOverhead) + 4096, http.StatusRequestEntityTooLarge, "file_too_large"},
+		{"missing file", -1, http.StatusBadRequest, "invalid_file"},
+	}
+	for _, tt := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("purpose", "user_data")
+		if tt.size >= 0 {
+			fw, _ := mw.CreateFormFile("file", "doc.txt")
+			fw.Write(bytes.Repeat([]byte("a"), tt.size))
+		}
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/files", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+			t.Errorf("%s: status = %d, body = %s; want %d %s", tt.name, w.Code, w.Body, tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// maxStopSequences OpenAI允许的stop数量上限
+const maxStopSequences = 4
+
+// parseStop 解析stop字段，支持字符串和字符串数组
+func parseStop(v any) ([]string, error) {
+	switch stop := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if stop == "" {
+			return nil, nil
+		}
+		return []string{stop}, nil
+	case []interface{}:
+		if len(stop) > maxStopSequences {
+			return nil, fmt.Errorf("stop accepts at most %d sequences", maxStopSequences)
+		}
+		stops := make([]string, 0, len(stop))
+		for _, item := range stop {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("stop must be a string or an array of strings")
+			}
+			if s != "" {
+				stops = append(stops, s)
+			}
+		}
+		return stops, nil
+	}
+	return nil, errors.New("stop must be a string or an array of strings")
+}
+
+// validateMaxTokens 校验max_tokens类参数
+func validateMaxTokens(name string, v *int) error {
+	if v != nil && *v <= 0 {
+		return fmt.Errorf("%s must be a positive integer", name)
+	}
+	return nil
+}
+
+// runeTokens 估算单个字符占用的token数
+// ADP不返回分词结果，按中日韩字符约1个token、其他字符约4个字符1个token估算
+func runeTokens(r rune) float64 {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+		return 1
+	}
+	return 0.25
+}
+
+// outputLimiter 在网关侧执行stop和max_tokens
+// stop可能跨越多个数据块，因此可能是stop开头的尾部内容会暂缓输出。
+// 参考来源（annotations和脚注列表）在模型正常结束后由网关追加，不计入max_tokens，也不检查stop；
+// 触发限制而截断的回复不附带参考来源
+type outputLimiter struct {
+	stops     []string
+	maxTokens int
+	tokens    float64
+	pending   string
+	reason    string // 已触发的结束原因：stop 或 length
+}
+
+// newOutputLimiter 没有任何限制时返回nil
+func newOutputLimiter(stops []string, maxTokens int) *outputLimiter {
+	if len(stops) == 0 && maxTokens <= 0 {
+		return nil
+	}
+	return &outputLimiter{stops: stops, maxTokens: maxTokens}
+}
+
+// Feed 输入增量内容，返回可以输出的部分；返回true表示已触发停止，应取消上游生成
+func (l *outputLimiter) Feed(delta string) (string, bool) {
+	if l.reason != "" {
+		return "", true
+	}
+
+	text := l.pending + delta
+	l.pending = ""
+
+	cut := -1
+	for _, stop := range l.stops {
+		if i := strings.Index(text, stop); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+
+	if cut >= 0 {
+		text = text[:cut]
+		l.reason = "stop"
+	} else {
+		keep := 0
+		for _, stop := range l.stops {
+			if k := partialSuffix(text, stop); k > keep {
+				keep = k
+			}
+		}
+		l.pending = text[len(text)-keep:]
+		text = text[:len(text)-keep]
+	}
+
+	text = l.applyBudget(text)
+	return text, l.reason != ""
+}
+
+// Flush 上游正常结束时返回暂缓的内容
+func (l *outputLimiter) Flush() string {
+	if l.reason != "" {
+		return ""
+	}
+	text := l.applyBudget(l.pending)
+	l.pending = ""
+	return text
+}
+
+// FinishReason 返回OpenAI的finish_reason
+func (l *outputLimiter) FinishReason() string {
+	if l.reason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// applyBudget 按token预算截断
+func (l *outputLimiter) applyBudget(text string) string {
+	if l.maxTokens <= 0 {
+		return text
+	}
+	for i, r := range text {
+		cost := runeTokens(r)
+		if l.tokens+cost > float64(l.maxTokens) {
+			l.reason = "length"
+			l.pending = ""
+			return text[:i]
+		}
+		l.tokens += cost
+	}
+	return text
+}
+
+// chatLimited 以流式方式请求ADP并在网关侧执行限制，触发后取消上游生成
+// 返回截断后的结果和finish_reason，被截断时不保留参考来源
+func (h *OpenAIHandler) chatLimited(ctx context.Context, client *adp.Client, messages []adp.Message, opts adp.ChatOptions, limiter *outputLimiter) (*adp.ChatResult, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var sb strings.Builder
+	stopped := false
+	recordID := ""
+
+	opts.Stream = true
+	opts.Context = ctx
+	opts.OnChunk = func(chunk adp.Chunk) {
+		if stopped || chunk.Type != "content" {
+			return
+		}
+		recordID = chunk.RecordID
+		text, done := limiter.Feed(chunk.Content)
+		sb.WriteString(text)
+		if done {
+			stopped = true
+			cancel()
+		}
+	}
+	result, err := client.Chat(messages, opts)
+
+	if err != nil && !(stopped && errors.Is(err, context.Canceled)) {
+		return nil, "", err
+	}
+	if stopped || result == nil {
+		result = &adp.ChatResult{RecordID: recordID}
+	} else {
+		sb.WriteString(limiter.Flush())
+	}
+	result.Content = sb.String()
+	return result, limiter.FinishReason(), nil
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+func TestParseStop(t *testing.T) {
+	tests := []struct {
+		stop    string
+		want    []string
+		wantErr string
+	}{
+		{stop: `null`},
+		{stop: `""`},
+		{stop: `"END"`, want: []string{"END"}},
+		{stop: `["a","","b"]`, want: []string{"a", "b"}},
+		{stop: `["1","2","3","4","5"]`, wantErr: "at most 4"},
+		{stop: `[1]`, wantErr: "must be a string"},
+		{stop: `true`, wantErr: "must be a string"},
+	}
+	for _, tt := range tests {
+		var stop any
+		json.Unmarshal([]byte(tt.stop), &stop)
+		got, err := parseStop(stop)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseStop(%s) err = %v, want containing %q", tt.stop, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("parseStop(%s) = %q, %v, want %q", tt.stop, got, err, tt.want)
+		}
+	}
+}
+
+func TestOutputLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		stops      []string
+		maxTokens  int
+		chunks     []string
+		want       string // 各次Feed和最后Flush输出的内容
+		wantReason string // 为空表示没有触发限制
+	}{
+		{
+			name:       "stop within a chunk",
+			stops:      []string{"END"},
+			chunks:     []string{"Hello END world"},
+			want:       "Hello ",
+			wantReason: "stop",
+		},
+		{
+			name:       "stop split across chunks",
+			stops:      []string{"END"},
+			chunks:     []string{"Hello E", "N", "D world"},
+			want:       "Hello ",
+			wantReason: "stop",
+		},
+		{
+			name:   "partial stop flushed at the end",
+			stops:  []string{"END"},
+			chunks: []string{"Hello E", "N"},
+			want:   "Hello EN",
+		},
+		{
+			name:   "partial stop that does not continue",
+			stops:  []string{"END"},
+			chunks: []string{"Hello E", "xit"},
+			want:   "Hello Exit",
+		},
+		{
+			name:       "earliest stop wins",
+			stops:      []string{"world", "lo"},
+			chunks:     []string{"Hello world"},
+			want:       "Hel",
+			wantReason: "stop",
+		},
+		{
+			name:       "CJK budget",
+			maxTokens:  3,
+			chunks:     []string{"你好", "世界"},
+			want:       "你好世",
+			wantReason: "length",
+		},
+		{
+			name:      "non-CJK exactly on budget",
+			maxTokens: 1,
+			chunks:    []string{"ab", "cd"},
+			want:      "abcd",
+		},
+		{
+			name:       "non-CJK over budget",
+			maxTokens:  1,
+			chunks:     []string{"abc", "de"},
+			want:       "abcd",
+			wantReason: "length",
+		},
+		{
+			name:       "mixed budget rounding",
+			maxTokens:  2,
+			chunks:     []string{"你a", "bcd", "e"},
+			want:       "你abcd",
+			wantReason: "length",
+		},
+		{
+			name:       "budget applies to flushed text",
+			stops:      []string{"xyz"},
+			maxTokens:  1,
+			chunks:     []string{"abcx"},
+			want:       "abcx",
+			wantReason: "",
+		},
+		{
+			name:       "budget cuts pending text",
+			stops:      []string{"xyz"},
+			maxTokens:  1,
+			chunks:     []string{"abcdx"},
+			want:       "abcd",
+			wantReason: "length",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newOutputLimiter(tt.stops, tt.maxTokens)
+			var out strings.Builder
+			stopped := false
+			for _, chunk := range tt.chunks {
+				text, done := l.Feed(chunk)
+				if stopped && (text != "" || !done) {
+					t.Errorf("Feed(%q) after stop = %q, %v", chunk, text, done)
+				}
+				out.WriteString(text)
+				stopped = stopped || done
+			}
+			// 上游只在未触发限制时正常结束
+			if !stopped {
+				out.WriteString(l.Flush())
+			} else if rest := l.Flush(); rest != "" {
+				t.Errorf("Flush() after stop = %q", rest)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+			if got := l.reason; got != tt.wantReason {
+				t.Errorf("reason = %q, want %q", got, tt.wantReason)
+			}
+			wantFinish := "stop"
+			if tt.wantReason == "length" {
+				wantFinish = "length"
+			}
+			if got := l.FinishReason(); got != wantFinish {
+				t.Errorf("FinishReason() = %q, want %q", got, wantFinish)
+			}
+		})
+	}
+}
+
+func TestNewOutputLimiterWithoutLimits(t *testing.T) {
+	if l := newOutputLimiter(nil, 0); l != nil {
+		t.Errorf("newOutputLimiter(nil, 0) = %+v, want nil", l)
+	}
+}
+
+func TestCitationsNotCountedAgainstMaxTokens(t *testing.T) {
+	_, client := newFakeADP(t, func(requestID, content string) []string {
+		ref := adp.Reference{Name: "Guide", URL: "https://example.com/guide"}
+		return append([]string{referenceFrame(requestID, ref)}, replyFrames(requestID, "ab", "cd")...)
+	})
+	h := NewOpenAIHandler(client, Config{Models: Models{defaultModel: {}}, Citations: CitationFootnotes})
+	r := gin.New()
+	r.POST("/v1/chat/completions", h.ChatCompletions)
+
+	// 回复正好用完max_tokens，脚注列表仍完整追加，stop也不作用于脚注
+	for _, stream := range []string{"false", "true"} {
+		body := `{"messages":[{"role":"user","content":"hi"}],"max_tokens":1,"stop":["Guide"],"stream":` + stream + `}`
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("stream=%s: status = %d, body = %s", stream, w.Code, w.Body)
+		}
+		if !strings.Contains(w.Body.String(), `[Guide](https://example.com/guide)`) {
+			t.Errorf("stream=%s: footnotes missing: %s", stream, w.Body)
+		}
+		if !strings.Contains(w.Body.String(), `"finish_reason":"stop"`) {
+			t.Errorf("stream=%s: finish_reason is not stop: %s", stream, w.Body)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("adp_gateway_requests_total",
+		"Completion requests by endpoint, model and HTTP status.", "endpoint", "model", "status")
+	requestDuration = metrics.NewHistogram("adp_gateway_request_duration_seconds",
+		"Completion request latency in seconds.", metrics.DurationBuckets, "endpoint", "model")
+	timeToFirstToken = metrics.NewHistogram("adp_gateway_time_to_first_token_seconds",
+		"Time from request start to the first streamed content chunk in seconds.", metrics.DurationBuckets, "endpoint", "model")
+	streamDuration = metrics.NewHistogram("adp_gateway_stream_duration_seconds",
+		"Duration of streamed responses in seconds.", metrics.DurationBuckets, "endpoint", "model")
+	requestsInFlight = metrics.NewGauge("adp_gateway_requests_in_flight",
+		"Completion requests currently being processed.", "endpoint")
+)
+
+// unknownModel 请求体解析失败、尚未确定模型时使用的标签
+const unknownModel = "unknown"
+
+// requestMetrics 记录一次补全请求的指标
+type requestMetrics struct {
+	endpoint string
+	model    string
+	stream   bool
+	start    time.Time
+	first    sync.Once
+}
+
+// trackRequest 开始记录请求，处理结束时需调用done
+func trackRequest(endpoint string) *requestMetrics {
+	requestsInFlight.Inc(endpoint)
+	return &requestMetrics{endpoint: endpoint, model: unknownModel, start: time.Now()}
+}
+
+// setModel 设置模型标签，未配置的模型统一记为other，避免标签数量不受控制
+func (m *requestMetrics) setModel(models Models, model string, stream bool) {
+	m.model = models.metricLabel(model)
+	m.stream = stream
+}
+
+// firstToken 记录首个内容块的到达时间，只有第一次调用有效
+func (m *requestMetrics) firstToken() {
+	if m == nil {
+		return
+	}
+	m.first.Do(func() {
+		timeToFirstToken.Observe(time.Since(m.start).Seconds(), m.endpoint, m.model)
+	})
+}
+
+// done 按响应状态码记录请求结果和耗时，流式耗时只统计成功开始输出的请求
+func (m *requestMetrics) done(c *gin.Context) {
+	elapsed := time.Since(m.start).Seconds()
+	requestsInFlight.Dec(m.endpoint)
+	status := c.Writer.Status()
+	requestsTotal.Inc(m.endpoint, m.model, strconv.Itoa(status))
+	requestDuration.Observe(elapsed, m.endpoint, m.model)
+	if m.stream && status == http.StatusOK {
+		streamDuration.Observe(elapsed, m.endpoint, m.model)
+	}
+}
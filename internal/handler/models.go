@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/auth"
+)
+
+// maxStreamingThrottle ADP允许的streaming_throttle上限
+const maxStreamingThrottle = 100
+
+// ADPOptions ADP特有的请求选项，可在模型配置中设置默认值，
+// 也可以在请求体的 adp_options 中逐项覆盖
+type ADPOptions struct {
+	SearchNetwork     *bool  `json:"search_network,omitempty"`
+	ModelName         string `json:"model_name,omitempty"`
+	StreamingThrottle *int   `json:"streaming_throttle,omitempty"`
+
+	// RecommendedQuestions 等待并返回ADP的推荐问题
+	RecommendedQuestions *bool `json:"recommended_questions,omitempty"`
+}
+
+// validate 校验选项取值
+func (o *ADPOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.StreamingThrottle != nil && (*o.StreamingThrottle < 1 || *o.StreamingThrottle > maxStreamingThrottle) {
+		return fmt.Errorf("adp_options.streaming_throttle must be between 1 and %d", maxStreamingThrottle)
+	}
+	return nil
+}
+
+// merge 用override中设置的字段覆盖默认值
+func (o ADPOptions) merge(override *ADPOptions) ADPOptions {
+	if override == nil {
+		return o
+	}
+	if override.SearchNetwork != nil {
+		o.SearchNetwork = override.SearchNetwork
+	}
+	if override.ModelName != "" {
+		o.ModelName = override.ModelName
+	}
+	if override.StreamingThrottle != nil {
+		o.StreamingThrottle = override.StreamingThrottle
+	}
+	if override.RecommendedQuestions != nil {
+		o.RecommendedQuestions = override.RecommendedQuestions
+	}
+	return o
+}
+
+// apply 把选项写入聊天选项
+func (o ADPOptions) apply(opts adp.ChatOptions) adp.ChatOptions {
+	if o.SearchNetwork != nil {
+		opts.SearchNetwork = "disable"
+		if *o.SearchNetwork {
+			opts.SearchNetwork = "enable"
+		}
+	}
+	opts.ModelName = o.ModelName
+	if o.StreamingThrottle != nil {
+		opts.StreamingThrottle = *o.StreamingThrottle
+	}
+	opts.WaitRecommended = o.RecommendedQuestions != nil && *o.RecommendedQuestions
+	return opts
+}
+
+// ModelConfig 模型的默认选项
+type ModelConfig struct {
+	ADPOptions
+
+	// AllowedModelNames 请求的 adp_options.model_name 可以使用的取值，
+	// 未配置时请求不能覆盖模型，避免绕过按Key限制的模型列表
+	AllowedModelNames []string `json:"allowed_model_names,omitempty"`
+}
+
+// Models 对外暴露的模型ID及其默认选项
+type Models map[string]ModelConfig
+
+// LoadModels 从JSON文件加载模型配置，格式为 {"<模型ID>": {<adp_options>, "allowed_model_names": [...]}}
+// path为空时只提供默认模型
+func LoadModels(path string) (Models, error) {
+	models := Models{defaultModel: {}}
+	if path == "" {
+		return models, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取模型配置失败: %w", err)
+	}
+	var loaded map[string]ModelConfig
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("解析模型配置失败: %w", err)
+	}
+	for id, opts := range loaded {
+		if id == "" {
+			return nil, errors.New("模型ID不能为空")
+		}
+		if err := opts.validate(); err != nil {
+			return nil, fmt.Errorf("模型 %s 配置错误: %w", id, err)
+		}
+		models[id] = opts
+	}
+	return models, nil
+}
+
+// IDs 返回排序后的模型ID
+func (m Models) IDs() []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// exists 模型是否已配置
+func (m Models) exists(model string) bool {
+	_, ok := m[model]
+	return ok
+}
+
+// options 返回模型的默认选项，未配置的模型没有默认值
+func (m Models) options(model string) ADPOptions {
+	return m[model].ADPOptions
+}
+
+// resolve 校验请求的adp_options并与模型默认选项合并
+func (m Models) resolve(model string, override *ADPOptions) (ADPOptions, error) {
+	if err := override.validate(); err != nil {
+		return ADPOptions{}, err
+	}
+	cfg := m[model]
+	if override != nil && override.ModelName != "" && override.ModelName != cfg.ModelName {
+		allowed := false
+		for _, name := range cfg.AllowedModelNames {
+			if name == override.ModelName {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ADPOptions{}, fmt.Errorf("adp_options.model_name %q is not allowed for model %q", override.ModelName, model)
+		}
+	}
+	return cfg.ADPOptions.merge(override), nil
+}
+
+// modelNotFound 模型未配置，与Key无权使用模型时的错误一致
+func modelNotFound(model string) (int, gin.H) {
+	return http.StatusNotFound, errorBody(
+		fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", model),
+		"invalid_request_error", "model_not_found")
+}
+
+// metricLabel 返回指标中使用的模型名，未配置的模型记为other
+func (m Models) metricLabel(model string) string {
+	if _, ok := m[model]; ok || model == defaultModel {
+		return model
+	}
+	return "other"
+}
+
+// PeekRequest 解析OpenAI请求体中的模型和是否流式，供限流中间件使用
+// 每个choice和每个prompt都是一次ADP对话，分别计入请求数额度
+func PeekRequest(body []byte) auth.RequestInfo {
+	var req struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+		N      int    `json:"n"`
+		Prompt any    `json:"prompt"`
+	}
+	json.Unmarshal(body, &req)
+	if req.Model == "" {
+		req.Model = defaultModel
+	}
+	units := req.N
+	if prompts, ok := req.Prompt.([]interface{}); ok {
+		units = len(prompts)
+	}
+	return auth.RequestInfo{Model: req.Model, Stream: req.Stream, Units: units}
+}
+
+// PeekOllamaRequest 解析Ollama请求体，stream默认为true
+func PeekOllamaRequest(body []byte) auth.RequestInfo {
+	var req struct {
+		Model  string `json:"model"`
+		Stream *bool  `json:"stream"`
+	}
+	json.Unmarshal(body, &req)
+	return auth.RequestInfo{Model: ollamaModel(req.Model), Stream: req.Stream == nil || *req.Stream}
+}
+
+// visibleModelIDs 返回当前Key可以使用的模型
+func (m Models) visibleModelIDs(c *gin.Context) []string {
+	ids := m.IDs()
+	key, ok := auth.FromContext(c)
+	if !ok {
+		return ids
+	}
+	visible := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if key.AllowsModel(id) {
+			visible = append(visible, id)
+		}
+	}
+	return visible
+}
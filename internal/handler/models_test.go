@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/auth"
+)
+
+func TestModelsResolve(t *testing.T) {
+	models := Models{
+		defaultModel: {},
+		"adp-hunyuan": {
+			ADPOptions:        ADPOptions{ModelName: "hunyuan-turbo"},
+			AllowedModelNames: []string{"hunyuan-large"},
+		},
+	}
+	throttle := 200
+
+	tests := []struct {
+		name      string
+		model     string
+		override  *ADPOptions
+		wantModel string
+		wantErr   string
+	}{
+		{name: "default options", model: "adp-hunyuan", wantModel: "hunyuan-turbo"},
+		{name: "allowed override", model: "adp-hunyuan", override: &ADPOptions{ModelName: "hunyuan-large"}, wantModel: "hunyuan-large"},
+		{name: "configured model name", model: "adp-hunyuan", override: &ADPOptions{ModelName: "hunyuan-turbo"}, wantModel: "hunyuan-turbo"},
+		{name: "override not in list", model: "adp-hunyuan", override: &ADPOptions{ModelName: "deepseek-r1"}, wantErr: `adp_options.model_name "deepseek-r1" is not allowed for model "adp-hunyuan"`},
+		{name: "no overrides configured", model: defaultModel, override: &ADPOptions{ModelName: "hunyuan-large"}, wantErr: "is not allowed"},
+		{name: "invalid option", model: defaultModel, override: &ADPOptions{StreamingThrottle: &throttle}, wantErr: "streaming_throttle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := models.resolve(tt.model, tt.override)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if opts.ModelName != tt.wantModel {
+				t.Errorf("ModelName = %q, want %q", opts.ModelName, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestPeekRequest(t *testing.T) {
+	tests := []struct {
+		body string
+		want auth.RequestInfo
+	}{
+		{`{"stream":true}`, auth.RequestInfo{Model: defaultModel, Stream: true}},
+		{`{"model":"m","n":3}`, auth.RequestInfo{Model: "m", Units: 3}},
+		{`{"model":"m","prompt":["a","b"]}`, auth.RequestInfo{Model: "m", Units: 2}},
+		{`{"model":"m","prompt":"a"}`, auth.RequestInfo{Model: "m"}},
+		{`not json`, auth.RequestInfo{Model: defaultModel}},
+	}
+	for _, tt := range tests {
+		if got := PeekRequest([]byte(tt.body)); got != tt.want {
+			t.Errorf("PeekRequest(%s) = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
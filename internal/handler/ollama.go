@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// OllamaHandler Ollama协议处理器
+type OllamaHandler struct {
+	client *adp.Client
+	models Models
+}
+
+// NewOllamaHandler 创建处理器
+func NewOllamaHandler(client *adp.Client, models Models) *OllamaHandler {
+	return &OllamaHandler{client: client, models: models}
+}
+
+// OllamaMessage Ollama格式消息
+type OllamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// OllamaChatRequest /api/chat 请求
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   *bool           `json:"stream"`
+}
+
+// OllamaGenerateRequest /api/generate 请求
+type OllamaGenerateRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	System string `json:"system"`
+	Stream *bool  `json:"stream"`
+}
+
+// Tags 获取模型列表
+func (h *OllamaHandler) Tags(c *gin.Context) {
+	models := make([]gin.H, 0, 1)
+	for _, id := range h.models.visibleModelIDs(c) {
+		models = append(models, gin.H{
+			"name":        id,
+			"model":       id,
+			"modified_at": time.Now().UTC().Format(time.RFC3339),
+			"size":        0,
+			"digest":      "",
+			"details": gin.H{
+				"format":             "",
+				"family":             "adp",
+				"families":           []string{"adp"},
+				"parameter_size":     "",
+				"quantization_level": "",
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+// Chat 处理 /api/chat 请求
+func (h *OllamaHandler) Chat(c *gin.Context) {
+	m := trackRequest("ollama_chat")
+	defer m.done(c)
+
+	var req OllamaChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if len(req.Messages) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "messages is required"})
+		return
+	}
+
+	messages := []adp.Message{ollamaPrompt(req.Messages)}
+
+	h.handle(c, m, messages, ollamaModel(req.Model), req.Stream == nil || *req.Stream, func(content string, done bool) gin.H {
+		return gin.H{
+			"message": gin.H{
+				"role":    "assistant",
+				"content": content,
+			},
+		}
+	})
+}
+
+// Generate 处理 /api/generate 请求
+func (h *OllamaHandler) Generate(c *gin.Context) {
+	m := trackRequest("ollama_generate")
+	defer m.done(c)
+
+	var req OllamaGenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if req.Prompt == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prompt is required"})
+		return
+	}
+
+	// ADP的角色设定在应用侧配置，system只能拼接到提示词前
+	prompt := req.Prompt
+	if req.System != "" {
+		prompt = req.System + "\n\n" + prompt
+	}
+	messages := []adp.Message{{Role: "user", Content: prompt}}
+
+	h.handle(c, m, messages, ollamaModel(req.Model), req.Stream == nil || *req.Stream, func(content string, done bool) gin.H {
+		resp := gin.H{"response": content}
+		if done {
+			resp["context"] = []int{}
+		}
+		return resp
+	})
+}
+
+// handle 调用ADP并按Ollama格式输出，build负责生成各接口特有的字段
+func (h *OllamaHandler) handle(c *gin.Context, m *requestMetrics, messages []adp.Message, model string, stream bool, build func(content string, done bool) gin.H) {
+	m.setModel(h.models, model, stream)
+	if !h.models.exists(model) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", model)})
+		return
+	}
+
+	start := time.Now()
+	options := h.models.options(model)
+	client := clientFor(c, h.client)
+
+	frame := func(content string, done bool) gin.H {
+		resp := build(content, done)
+		resp["model"] = model
+		resp["created_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+		resp["done"] = done
+		if done {
+			resp["done_reason"] = "stop"
+			resp["total_duration"] = time.Since(start).Nanoseconds()
+			resp["load_duration"] = 0
+			resp["prompt_eval_count"] = 0
+			resp["prompt_eval_duration"] = 0
+			resp["eval_count"] = 0
+			resp["eval_duration"] = time.Since(start).Nanoseconds()
+		}
+		return resp
+	}
+
+	if !stream {
+		result, err := client.Chat(messages, options.apply(adp.ChatOptions{
+			Stream:  false,
+			Context: c.Request.Context(),
+		}))
+		if err != nil {
+			logger.WarnContext(c.Request.Context(), "Ollama请求失败", "error", err)
+			status, _ := chatErrorResponse(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, frame(result.Content, true))
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "streaming not supported"})
+		return
+	}
+
+	// ADP读取协程和错误处理都会输出，处理函数返回后gin会回收c，closed之后的输出全部丢弃
+	var writeMu sync.Mutex
+	closed := false
+	writeLine := func(v any) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if closed {
+			return
+		}
+		data, _ := json.Marshal(v)
+		c.Writer.Write(append(data, '\n'))
+		flusher.Flush()
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	var wg sync.WaitGroup
+	defer func() {
+		writeMu.Lock()
+		closed = true
+		writeMu.Unlock()
+		cancel()
+		wg.Wait()
+	}()
+
+	done := make(chan struct{})
+	errCh := make(chan error, 1)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := client.Chat(messages, options.apply(adp.ChatOptions{
+			Stream:  true,
+			Context: ctx,
+			OnChunk: func(chunk adp.Chunk) {
+				switch chunk.Type {
+				case "content":
+					m.firstToken()
+					writeLine(frame(chunk.Content, false))
+				case "done":
+					writeLine(frame("", true))
+					close(done)
+				}
+			},
+		}))
+
+		if err != nil {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-done:
+		return
+	case err := <-errCh:
+		logger.WarnContext(c.Request.Context(), "Ollama流式请求失败", "error", err)
+		writeMu.Lock()
+		written := c.Writer.Written()
+		if !written {
+			status, _ := chatErrorResponse(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			closed = true
+		}
+		writeMu.Unlock()
+		if written {
+			// Ollama在流中以error字段返回错误
+			writeLine(gin.H{"error": err.Error()})
+		}
+		return
+	case <-c.Request.Context().Done():
+		return
+	}
+}
+
+// ollamaPrompt 把Ollama消息合并为发送给ADP的一条消息
+// Ollama客户端每次都发送完整的对话，而ADP只接收最后一条消息、每个请求又是新的会话，
+// 因此system和之前的对话需要展开为文本放在最后一条消息前；之前消息中的图片不再发送
+func ollamaPrompt(messages []OllamaMessage) adp.Message {
+	last := messages[len(messages)-1]
+
+	var system []string
+	var history strings.Builder
+	for _, m := range messages[:len(messages)-1] {
+		switch m.Role {
+		case "system":
+			system = append(system, m.Content)
+		case "assistant":
+			history.WriteString("Assistant: " + m.Content + "\n")
+		default:
+			history.WriteString("User: " + m.Content + "\n")
+		}
+	}
+
+	// ADP的角色设定在应用侧配置，system和Generate一样拼接到提示词前
+	var sb strings.Builder
+	if len(system) > 0 {
+		sb.WriteString(strings.Join(system, "\n\n") + "\n\n")
+	}
+	if history.Len() > 0 {
+		sb.WriteString("Conversation so far:\n" + history.String() + "\n")
+	}
+	content := last.Content
+	if sb.Len() > 0 {
+		content = sb.String() + last.Content
+	}
+
+	if len(last.Images) == 0 {
+		return adp.Message{Role: "user", Content: content}
+	}
+	parts := []adp.ContentPart{{Type: "text", Text: content}}
+	for _, img := range last.Images {
+		parts = append(parts, adp.ContentPart{
+			Type:     "image_url",
+			ImageURL: &adp.ImageURL{URL: imageDataURL(img)},
+		})
+	}
+	return adp.Message{Role: "user", Content: parts}
+}
+
+// imageDataURL 把不带前缀的base64图片转换为data URL，类型按内容开头嗅探
+// 上传前还会按完整内容校验类型，无法识别时使用application/octet-stream
+func imageDataURL(b64 string) string {
+	// DetectContentType最多检查512字节
+	head := b64[:min(len(b64), base64.StdEncoding.EncodedLen(512))]
+	head = head[:len(head)/4*4]
+	contentType := "application/octet-stream"
+	if data, err := base64.StdEncoding.DecodeString(head); err == nil {
+		if sniffed := http.DetectContentType(data); strings.HasPrefix(sniffed, "image/") {
+			contentType = sniffed
+		}
+	}
+	return "data:" + contentType + ";base64," + b64
+}
+
+// ollamaModel Ollama客户端通常带":latest"等标签，统一去掉
+func ollamaModel(model string) string {
+	if model == "" {
+		return defaultModel
+	}
+	if i := strings.LastIndex(model, ":"); i > 0 {
+		return model[:i]
+	}
+	return model
+}
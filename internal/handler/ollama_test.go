@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/metrics"
+)
+
+func TestOllamaRequestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewOllamaHandler(nil, Models{})
+	r := gin.New()
+	r.POST("/api/chat", h.Chat)
+	r.POST("/api/generate", h.Generate)
+
+	// count 从/metrics输出中读取requests_total的值
+	count := func(key ...string) float64 {
+		var buf bytes.Buffer
+		metrics.Default.WriteTo(&buf)
+		prefix := fmt.Sprintf(`adp_gateway_requests_total{endpoint=%q,model=%q,status=%q} `, key[0], key[1], key[2])
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if v, ok := strings.CutPrefix(line, prefix); ok {
+				n, _ := strconv.ParseFloat(v, 64)
+				return n
+			}
+		}
+		return 0
+	}
+
+	tests := []struct {
+		path, body string
+		key        []string
+	}{
+		{"/api/chat", `{"model":"missing:latest","messages":[{"role":"user","content":"hi"}]}`, []string{"ollama_chat", "other", "404"}},
+		{"/api/chat", `not json`, []string{"ollama_chat", unknownModel, "400"}},
+		{"/api/generate", `{"model":"missing","prompt":"hi"}`, []string{"ollama_generate", "other", "404"}},
+	}
+	for _, tt := range tests {
+		before := count(tt.key...)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		if got := count(tt.key...); got != before+1 {
+			t.Errorf("%s %s: requests_total%v = %v, want %v", tt.path, tt.body, tt.key, got, before+1)
+		}
+	}
+}
+
+func TestOllamaModel(t *testing.T) {
+	for in, want := range map[string]string{"": defaultModel, "adp-default:latest": "adp-default", "adp": "adp", ":x": ":x"} {
+		if got := ollamaModel(in); got != want {
+			t.Errorf("ollamaModel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOllamaPrompt(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []OllamaMessage
+		want     string
+	}{
+		{
+			name:     "single message",
+			messages: []OllamaMessage{{Role: "user", Content: "hi"}},
+			want:     "hi",
+		},
+		{
+			name:     "system only",
+			messages: []OllamaMessage{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "hi"}},
+			want:     "Be brief.\n\nhi",
+		},
+		{
+			name: "history",
+			messages: []OllamaMessage{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "My name is Li."},
+				{Role: "assistant", Content: "Hello Li."},
+				{Role: "system", Content: "Answer in English."},
+				{Role: "user", Content: "What is my name?"},
+			},
+			want: "Be brief.\n\nAnswer in English.\n\nConversation so far:\nUser: My name is Li.\nAssistant: Hello Li.\n\nWhat is my name?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ollamaPrompt(tt.messages)
+			if got.Role != "user" || got.Content != tt.want {
+				t.Errorf("ollamaPrompt() = %+v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaPromptImages(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	got := ollamaPrompt([]OllamaMessage{
+		{Role: "user", Content: "earlier", Images: []string{"b2xk"}},
+		{Role: "user", Content: "what is this?", Images: []string{png, "bm90IGFuIGltYWdl", "!!"}},
+	})
+	parts, ok := got.Content.([]adp.ContentPart)
+	if !ok || len(parts) != 4 {
+		t.Fatalf("content = %+v, want text and the three images of the last message", got.Content)
+	}
+	if !strings.HasSuffix(parts[0].Text, "what is this?") {
+		t.Errorf("text = %q", parts[0].Text)
+	}
+	wants := []string{
+		"data:image/png;base64," + png,
+		"data:application/octet-stream;base64,bm90IGFuIGltYWdl",
+		"data:application/octet-stream;base64,!!",
+	}
+	for i, want := range wants {
+		if got := parts[i+1].ImageURL.URL; got != want {
+			t.Errorf("image %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	f, client := newFakeADP(t, func(requestID, content string) []string {
+		if strings.Contains(content, "fail") {
+			// 先输出一段再断开
+			return append(replyFrames(requestID, "partial", " output")[:1], "")
+		}
+		return replyFrames(requestID, "Hello", " Li.")
+	})
+	h := NewOllamaHandler(client, Models{defaultModel: {}})
+	r := gin.New()
+	r.POST("/api/chat", h.Chat)
+	r.POST("/api/generate", h.Generate)
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w
+	}
+	// lines 解析NDJSON响应
+	lines := func(body string) []map[string]any {
+		var out []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			var v map[string]any
+			if err := json.Unmarshal([]byte(line), &v); err != nil {
+				t.Fatalf("invalid NDJSON line %q: %v", line, err)
+			}
+			out = append(out, v)
+		}
+		return out
+	}
+
+	t.Run("non-stream", func(t *testing.T) {
+		w := serve("/api/chat", `{"model":"adp-default:latest","stream":false,"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"My name is Li."},{"role":"assistant","content":"Hi."},{"role":"user","content":"Who am I?"}]}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+		}
+		resp := lines(w.Body.String())[0]
+		if resp["message"].(map[string]any)["content"] != "Hello Li." || resp["done"] != true || resp["model"] != defaultModel {
+			t.Errorf("response = %v", resp)
+		}
+		sent := f.sent()
+		if last := sent[len(sent)-1]; !strings.HasPrefix(last, "Be brief.") || !strings.Contains(last, "User: My name is Li.") || !strings.HasSuffix(last, "Who am I?") {
+			t.Errorf("sent %q, want system and history folded into the prompt", last)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		w := serve("/api/chat", `{"messages":[{"role":"user","content":"hi"}]}`)
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var content strings.Builder
+		frames := lines(w.Body.String())
+		for _, frame := range frames[:len(frames)-1] {
+			if frame["done"] != false {
+				t.Errorf("frame before the end has done = %v", frame["done"])
+			}
+			content.WriteString(frame["message"].(map[string]any)["content"].(string))
+		}
+		if last := frames[len(frames)-1]; last["done"] != true || last["done_reason"] != "stop" {
+			t.Errorf("last frame = %v", last)
+		}
+		if content.String() != "Hello Li." {
+			t.Errorf("content = %q", content.String())
+		}
+	})
+
+	t.Run("generate with system", func(t *testing.T) {
+		w := serve("/api/generate", `{"prompt":"hi","system":"Be brief.","stream":false}`)
+		resp := lines(w.Body.String())[0]
+		if resp["response"] != "Hello Li." || resp["context"] == nil {
+			t.Errorf("response = %v", resp)
+		}
+		sent := f.sent()
+		if last := sent[len(sent)-1]; last != "Be brief.\n\nhi" {
+			t.Errorf("sent %q", last)
+		}
+	})
+
+	t.Run("stream error after output", func(t *testing.T) {
+		w := serve("/api/chat", `{"messages":[{"role":"user","content":"fail"}]}`)
+		frames := lines(w.Body.String())
+		if w.Code != http.StatusOK || frames[0]["message"] == nil || frames[len(frames)-1]["error"] == nil {
+			t.Errorf("status = %d, body = %s, want partial output followed by an error line", w.Code, w.Body)
+		}
+	})
+
+	t.Run("missing messages", func(t *testing.T) {
+		if w := serve("/api/chat", `{"messages":[]}`); w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", w.Code)
+		}
+	})
+}
@@ -1,121 +1,304 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/jsonschema"
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+	"github.com/brinkmai/adp-openai-gateway/internal/session"
+	"github.com/brinkmai/adp-openai-gateway/internal/tenant"
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
 )
 
+var logger = logging.Component("handler")
+
+// defaultModel 默认模型ID
+const defaultModel = "adp-default"
+
+// Config 处理器配置
+type Config struct {
+	// JSONRetries 结构化输出校验失败后要求模型修正的最大次数
+	JSONRetries int
+	// MaxChoices 单个请求允许的n上限
+	MaxChoices int
+	// Citations 参考来源的输出方式
+	Citations CitationMode
+	// AllowedVariables 允许客户端设置的custom_variables
+	AllowedVariables Allowlist
+	// AllowedLabels 允许客户端设置的visitor_labels
+	AllowedLabels Allowlist
+	// Models 模型列表及各模型的默认ADP选项
+	Models Models
+	// Sessions 客户端创建的命名会话
+	Sessions *session.Store
+}
+
 // OpenAIHandler OpenAI协议处理器
 type OpenAIHandler struct {
-	client *adp.Client
+	client  *adp.Client
+	cfg     Config
+	records *recordStore
 }
 
 // NewOpenAIHandler 创建处理器
-func NewOpenAIHandler(client *adp.Client) *OpenAIHandler {
-	return &OpenAIHandler{client: client}
+func NewOpenAIHandler(client *adp.Client, cfg Config) *OpenAIHandler {
+	return &OpenAIHandler{client: client, cfg: cfg, records: newRecordStore()}
 }
 
 // ChatRequest 聊天请求
 type ChatRequest struct {
-	Model    string        `json:"model"`
-	Messages []adp.Message `json:"messages"`
-	Stream   bool          `json:"stream"`
+	Model      string        `json:"model"`
+	Messages   []adp.Message `json:"messages"`
+	Stream     bool          `json:"stream"`
+	Tools      []Tool        `json:"tools"`
+	ToolChoice any           `json:"tool_choice"`
+
+	ResponseFormat *ResponseFormat `json:"response_format"`
+
+	Stop                any  `json:"stop"` // string 或 []string
+	MaxTokens           *int `json:"max_tokens"`
+	MaxCompletionTokens *int `json:"max_completion_tokens"`
+
+	// N 生成的choice数量，每个choice是独立的ADP会话
+	N *int `json:"n"`
+
+	// User 作为ADP的visitor_biz_id，Metadata映射为custom_variables
+	User     string            `json:"user"`
+	Metadata map[string]string `json:"metadata"`
+
+	// ADPOptions 覆盖模型配置中的ADP选项
+	ADPOptions *ADPOptions `json:"adp_options"`
+
+	// SessionID 通过 /v1/sessions 创建的会话，不设置时每次请求都是新的ADP会话
+	SessionID string `json:"session_id"`
+
+	toolChoice toolChoice
+	schema     *jsonschema.Schema
+	stops      []string
+	visitor    visitor
+	options    ADPOptions
+	adpSession string
+	owner      string
+	client     *adp.Client
+	ctx        context.Context
+	metrics    *requestMetrics
+}
+
+// chatOptions 补充访客信息和ADP选项，未指定Context时使用请求的ctx
+func (r *ChatRequest) chatOptions(opts adp.ChatOptions) adp.ChatOptions {
+	if opts.SessionID == "" {
+		opts.SessionID = r.adpSession
+	}
+	if opts.Context == nil {
+		opts.Context = r.ctx
+	}
+	opts.FileOwner = r.owner
+	return r.options.apply(r.visitor.apply(opts))
+}
+
+// choices 返回需要生成的choice数量
+func (r *ChatRequest) choices() int {
+	if r.N == nil {
+		return 1
+	}
+	return *r.N
+}
+
+// useTools 是否启用工具调用模拟
+func (r *ChatRequest) useTools() bool {
+	return len(r.Tools) > 0 && r.toolChoice.Mode != "none"
+}
+
+// limiter 返回网关侧的stop/max_tokens限制，没有限制时为nil
+func (r *ChatRequest) limiter() *outputLimiter {
+	maxTokens := 0
+	if r.MaxCompletionTokens != nil {
+		maxTokens = *r.MaxCompletionTokens
+	} else if r.MaxTokens != nil {
+		maxTokens = *r.MaxTokens
+	}
+	return newOutputLimiter(r.stops, maxTokens)
+}
+
+// adpMessages 返回发送给ADP的消息
+func (r *ChatRequest) adpMessages() []adp.Message {
+	if r.useTools() {
+		return toolPromptMessages(r.Messages, r.Tools, r.toolChoice)
+	}
+	return r.Messages
 }
 
 // GetModels 获取模型列表
 func (h *OpenAIHandler) GetModels(c *gin.Context) {
+	data := make([]gin.H, 0, 1)
+	for _, id := range h.cfg.Models.visibleModelIDs(c) {
+		data = append(data, gin.H{
+			"id":       id,
+			"object":   "model",
+			"created":  time.Now().Unix(),
+			"owned_by": "tencent-adp",
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"object": "list",
-		"data": []gin.H{
-			{
-				"id":       "adp-default",
-				"object":   "model",
-				"created":  time.Now().Unix(),
-				"owned_by": "tencent-adp",
-			},
-		},
+		"data":   data,
 	})
 }
 
 // ChatCompletions 处理聊天完成请求
 func (h *OpenAIHandler) ChatCompletions(c *gin.Context) {
+	m := trackRequest("chat_completions")
+	defer m.done(c)
+
 	var req ChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"message": "Invalid request body",
-				"type":    "invalid_request_error",
-				"code":    "invalid_request",
-			},
-		})
+		c.JSON(http.StatusBadRequest, errorBody("Invalid request body", "invalid_request_error", "invalid_request"))
+		return
+	}
+	model := req.Model
+	if model == "" {
+		model = defaultModel
+	}
+	m.setModel(h.cfg.Models, model, req.Stream)
+	req.metrics = m
+	if !h.cfg.Models.exists(model) {
+		c.JSON(modelNotFound(model))
 		return
 	}
 
 	if len(req.Messages) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"message": "messages is required and must be a non-empty array",
-				"type":    "invalid_request_error",
-				"code":    "invalid_messages",
-			},
-		})
+		c.JSON(http.StatusBadRequest, errorBody("messages is required and must be a non-empty array", "invalid_request_error", "invalid_messages"))
 		return
 	}
 
+	if err := validateTools(req.Tools); err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_tools"))
+		return
+	}
+	choice, err := parseToolChoice(req.ToolChoice, req.Tools)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_tool_choice"))
+		return
+	}
+	req.toolChoice = choice
+
+	schema, err := req.ResponseFormat.compile()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_response_format"))
+		return
+	}
+	req.schema = schema
+
+	stops, err := parseStop(req.Stop)
+	if err == nil {
+		err = validateMaxTokens("max_tokens", req.MaxTokens)
+	}
+	if err == nil {
+		err = validateMaxTokens("max_completion_tokens", req.MaxCompletionTokens)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_parameter"))
+		return
+	}
+	req.stops = stops
+
+	if req.N != nil && (*req.N < 1 || *req.N > h.cfg.MaxChoices) {
+		c.JSON(http.StatusBadRequest, errorBody(fmt.Sprintf("n must be between 1 and %d", h.cfg.MaxChoices), "invalid_request_error", "invalid_n"))
+		return
+	}
+	options, err := h.cfg.Models.resolve(model, req.ADPOptions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_adp_options"))
+		return
+	}
+	req.options = options
+	req.visitor = h.parseVisitor(c.Request.Context(), c.Request.Header, req.User, req.Metadata)
+	req.client = clientFor(c, h.client)
+	req.ctx = c.Request.Context()
+	req.owner = requestOwner(c)
+
+	if req.SessionID != "" {
+		// 同一ADP会话中的并发请求会互相干扰上下文
+		if req.choices() > 1 {
+			c.JSON(http.StatusBadRequest, errorBody("n > 1 cannot be used with session_id", "invalid_request_error", "invalid_n"))
+			return
+		}
+		sess, ok := h.cfg.Sessions.Get(requestOwner(c), req.SessionID)
+		if !ok {
+			c.JSON(sessionNotFound())
+			return
+		}
+		req.adpSession = sess.ADPSessionID
+	}
+
 	requestID := fmt.Sprintf("chatcmpl-%s", uuid.New().String())
 	created := time.Now().Unix()
-	model := req.Model
-	if model == "" {
-		model = "adp-default"
-	}
 
-	if req.Stream {
-		h.handleStreamRequest(c, req.Messages, requestID, created, model)
-	} else {
-		h.handleNonStreamRequest(c, req.Messages, requestID, created, model)
+	switch {
+	case req.Stream && req.ResponseFormat.jsonMode():
+		// JSON需要完整校验后才能输出，流式请求退化为一次性输出
+		h.handleStructuredStream(c, &req, requestID, created, model)
+	case req.Stream:
+		h.handleStreamRequest(c, &req, requestID, created, model)
+	default:
+		h.handleNonStreamRequest(c, &req, requestID, created, model)
 	}
 }
 
-func (h *OpenAIHandler) handleNonStreamRequest(c *gin.Context, messages []adp.Message, requestID string, created int64, model string) {
-	result, err := h.client.Chat(messages, adp.ChatOptions{
-		Stream: false,
+func (h *OpenAIHandler) handleNonStreamRequest(c *gin.Context, req *ChatRequest, requestID string, created int64, model string) {
+	// n>1时每个choice是独立的ADP会话，并发请求
+	choices := make([]gin.H, req.choices())
+	err := fanOut(c.Request.Context(), len(choices), func(ctx context.Context, i int) error {
+		message, finishReason, recordID, err := h.completeChoice(ctx, req)
+		if err != nil {
+			return err
+		}
+		choices[i] = gin.H{
+			"index":         i,
+			"message":       message,
+			"finish_reason": finishReason,
+		}
+		if recordID != "" {
+			choices[i]["record_id"] = recordID
+		}
+		return nil
 	})
 
 	if err != nil {
-		log.Printf("[OpenAIHandler] 请求失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": err.Error(),
-				"type":    "api_error",
-				"code":    "internal_error",
-			},
-		})
+		logger.WarnContext(c.Request.Context(), "请求失败", "error", err)
+		c.JSON(chatErrorResponse(err))
 		return
 	}
 
+	recordIDs := make([]string, 0, len(choices))
+	for _, choice := range choices {
+		if id, ok := choice["record_id"].(string); ok {
+			recordIDs = append(recordIDs, id)
+		}
+	}
+	if len(recordIDs) > 0 {
+		c.Header(headerRecordID, strings.Join(recordIDs, ","))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"id":      requestID,
 		"object":  "chat.completion",
 		"created": created,
 		"model":   model,
-		"choices": []gin.H{
-			{
-				"index": 0,
-				"message": gin.H{
-					"role":    "assistant",
-					"content": result.Content,
-				},
-				"finish_reason": "stop",
-			},
-		},
+		"choices": choices,
 		"usage": gin.H{
 			"prompt_tokens":     0,
 			"completion_tokens": 0,
@@ -124,7 +307,66 @@ func (h *OpenAIHandler) handleNonStreamRequest(c *gin.Context, messages []adp.Me
 	})
 }
 
-func (h *OpenAIHandler) handleStreamRequest(c *gin.Context, messages []adp.Message, requestID string, created int64, model string) {
+// completeChoice 完成一次非流式请求，返回assistant消息、finish_reason和ADP消息ID
+func (h *OpenAIHandler) completeChoice(ctx context.Context, req *ChatRequest) (gin.H, string, string, error) {
+	var result *adp.ChatResult
+	var err error
+	finishReason := "stop"
+	switch limiter := req.limiter(); {
+	case req.ResponseFormat.jsonMode():
+		// 截断会破坏JSON，JSON模式不执行stop/max_tokens
+		result, err = h.chatStructured(ctx, req, req.schema)
+	case limiter != nil:
+		// stop和max_tokens需要边生成边检查，以便及时取消上游
+		result, finishReason, err = h.chatLimited(ctx, req.client, req.adpMessages(), req.chatOptions(adp.ChatOptions{}), limiter)
+	default:
+		result, err = req.client.Chat(req.adpMessages(), req.chatOptions(adp.ChatOptions{
+			Stream:  false,
+			Context: ctx,
+		}))
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	h.records.add(req.owner, result.RecordID)
+
+	message := gin.H{
+		"role":    "assistant",
+		"content": result.Content,
+	}
+	if req.useTools() && finishReason == "stop" {
+		text, calls := parseToolCalls(result.Content, req.Tools)
+		if !req.toolChoice.satisfiedBy(calls) {
+			return nil, "", "", &ToolCallError{Choice: req.toolChoice, Content: result.Content}
+		}
+		if len(calls) > 0 {
+			message["content"] = nil
+			if text != "" {
+				message["content"] = text
+			}
+			message["tool_calls"] = calls
+			finishReason = "tool_calls"
+			return message, finishReason, result.RecordID, nil
+		}
+	}
+
+	if len(result.RecommendedQuestions) > 0 {
+		message["recommended_questions"] = result.RecommendedQuestions
+	}
+
+	// JSON模式下脚注会破坏JSON，只返回annotations
+	switch mode := h.cfg.Citations; {
+	case mode == CitationFootnotes && !req.ResponseFormat.jsonMode():
+		message["content"] = renderFootnotes(result.Content, result.References, result.Quotes, true)
+	case mode != CitationOff:
+		if annotations := urlCitations(result.Content, result.References, result.Quotes); len(annotations) > 0 {
+			message["annotations"] = annotations
+		}
+	}
+	return message, finishReason, result.RecordID, nil
+}
+
+func (h *OpenAIHandler) handleStreamRequest(c *gin.Context, req *ChatRequest, requestID string, created int64, model string) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
@@ -132,77 +374,347 @@ func (h *OpenAIHandler) handleStreamRequest(c *gin.Context, messages []adp.Messa
 
 	flusher, ok := c.Writer.(http.Flusher)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Streaming not supported",
-				"type":    "api_error",
-			},
-		})
+		c.JSON(http.StatusInternalServerError, errorBody("Streaming not supported", "api_error", ""))
 		return
 	}
 
-	done := make(chan struct{})
-	errCh := make(chan error, 1)
-
-	go func() {
-		_, err := h.client.Chat(messages, adp.ChatOptions{
-			Stream: true,
-			OnChunk: func(chunk adp.Chunk) {
-				var data []byte
-				
-				switch chunk.Type {
-				case "content":
-					data, _ = json.Marshal(gin.H{
-						"id":      requestID,
-						"object":  "chat.completion.chunk",
-						"created": created,
-						"model":   model,
-						"choices": []gin.H{
-							{
-								"index":         0,
-								"delta":         gin.H{"content": chunk.Content},
-								"finish_reason": nil,
-							},
-						},
-					})
-					c.Writer.Write([]byte(fmt.Sprintf("data: %s\n\n", data)))
-					flusher.Flush()
-
-				case "done":
-					data, _ = json.Marshal(gin.H{
-						"id":      requestID,
-						"object":  "chat.completion.chunk",
-						"created": created,
-						"model":   model,
-						"choices": []gin.H{
-							{
-								"index":         0,
-								"delta":         gin.H{},
-								"finish_reason": "stop",
-							},
-						},
-					})
-					c.Writer.Write([]byte(fmt.Sprintf("data: %s\n\n", data)))
-					c.Writer.Write([]byte("data: [DONE]\n\n"))
-					flusher.Flush()
-					close(done)
-				}
-			},
-		})
+	n := req.choices()
+	// 推荐问题在所有choice结束后以自定义事件输出
+	questions := make([][]string, n)
+	recordIDs := make([]string, n)
 
-		if err != nil {
-			errCh <- err
+	// 多个choice的数据块交错输出，按index区分
+	// 处理函数返回后gin会回收c，closed之后的输出全部丢弃
+	var writeMu sync.Mutex
+	closed := false
+	writeChunk := func(index int, delta gin.H, finishReason any) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if closed {
+			return
+		}
+		chunk := chatChunk(requestID, created, model, index, delta, finishReason)
+		if finishReason != nil && recordIDs[index] != "" {
+			chunk["choices"].([]gin.H)[0]["record_id"] = recordIDs[index]
 		}
+		writeSSE(c, flusher, chunk)
+	}
+
+	// 请求结束或出错时取消仍在生成的choice，并等待它们退出后再返回
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	var wg sync.WaitGroup
+	defer func() {
+		writeMu.Lock()
+		closed = true
+		writeMu.Unlock()
+		cancel()
+		wg.Wait()
 	}()
 
+	done := make(chan struct{})
+	errCh := make(chan error, n)
+	remaining := int32(n)
+	// 只需要第一个错误
+	fail := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			err := h.streamChoice(ctx, req, func(delta gin.H, finishReason any) {
+				writeChunk(index, delta, finishReason)
+				if finishReason != nil && atomic.AddInt32(&remaining, -1) == 0 {
+					close(done)
+				}
+			}, streamHooks{
+				record: func(recordID string) {
+					writeMu.Lock()
+					defer writeMu.Unlock()
+					if closed {
+						return
+					}
+					recordIDs[index] = recordID
+					// 响应头只能在首次输出前设置，n>1时无法对应所有choice
+					if n == 1 && !c.Writer.Written() {
+						c.Header(headerRecordID, recordID)
+					}
+				},
+				questions: func(q []string) {
+					questions[index] = q
+				},
+				fail: fail,
+			})
+			if err != nil {
+				fail(err)
+			}
+		}(i)
+	}
+
 	select {
 	case <-done:
+		writeMu.Lock()
+		writeRecommendedQuestions(c, flusher, questions)
+		writeDone(c, flusher)
+		writeMu.Unlock()
 		return
 	case err := <-errCh:
-		log.Printf("[OpenAIHandler] 流式请求失败: %v", err)
-		// 错误已经无法发送到客户端，因为已经开始流式响应
+		logger.WarnContext(c.Request.Context(), "流式请求失败", "error", err)
+		// 尚未输出任何数据时（如图片校验失败）仍可返回普通错误响应，否则在流中输出错误
+		writeMu.Lock()
+		writeStreamError(c, flusher, err)
+		closed = true
+		writeMu.Unlock()
 		return
 	case <-c.Request.Context().Done():
 		return
 	}
 }
+
+// streamHooks streamChoice交回的附加信息
+type streamHooks struct {
+	record    func(recordID string) // 首次得到ADP消息ID时调用，早于该choice的第一次输出
+	questions func([]string)        // 正常结束时调用，早于结束数据块
+	fail      func(err error)       // 回复不满足要求（如未调用要求的工具）时代替结束数据块调用
+}
+
+// streamChoice 流式生成一个choice，write在结束时以非nil的finishReason调用一次，或者改为调用hooks.fail
+func (h *OpenAIHandler) streamChoice(ctx context.Context, req *ChatRequest, write func(delta gin.H, finishReason any), hooks streamHooks) error {
+	// 启用工具时需要识别回复中的调用块，不能原样透传
+	var tools *toolCallStream
+	if req.useTools() {
+		tools = newToolCallStream(req.Tools, req.toolChoice)
+	}
+	limiter := req.limiter()
+
+	// 触发stop/max_tokens时只取消当前choice的上游生成
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	emit := func(content string) {
+		if tools != nil {
+			content = tools.Feed(content)
+		}
+		if content != "" {
+			write(gin.H{"content": content}, nil)
+		}
+	}
+
+	finished := false
+	recordID := ""
+	finish := func(finishReason string) {
+		finished = true
+		if tools != nil {
+			rest, calls := tools.Finish()
+			if finishReason == "stop" && !req.toolChoice.satisfiedBy(calls) {
+				hooks.fail(&ToolCallError{Choice: req.toolChoice, Content: rest})
+				return
+			}
+			if rest != "" {
+				write(gin.H{"content": rest}, nil)
+			}
+			for i, call := range calls {
+				write(toolCallDelta(i, call), nil)
+			}
+			if len(calls) > 0 && finishReason == "stop" {
+				finishReason = "tool_calls"
+			}
+		}
+		write(gin.H{}, finishReason)
+	}
+
+	_, err := req.client.Chat(req.adpMessages(), req.chatOptions(adp.ChatOptions{
+		Stream:  true,
+		Context: ctx,
+		OnChunk: func(chunk adp.Chunk) {
+			// 停止生成后仍可能收到已在途的数据块
+			if finished {
+				return
+			}
+			if recordID == "" && chunk.RecordID != "" {
+				recordID = chunk.RecordID
+				h.records.add(req.owner, recordID)
+				hooks.record(recordID)
+			}
+
+			switch chunk.Type {
+			case "content":
+				req.metrics.firstToken()
+				content := chunk.Content
+				if limiter != nil {
+					var stopped bool
+					content, stopped = limiter.Feed(content)
+					if stopped {
+						emit(content)
+						finish(limiter.FinishReason())
+						cancel()
+						return
+					}
+				}
+				emit(content)
+
+			case "done":
+				if limiter != nil {
+					emit(limiter.Flush())
+				}
+				h.emitCitations(chunk, tools, emit, write)
+				hooks.questions(chunk.RecommendedQuestions)
+				finish("stop")
+			}
+		},
+	}))
+
+	// 主动停止导致的取消不算错误
+	if err != nil && !(finished && errors.Is(err, context.Canceled)) {
+		return err
+	}
+	return nil
+}
+
+// fanOut 并发执行n次fn，返回第一个错误
+// 任一次失败时取消ctx，其余仍在生成的调用随之停止
+func fanOut(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	if n == 1 {
+		return fn(ctx, 0)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// chatChunk 构建流式响应的数据块
+func chatChunk(requestID string, created int64, model string, index int, delta gin.H, finishReason any) gin.H {
+	return gin.H{
+		"id":      requestID,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   model,
+		"choices": []gin.H{
+			{
+				"index":         index,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+}
+
+// writeSSE 输出一条SSE数据
+func writeSSE(c *gin.Context, flusher http.Flusher, v any) {
+	data, _ := json.Marshal(v)
+	c.Writer.Write([]byte(fmt.Sprintf("data: %s\n\n", data)))
+	flusher.Flush()
+}
+
+// writeRecommendedQuestions 在[DONE]之前以自定义事件输出各choice的推荐问题
+// 事件带有event字段，只处理data的OpenAI客户端会忽略
+func writeRecommendedQuestions(c *gin.Context, flusher http.Flusher, questions [][]string) {
+	for index, q := range questions {
+		if len(q) == 0 {
+			continue
+		}
+		data, _ := json.Marshal(gin.H{"index": index, "questions": q})
+		c.Writer.Write([]byte(fmt.Sprintf("event: recommended_questions\ndata: %s\n\n", data)))
+	}
+	flusher.Flush()
+}
+
+// writeDone 输出流结束标记
+func writeDone(c *gin.Context, flusher http.Flusher) {
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	flusher.Flush()
+}
+
+// writeStreamError 输出错误并结束流
+// 已经开始输出时无法再改状态码，按OpenAI的做法以error数据块通知客户端
+func writeStreamError(c *gin.Context, flusher http.Flusher, err error) {
+	if !c.Writer.Written() {
+		c.JSON(chatErrorResponse(err))
+		return
+	}
+	_, body := chatErrorResponse(err)
+	writeSSE(c, flusher, body)
+	writeDone(c, flusher)
+}
+
+// clientFor 返回请求使用的ADP客户端：携带自有凭证时为租户客户端，否则为网关默认客户端
+func clientFor(c *gin.Context, fallback *adp.Client) *adp.Client {
+	if client, ok := tenant.FromContext(c); ok {
+		return client
+	}
+	return fallback
+}
+
+// errorBody 构建OpenAI格式的错误响应
+func errorBody(message, errType, code string) gin.H {
+	return gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"code":    code,
+		},
+	}
+}
+
+// chatErrorResponse 将ADP调用错误映射为HTTP状态码和错误响应
+func chatErrorResponse(err error) (int, gin.H) {
+	var inputErr *adp.InputError
+	var structuredErr *StructuredOutputError
+	var toolErr *ToolCallError
+	var apiErr *tencentcloud.Error
+	switch {
+	case errors.As(err, &structuredErr):
+		body := errorBody(structuredErr.Error(), "invalid_response_error", "json_validation_failed")
+		body["error"].(gin.H)["attempts"] = structuredErr.Attempts
+		body["error"].(gin.H)["last_response"] = structuredErr.Content
+		return http.StatusBadGateway, body
+	case errors.As(err, &toolErr):
+		body := errorBody(toolErr.Error(), "invalid_response_error", "tool_call_missing")
+		body["error"].(gin.H)["last_response"] = toolErr.Content
+		return http.StatusBadGateway, body
+	case errors.Is(err, adp.ErrImageNotSupported):
+		return http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "image_not_supported")
+	case errors.Is(err, adp.ErrFileNotSupported):
+		return http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "file_not_supported")
+	case errors.As(err, &inputErr):
+		return http.StatusBadRequest, errorBody(inputErr.Message, "invalid_request_error", "invalid_content")
+	case errors.As(err, &apiErr):
+		return upstreamErrorResponse(apiErr)
+	}
+	return http.StatusInternalServerError, errorBody(err.Error(), "api_error", "internal_error")
+}
+
+// invalidParameterCodes 表示请求参数有误的腾讯云错误码前缀，其余错误视为上游故障
+var invalidParameterCodes = []string{"InvalidParameter", "MissingParameter", "UnknownParameter", "ResourceNotFound"}
+
+// upstreamErrorResponse 腾讯云API错误：参数错误（如不存在的RecordId）返回400，其余返回502
+func upstreamErrorResponse(err *tencentcloud.Error) (int, gin.H) {
+	for _, prefix := range invalidParameterCodes {
+		if strings.HasPrefix(err.Code, prefix) {
+			return http.StatusBadRequest, errorBody(err.Message, "invalid_request_error", "invalid_parameter")
+		}
+	}
+	return http.StatusBadGateway, errorBody("Upstream request failed: "+err.Code, "api_error", "upstream_error")
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/auth"
+	"github.com/brinkmai/adp-openai-gateway/internal/session"
+)
+
+// 消息记录分页大小
+const (
+	defaultMessageLimit = 20
+	maxMessageLimit     = 100
+)
+
+// CreateSessionRequest 创建会话请求
+type CreateSessionRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateSession 创建会话，聊天请求通过session_id引用后ADP会保留多轮上下文
+func (h *OpenAIHandler) CreateSession(c *gin.Context) {
+	var req CreateSessionRequest
+	// 请求体可以为空
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errorBody("Invalid request body", "invalid_request_error", "invalid_request"))
+			return
+		}
+	}
+
+	sess, err := h.cfg.Sessions.Create(requestOwner(c), req.Name)
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "创建会话失败", "error", err)
+		c.JSON(sessionLimit(err))
+		return
+	}
+	logger.InfoContext(c.Request.Context(), "创建会话", "gateway_session", sess.ID)
+	c.JSON(http.StatusOK, sessionObject(sess))
+}
+
+// GetSession 查询会话
+func (h *OpenAIHandler) GetSession(c *gin.Context) {
+	sess, ok := h.cfg.Sessions.Get(requestOwner(c), c.Param("id"))
+	if !ok {
+		c.JSON(sessionNotFound())
+		return
+	}
+	c.JSON(http.StatusOK, sessionObject(sess))
+}
+
+// ResetSession 重置会话：保留会话ID，改用新的ADP会话，之前的上下文不再生效
+func (h *OpenAIHandler) ResetSession(c *gin.Context) {
+	sess, ok := h.cfg.Sessions.Reset(requestOwner(c), c.Param("id"))
+	if !ok {
+		c.JSON(sessionNotFound())
+		return
+	}
+	logger.InfoContext(c.Request.Context(), "重置会话", "gateway_session", sess.ID)
+	c.JSON(http.StatusOK, sessionObject(sess))
+}
+
+// DeleteSession 删除会话
+func (h *OpenAIHandler) DeleteSession(c *gin.Context) {
+	id := c.Param("id")
+	if !h.cfg.Sessions.Delete(requestOwner(c), id) {
+		c.JSON(sessionNotFound())
+		return
+	}
+	logger.InfoContext(c.Request.Context(), "删除会话", "gateway_session", id)
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "session.deleted",
+		"deleted": true,
+	})
+}
+
+// ListSessionMessages 查询会话的消息记录，按时间正序返回
+// 分页参数：limit 每页数量，before 取该记录之前的消息（上一页的first_id）
+// 重置过的会话只返回重置之后的消息
+func (h *OpenAIHandler) ListSessionMessages(c *gin.Context) {
+	sess, ok := h.cfg.Sessions.Get(requestOwner(c), c.Param("id"))
+	if !ok {
+		c.JSON(sessionNotFound())
+		return
+	}
+	sessionID := sess.ADPSessionID
+
+	limit := defaultMessageLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxMessageLimit {
+			c.JSON(http.StatusBadRequest, errorBody("limit must be between 1 and 100", "invalid_request_error", "invalid_limit"))
+			return
+		}
+		limit = n
+	}
+
+	records, err := clientFor(c, h.client).MessageRecords(c.Request.Context(), sessionID, limit, c.Query("before"))
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "查询消息记录失败", "error", err)
+		c.JSON(chatErrorResponse(err))
+		return
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return recordTime(records[i]) < recordTime(records[j])
+	})
+
+	data := make([]gin.H, 0, len(records))
+	for _, r := range records {
+		data = append(data, sessionMessage(r))
+	}
+
+	resp := gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": nil,
+		"last_id":  nil,
+		// ADP不返回总数，取满一页时认为可能还有更早的消息
+		"has_more": len(records) == limit,
+	}
+	if len(records) > 0 {
+		resp["first_id"] = records[0].RecordID
+		resp["last_id"] = records[len(records)-1].RecordID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// sessionObject 会话的响应格式，不暴露ADP的session_id
+func sessionObject(sess session.Session) gin.H {
+	return gin.H{
+		"id":         sess.ID,
+		"object":     "session",
+		"name":       sess.Name,
+		"created_at": sess.CreatedAt.Unix(),
+		"expires_at": sess.ExpiresAt.Unix(),
+	}
+}
+
+// sessionNotFound 会话不存在、已过期或属于其他API Key
+func sessionNotFound() (int, gin.H) {
+	return http.StatusNotFound, errorBody("Session not found", "invalid_request_error", "session_not_found")
+}
+
+// sessionLimit 会话数达到上限时的错误响应
+func sessionLimit(err error) (int, gin.H) {
+	if errors.Is(err, session.ErrOwnerLimit) {
+		return http.StatusBadRequest, errorBody(fmt.Sprintf("Session limit of %d reached, delete unused sessions first.", session.MaxSessionsPerOwner),
+			"invalid_request_error", "session_limit_exceeded")
+	}
+	return http.StatusTooManyRequests, errorBody("Too many active sessions, please try again later.", "requests", "session_limit_exceeded")
+}
+
+// requestOwner 返回请求所属的API Key标识
+// 启用鉴权时使用Key名称，否则使用请求携带的Key的摘要，未携带Key的请求共用空标识
+func requestOwner(c *gin.Context) string {
+	if key, ok := auth.FromContext(c); ok {
+		return "key:" + key.Name
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if token == "" {
+		return ""
+	}
+	return auth.HashKey(token)
+}
+
+// sessionMessage 转换为OpenAI格式的消息
+func sessionMessage(r adp.MessageRecord) gin.H {
+	role := "assistant"
+	if r.IsFromSelf {
+		role = "user"
+	}
+
+	msg := gin.H{
+		"id":         r.RecordID,
+		"object":     "session.message",
+		"created_at": recordTime(r),
+		"role":       role,
+		"content":    r.Content,
+	}
+	switch r.Score {
+	case adp.RatingUp:
+		msg["rating"] = "up"
+	case adp.RatingDown:
+		msg["rating"] = "down"
+	}
+	return msg
+}
+
+// recordTime 解析消息记录的时间戳
+func recordTime(r adp.MessageRecord) int64 {
+	ts, _ := strconv.ParseInt(r.Timestamp, 10, 64)
+	return ts
+}
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/jsonschema"
+)
+
+// ResponseFormat OpenAI的response_format
+type ResponseFormat struct {
+	Type       string            `json:"type"` // text, json_object, json_schema
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat json_schema模式的定义
+type JSONSchemaFormat struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// StructuredOutputError 多次修复后回复仍不满足格式要求
+type StructuredOutputError struct {
+	Attempts int
+	Content  string // 最后一次回复
+	Err      error
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("model output did not pass validation after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *StructuredOutputError) Unwrap() error {
+	return e.Err
+}
+
+// jsonMode 是否需要JSON输出
+func (f *ResponseFormat) jsonMode() bool {
+	return f != nil && (f.Type == "json_object" || f.Type == "json_schema")
+}
+
+// compile 校验response_format并解析Schema
+func (f *ResponseFormat) compile() (*jsonschema.Schema, error) {
+	if f == nil {
+		return nil, nil
+	}
+	switch f.Type {
+	case "", "text", "json_object":
+		return nil, nil
+	case "json_schema":
+		if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+			return nil, errors.New("response_format.json_schema.schema is required")
+		}
+		schema, err := jsonschema.Compile(f.JSONSchema.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response_format.json_schema.schema: %v", err)
+		}
+		return schema, nil
+	}
+	return nil, fmt.Errorf("unsupported response_format type %q", f.Type)
+}
+
+// instruction 追加到请求中的格式说明
+func (f *ResponseFormat) instruction() string {
+	var sb strings.Builder
+	sb.WriteString("\n\n# Output format\n")
+	sb.WriteString("Reply with a single valid JSON value only. Do not wrap it in markdown code fences and do not add any explanation.\n")
+	if f.Type == "json_schema" {
+		if f.JSONSchema.Description != "" {
+			sb.WriteString(f.JSONSchema.Description + "\n")
+		}
+		sb.WriteString("The JSON must conform to this JSON Schema:\n")
+		sb.WriteString(string(f.JSONSchema.Schema) + "\n")
+	} else {
+		sb.WriteString("The JSON must be an object.\n")
+	}
+	return sb.String()
+}
+
+// withInstruction 把说明追加到最后一条消息的文本中
+func withInstruction(messages []adp.Message, instruction string) []adp.Message {
+	out := make([]adp.Message, len(messages))
+	copy(out, messages)
+
+	last := &out[len(out)-1]
+	switch v := last.Content.(type) {
+	case string:
+		last.Content = v + instruction
+	default:
+		parts := []adp.ContentPart{{Type: "text", Text: textContent(v) + instruction}}
+		last.Content = append(parts, attachmentParts(v)...)
+	}
+	return out
+}
+
+// checkJSON 提取并校验回复中的JSON，返回规范化后的内容
+func checkJSON(content string, schema *jsonschema.Schema) (string, error) {
+	text := extractJSON(content)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return "", fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+
+	if schema == nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "", errors.New("reply must be a JSON object")
+		}
+		return text, nil
+	}
+	if err := schema.Validate([]byte(text)); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// extractJSON 去掉模型常见的代码块包裹和前后说明
+func extractJSON(content string) string {
+	text := strings.TrimSpace(content)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+		return strings.TrimSpace(text)
+	}
+
+	if json.Valid([]byte(text)) {
+		return text
+	}
+
+	// 截取第一个 { 或 [ 到最后一个 } 或 ]
+	start := strings.IndexAny(text, "{[")
+	end := strings.LastIndexAny(text, "}]")
+	if start >= 0 && end > start {
+		return text[start : end+1]
+	}
+	return text
+}
+
+// chatStructured 请求JSON输出并在网关侧校验，失败时在同一会话中要求模型修正
+func (h *OpenAIHandler) chatStructured(ctx context.Context, req *ChatRequest, schema *jsonschema.Schema) (*adp.ChatResult, error) {
+	// 修正要求依赖ADP会话中的上一轮回复
+	sessionID := req.adpSession
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+	messages := withInstruction(req.adpMessages(), req.ResponseFormat.instruction())
+
+	attempts := h.cfg.JSONRetries + 1
+	var lastErr error
+	var lastContent string
+	for attempt := 1; attempt <= attempts; attempt++ {
+		result, err := req.client.Chat(messages, req.chatOptions(adp.ChatOptions{
+			SessionID: sessionID,
+			Stream:    false,
+			Context:   ctx,
+		}))
+		if err != nil {
+			return nil, err
+		}
+
+		// 模型选择调用工具时不做JSON校验
+		if req.useTools() {
+			if _, calls := parseToolCalls(result.Content, req.Tools); len(calls) > 0 {
+				return result, nil
+			}
+		}
+
+		content, err := checkJSON(result.Content, schema)
+		if err == nil {
+			result.Content = content
+			return result, nil
+		}
+
+		logger.InfoContext(ctx, "JSON校验失败", "attempt", attempt, "error", err)
+		lastErr = err
+		lastContent = result.Content
+
+		// ADP会话保留了上一轮回复，只需发送修正要求
+		messages = []adp.Message{{
+			Role: "user",
+			Content: fmt.Sprintf("Your previous reply was rejected: %v\nReply again with only the corrected JSON.%s",
+				err, req.ResponseFormat.instruction()),
+		}}
+	}
+
+	return nil, &StructuredOutputError{Attempts: attempts, Content: lastContent, Err: lastErr}
+}
+
+// handleStructuredStream 流式请求的JSON模式：校验通过后一次性输出
+func (h *OpenAIHandler) handleStructuredStream(c *gin.Context, req *ChatRequest, requestID string, created int64, model string) {
+	results := make([]*adp.ChatResult, req.choices())
+	err := fanOut(c.Request.Context(), len(results), func(ctx context.Context, i int) error {
+		result, err := h.chatStructured(ctx, req, req.schema)
+		results[i] = result
+		return err
+	})
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "流式请求失败", "error", err)
+		c.JSON(chatErrorResponse(err))
+		return
+	}
+
+	recordIDs := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.RecordID != "" {
+			h.records.add(req.owner, result.RecordID)
+			recordIDs = append(recordIDs, result.RecordID)
+		}
+	}
+	if len(recordIDs) > 0 {
+		c.Header(headerRecordID, strings.Join(recordIDs, ","))
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorBody("Streaming not supported", "api_error", ""))
+		return
+	}
+
+	questions := make([][]string, len(results))
+	for index, result := range results {
+		questions[index] = result.RecommendedQuestions
+		finishReason := "stop"
+		content := result.Content
+		var calls []adp.ToolCall
+		if req.useTools() {
+			content, calls = parseToolCalls(result.Content, req.Tools)
+		}
+		if content != "" {
+			writeSSE(c, flusher, chatChunk(requestID, created, model, index, gin.H{"role": "assistant", "content": content}, nil))
+		}
+		for i, call := range calls {
+			writeSSE(c, flusher, chatChunk(requestID, created, model, index, toolCallDelta(i, call), nil))
+			finishReason = "tool_calls"
+		}
+		if len(calls) == 0 && h.cfg.Citations != CitationOff {
+			if annotations := urlCitations(result.Content, result.References, result.Quotes); len(annotations) > 0 {
+				writeSSE(c, flusher, chatChunk(requestID, created, model, index, gin.H{"annotations": annotations}, nil))
+			}
+		}
+		final := chatChunk(requestID, created, model, index, gin.H{}, finishReason)
+		if result.RecordID != "" {
+			final["choices"].([]gin.H)[0]["record_id"] = result.RecordID
+		}
+		writeSSE(c, flusher, final)
+	}
+	writeRecommendedQuestions(c, flusher, questions)
+	writeDone(c, flusher)
+}
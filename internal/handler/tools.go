@@ -0,0 +1,376 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// ADP没有原生的函数调用能力，网关把工具定义写进提示词，
+// 再从回复中解析出约定格式的调用块
+const (
+	toolCallsOpen  = "<tool_calls>"
+	toolCallsClose = "</tool_calls>"
+)
+
+// Tool OpenAI工具定义
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 函数定义
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// toolChoice 解析后的tool_choice
+type toolChoice struct {
+	Mode string // auto, none, required, function
+	Name string // Mode为function时指定的函数名
+}
+
+// parseToolChoice 解析tool_choice，支持字符串和 {"type":"function","function":{"name":...}}
+func parseToolChoice(v any, tools []Tool) (toolChoice, error) {
+	switch choice := v.(type) {
+	case nil:
+		return toolChoice{Mode: "auto"}, nil
+	case string:
+		switch choice {
+		case "auto", "none", "required":
+			return toolChoice{Mode: choice}, nil
+		}
+		return toolChoice{}, fmt.Errorf("invalid tool_choice %q", choice)
+	case map[string]interface{}:
+		fn, _ := choice["function"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		if name == "" {
+			return toolChoice{}, errors.New("tool_choice.function.name is required")
+		}
+		for _, t := range tools {
+			if t.Function.Name == name {
+				return toolChoice{Mode: "function", Name: name}, nil
+			}
+		}
+		return toolChoice{}, fmt.Errorf("tool_choice references unknown function %q", name)
+	}
+	return toolChoice{}, errors.New("invalid tool_choice")
+}
+
+// requiresCall tool_choice是否要求本轮必须调用工具
+func (c toolChoice) requiresCall() bool {
+	return c.Mode == "required" || c.Mode == "function"
+}
+
+// satisfiedBy 解析出的调用是否满足tool_choice的要求
+func (c toolChoice) satisfiedBy(calls []adp.ToolCall) bool {
+	switch c.Mode {
+	case "required":
+		return len(calls) > 0
+	case "function":
+		for _, call := range calls {
+			if call.Function.Name == c.Name {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ToolCallError tool_choice要求调用工具，但回复中没有相应的调用
+type ToolCallError struct {
+	Choice  toolChoice
+	Content string // 模型的回复
+}
+
+func (e *ToolCallError) Error() string {
+	if e.Choice.Mode == "function" {
+		return fmt.Sprintf("model did not call the required tool %q", e.Choice.Name)
+	}
+	return "model did not call any tool although tool_choice is required"
+}
+
+// validateTools 校验工具定义
+func validateTools(tools []Tool) error {
+	seen := make(map[string]bool, len(tools))
+	for _, t := range tools {
+		if t.Type != "" && t.Type != "function" {
+			return fmt.Errorf("unsupported tool type %q", t.Type)
+		}
+		if t.Function.Name == "" {
+			return errors.New("tools[].function.name is required")
+		}
+		if seen[t.Function.Name] {
+			return fmt.Errorf("duplicate tool %q", t.Function.Name)
+		}
+		seen[t.Function.Name] = true
+	}
+	return nil
+}
+
+// toolPromptMessages 把工具定义和本轮工具调用往来写进发送给ADP的最后一条消息
+// ADP只接收最后一条消息，因此从最近一条用户消息开始的往来都需要展开为文本
+func toolPromptMessages(messages []adp.Message, tools []Tool, choice toolChoice) []adp.Message {
+	var sb strings.Builder
+	sb.WriteString("You can call the following tools to help answer the user.\n\n")
+	sb.WriteString("# Tools\n")
+	for _, t := range tools {
+		sb.WriteString(fmt.Sprintf("\n## %s\n", t.Function.Name))
+		if t.Function.Description != "" {
+			sb.WriteString(t.Function.Description + "\n")
+		}
+		if len(t.Function.Parameters) > 0 {
+			sb.WriteString("Parameters (JSON Schema): " + string(t.Function.Parameters) + "\n")
+		}
+	}
+
+	sb.WriteString("\n# How to call tools\n")
+	sb.WriteString("To call tools, reply with ONLY the following block and nothing else:\n")
+	sb.WriteString(toolCallsOpen + "\n")
+	sb.WriteString(`[{"name": "<tool name>", "arguments": {<arguments as a JSON object>}}]` + "\n")
+	sb.WriteString(toolCallsClose + "\n")
+	sb.WriteString("You may include several calls in the array. Tool results will be sent back to you in a later message.\n")
+	switch choice.Mode {
+	case "required":
+		sb.WriteString("You MUST call at least one tool in this reply.\n")
+	case "function":
+		sb.WriteString(fmt.Sprintf("You MUST call the tool %q in this reply.\n", choice.Name))
+	default:
+		sb.WriteString("If no tool is needed, answer the user directly without the block.\n")
+	}
+
+	// 找到最近一条用户消息
+	last := len(messages) - 1
+	start := last
+	for i := last; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			start = i
+			break
+		}
+	}
+
+	sb.WriteString("\n# Conversation\n")
+	for _, m := range messages[start:] {
+		switch m.Role {
+		case "user":
+			sb.WriteString("User: " + textContent(m.Content) + "\n")
+		case "assistant":
+			if text := textContent(m.Content); text != "" {
+				sb.WriteString("Assistant: " + text + "\n")
+			}
+			for _, call := range m.ToolCalls {
+				sb.WriteString(fmt.Sprintf("Assistant called tool %s (id %s) with arguments: %s\n",
+					call.Function.Name, call.ID, call.Function.Arguments))
+			}
+		case "tool":
+			sb.WriteString(fmt.Sprintf("Tool result (id %s): %s\n", m.ToolCallID, textContent(m.Content)))
+		}
+	}
+	if messages[last].Role == "tool" {
+		sb.WriteString("\nUse the tool results above to continue.\n")
+	}
+
+	// 保留用户消息中的图片和文件
+	parts := []adp.ContentPart{{Type: "text", Text: sb.String()}}
+	parts = append(parts, attachmentParts(messages[start].Content)...)
+
+	return []adp.Message{{Role: "user", Content: parts}}
+}
+
+// parseToolCalls 从完整回复中解析工具调用，返回调用块以外的文本
+// 调用块无法解析或引用了未定义的工具时按普通文本处理
+func parseToolCalls(content string, tools []Tool) (string, []adp.ToolCall) {
+	open := strings.Index(content, toolCallsOpen)
+	if open < 0 {
+		return content, nil
+	}
+
+	body := content[open+len(toolCallsOpen):]
+	rest := ""
+	if end := strings.Index(body, toolCallsClose); end >= 0 {
+		rest = body[end+len(toolCallsClose):]
+		body = body[:end]
+	}
+	body = strings.TrimSpace(body)
+	body = strings.TrimPrefix(body, "```json")
+	body = strings.Trim(body, "` \n")
+
+	var raw []struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(body), &raw); err != nil {
+		// 兼容只返回单个对象的情况
+		var single struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(body), &single); err != nil {
+			return content, nil
+		}
+		raw = append(raw, single)
+	}
+
+	known := make(map[string]bool, len(tools))
+	for _, t := range tools {
+		known[t.Function.Name] = true
+	}
+
+	calls := make([]adp.ToolCall, 0, len(raw))
+	for _, r := range raw {
+		if !known[r.Name] {
+			return content, nil
+		}
+		args := "{}"
+		if len(r.Arguments) > 0 {
+			// 参数可能是对象，也可能已经是JSON字符串
+			var s string
+			if err := json.Unmarshal(r.Arguments, &s); err == nil {
+				args = s
+			} else {
+				args = string(r.Arguments)
+			}
+		}
+		calls = append(calls, adp.ToolCall{
+			ID:   "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+			Type: "function",
+			Function: adp.ToolCallFunction{
+				Name:      r.Name,
+				Arguments: args,
+			},
+		})
+	}
+	if len(calls) == 0 {
+		return content, nil
+	}
+
+	text := strings.TrimSpace(content[:open] + rest)
+	return text, calls
+}
+
+// toolCallStream 流式输出时识别调用块：块之前的内容照常输出，块本身缓存到结束后解析
+// 要求调用工具时缓存全部内容，没有调用时不会输出任何文本
+type toolCallStream struct {
+	tools   []Tool
+	pending string // 可能是调用块开头的尾部内容
+	block   strings.Builder
+	inBlock bool
+}
+
+// newToolCallStream 创建流式调用块识别器
+func newToolCallStream(tools []Tool, choice toolChoice) *toolCallStream {
+	return &toolCallStream{tools: tools, inBlock: choice.requiresCall()}
+}
+
+// Feed 输入增量内容，返回可以立即输出的部分
+func (s *toolCallStream) Feed(delta string) string {
+	if s.inBlock {
+		s.block.WriteString(delta)
+		return ""
+	}
+
+	text := s.pending + delta
+	if i := strings.Index(text, toolCallsOpen); i >= 0 {
+		s.inBlock = true
+		s.pending = ""
+		s.block.WriteString(text[i:])
+		return text[:i]
+	}
+
+	keep := partialSuffix(text, toolCallsOpen)
+	s.pending = text[len(text)-keep:]
+	return text[:len(text)-keep]
+}
+
+// Finish 输出结束，返回剩余文本和解析出的工具调用
+func (s *toolCallStream) Finish() (string, []adp.ToolCall) {
+	if !s.inBlock {
+		rest := s.pending
+		s.pending = ""
+		return rest, nil
+	}
+	return parseToolCalls(s.block.String(), s.tools)
+}
+
+// toolCallDelta 构建流式输出中的delta.tool_calls
+func toolCallDelta(index int, call adp.ToolCall) map[string]any {
+	return map[string]any{
+		"tool_calls": []map[string]any{
+			{
+				"index":    index,
+				"id":       call.ID,
+				"type":     call.Type,
+				"function": call.Function,
+			},
+		},
+	}
+}
+
+// partialSuffix 返回text末尾与marker前缀重合的最大长度（不含完整匹配）
+func partialSuffix(text, marker string) int {
+	n := len(marker) - 1
+	if n > len(text) {
+		n = len(text)
+	}
+	for k := n; k > 0; k-- {
+		if strings.HasSuffix(text, marker[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+// textContent 提取消息中的文本
+func textContent(content any) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []adp.ContentPart:
+		var sb strings.Builder
+		for _, p := range v {
+			if p.Type == "text" {
+				sb.WriteString(p.Text)
+			}
+		}
+		return sb.String()
+	case []interface{}:
+		var sb strings.Builder
+		for _, part := range v {
+			if p, ok := part.(map[string]interface{}); ok && p["type"] == "text" {
+				if text, ok := p["text"].(string); ok {
+					sb.WriteString(text)
+				}
+			}
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+// attachmentParts 提取消息中的非文本内容（图片、文件）
+func attachmentParts(content any) []adp.ContentPart {
+	var parts []adp.ContentPart
+	switch v := content.(type) {
+	case []adp.ContentPart:
+		parts = v
+	case []interface{}:
+		raw, _ := json.Marshal(v)
+		json.Unmarshal(raw, &parts)
+	}
+
+	attachments := make([]adp.ContentPart, 0, len(parts))
+	for _, p := range parts {
+		if p.Type != "text" {
+			attachments = append(attachments, p)
+		}
+	}
+	return attachments
+}
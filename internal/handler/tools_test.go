@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+var testTools = []Tool{
+	{Type: "function", Function: ToolFunction{Name: "get_weather"}},
+	{Type: "function", Function: ToolFunction{Name: "get_time"}},
+}
+
+func TestParseToolCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantText string
+		wantArgs []string // 每个调用的arguments，nil表示没有解析出调用
+	}{
+		{
+			name:     "array",
+			content:  `<tool_calls>[{"name":"get_weather","arguments":{"city":"Beijing"}},{"name":"get_time","arguments":{}}]</tool_calls>`,
+			wantArgs: []string{`{"city":"Beijing"}`, `{}`},
+		},
+		{
+			name:     "single object",
+			content:  `<tool_calls>{"name":"get_time","arguments":{"tz":"UTC"}}</tool_calls>`,
+			wantArgs: []string{`{"tz":"UTC"}`},
+		},
+		{
+			name:     "text before and after",
+			content:  "Let me check.\n<tool_calls>[{\"name\":\"get_time\",\"arguments\":{}}]</tool_calls>\nOne moment.",
+			wantText: "Let me check.\n\nOne moment.",
+			wantArgs: []string{`{}`},
+		},
+		{
+			name:     "fenced json",
+			content:  "<tool_calls>\n```json\n[{\"name\":\"get_time\",\"arguments\":{}}]\n```\n</tool_calls>",
+			wantArgs: []string{`{}`},
+		},
+		{
+			name:     "missing arguments",
+			content:  `<tool_calls>[{"name":"get_time"}]</tool_calls>`,
+			wantArgs: []string{`{}`},
+		},
+		{
+			name:     "arguments as string",
+			content:  `<tool_calls>[{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}]</tool_calls>`,
+			wantArgs: []string{`{"city":"Paris"}`},
+		},
+		{
+			name:     "missing close tag",
+			content:  `<tool_calls>[{"name":"get_time","arguments":{}}]`,
+			wantArgs: []string{`{}`},
+		},
+		{
+			name:     "malformed JSON",
+			content:  `<tool_calls>[{"name":"get_time",</tool_calls>`,
+			wantText: `<tool_calls>[{"name":"get_time",</tool_calls>`,
+		},
+		{
+			name:     "unknown tool",
+			content:  `<tool_calls>[{"name":"rm_rf","arguments":{}}]</tool_calls>`,
+			wantText: `<tool_calls>[{"name":"rm_rf","arguments":{}}]</tool_calls>`,
+		},
+		{
+			name:     "empty array",
+			content:  `<tool_calls>[]</tool_calls>`,
+			wantText: `<tool_calls>[]</tool_calls>`,
+		},
+		{
+			name:     "no block",
+			content:  "It is sunny.",
+			wantText: "It is sunny.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, calls := parseToolCalls(tt.content, testTools)
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if len(calls) != len(tt.wantArgs) {
+				t.Fatalf("calls = %+v, want %d", calls, len(tt.wantArgs))
+			}
+			for i, call := range calls {
+				if call.Function.Arguments != tt.wantArgs[i] {
+					t.Errorf("calls[%d].arguments = %s, want %s", i, call.Function.Arguments, tt.wantArgs[i])
+				}
+				if !strings.HasPrefix(call.ID, "call_") || call.Type != "function" {
+					t.Errorf("calls[%d] = %+v", i, call)
+				}
+			}
+		})
+	}
+}
+
+func TestToolCallStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		choice   toolChoice
+		wantOut  string // Feed和Finish输出的文本
+		wantCall string
+	}{
+		{
+			name:    "plain text",
+			chunks:  []string{"It is ", "sunny."},
+			wantOut: "It is sunny.",
+		},
+		{
+			name:    "partial tag that is not a block",
+			chunks:  []string{"a <tool", "box> b"},
+			wantOut: "a <toolbox> b",
+		},
+		{
+			name:     "tag split across chunks",
+			chunks:   []string{"Checking.<tool", "_ca", `lls>[{"name":"get_time"}]</tool_`, "calls>"},
+			wantOut:  "Checking.",
+			wantCall: "get_time",
+		},
+		{
+			name:     "text after block",
+			chunks:   []string{`<tool_calls>[{"name":"get_time"}]</tool_calls>`, " Done."},
+			wantOut:  "Done.",
+			wantCall: "get_time",
+		},
+		{
+			name:    "malformed block",
+			chunks:  []string{"<tool_calls>[{", "oops</tool_calls>"},
+			wantOut: "<tool_calls>[{oops</tool_calls>",
+		},
+		{
+			name:    "required holds text",
+			chunks:  []string{"It is ", "sunny."},
+			choice:  toolChoice{Mode: "required"},
+			wantOut: "It is sunny.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newToolCallStream(testTools, tt.choice)
+			var out strings.Builder
+			for _, chunk := range tt.chunks {
+				text := s.Feed(chunk)
+				if tt.choice.requiresCall() && text != "" {
+					t.Errorf("Feed(%q) = %q, want nothing before the reply is complete", chunk, text)
+				}
+				out.WriteString(text)
+			}
+			rest, calls := s.Finish()
+			out.WriteString(rest)
+			if out.String() != tt.wantOut {
+				t.Errorf("output = %q, want %q", out.String(), tt.wantOut)
+			}
+			name := ""
+			if len(calls) > 0 {
+				name = calls[0].Function.Name
+			}
+			if name != tt.wantCall {
+				t.Errorf("call = %q, want %q", name, tt.wantCall)
+			}
+		})
+	}
+}
+
+func TestToolChoiceSatisfiedBy(t *testing.T) {
+	calls := []adp.ToolCall{{Function: adp.ToolCallFunction{Name: "get_time"}}}
+	tests := []struct {
+		choice toolChoice
+		calls  []adp.ToolCall
+		want   bool
+	}{
+		{toolChoice{Mode: "auto"}, nil, true},
+		{toolChoice{Mode: "required"}, nil, false},
+		{toolChoice{Mode: "required"}, calls, true},
+		{toolChoice{Mode: "function", Name: "get_time"}, calls, true},
+		{toolChoice{Mode: "function", Name: "get_weather"}, calls, false},
+	}
+	for _, tt := range tests {
+		if got := tt.choice.satisfiedBy(tt.calls); got != tt.want {
+			t.Errorf("%+v.satisfiedBy(%d calls) = %v, want %v", tt.choice, len(tt.calls), got, tt.want)
+		}
+	}
+}
+
+func TestChatToolChoiceEnforced(t *testing.T) {
+	_, client := newFakeADP(t, func(requestID, content string) []string {
+		if strings.Contains(content, "User: weather") {
+			return replyFrames(requestID, `<tool_calls>[{"name":"get_weather","arguments":{"city":"Beijing"}}]</tool_calls>`)
+		}
+		return replyFrames(requestID, "I am not ", "calling anything.")
+	})
+	h := NewOpenAIHandler(client, Config{Models: Models{defaultModel: {}}})
+	r := gin.New()
+	r.POST("/v1/chat/completions", h.ChatCompletions)
+
+	tools, _ := json.Marshal(testTools)
+	tests := []struct {
+		name       string
+		message    string
+		toolChoice string
+		stream     bool
+		status     int
+		want       string
+	}{
+		{"required without call", "hello", `"required"`, false, http.StatusBadGateway, "tool_call_missing"},
+		{"function without call", "hello", `{"type":"function","function":{"name":"get_weather"}}`, false, http.StatusBadGateway, `required tool \"get_weather\"`},
+		{"other function called", "weather", `{"type":"function","function":{"name":"get_time"}}`, false, http.StatusBadGateway, "tool_call_missing"},
+		{"required with call", "weather", `"required"`, false, http.StatusOK, `"finish_reason":"tool_calls"`},
+		{"auto without call", "hello", `"auto"`, false, http.StatusOK, "calling anything."},
+		{"stream required without call", "hello", `"required"`, true, http.StatusBadGateway, "tool_call_missing"},
+		{"stream required with call", "weather", `"required"`, true, http.StatusOK, `"finish_reason":"tool_calls"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"messages":[{"role":"user","content":"` + tt.message + `"}],"tools":` + string(tools) +
+				`,"tool_choice":` + tt.toolChoice + `,"stream":` + map[bool]string{true: "true", false: "false"}[tt.stream] + `}`
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("status = %d, body = %s, want %d containing %s", w.Code, w.Body, tt.status, tt.want)
+			}
+			if w.Code != http.StatusOK && strings.Contains(w.Body.String(), "data:") {
+				t.Errorf("reply text was streamed before the error: %s", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+// 通过请求头传递访客信息：
+//
+//	X-ADP-Visitor-Id: <visitor_biz_id>
+//	X-ADP-Var-<name>: <value>
+//	X-ADP-Label-<name>: <value1>,<value2>
+const (
+	headerVisitorID   = "X-Adp-Visitor-Id"
+	headerVarPrefix   = "X-Adp-Var-"
+	headerLabelPrefix = "X-Adp-Label-"
+)
+
+// Allowlist 允许客户端设置的键，包含 "*" 时允许任意键
+type Allowlist []string
+
+// ParseAllowlist 解析逗号分隔的键列表
+func ParseAllowlist(s string) Allowlist {
+	var list Allowlist
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			list = append(list, key)
+		}
+	}
+	return list
+}
+
+// match 返回允许的键名（以配置中的写法为准）
+// 请求头名称会被规范化大小写，因此按不区分大小写匹配
+func (l Allowlist) match(key string) (string, bool) {
+	for _, allowed := range l {
+		if allowed == "*" {
+			return key, true
+		}
+		if strings.EqualFold(allowed, key) {
+			return allowed, true
+		}
+	}
+	return "", false
+}
+
+// visitor 透传给ADP的访客信息
+type visitor struct {
+	bizID     string
+	variables map[string]string
+	labels    []adp.VisitorLabel
+}
+
+// apply 把访客信息写入聊天选项
+func (v visitor) apply(opts adp.ChatOptions) adp.ChatOptions {
+	opts.VisitorBizID = v.bizID
+	opts.CustomVariables = v.variables
+	opts.VisitorLabels = v.labels
+	return opts
+}
+
+// parseVisitor 从user、metadata和X-ADP-*请求头提取访客信息，请求头优先
+// 不在白名单中的键直接忽略，避免客户端随意改变工作流分支
+func (h *OpenAIHandler) parseVisitor(ctx context.Context, header http.Header, user string, metadata map[string]string) visitor {
+	v := visitor{bizID: user}
+	if id := header.Get(headerVisitorID); id != "" {
+		v.bizID = id
+	}
+
+	setVariable := func(key, value string) {
+		name, ok := h.cfg.AllowedVariables.match(key)
+		if !ok {
+			logger.DebugContext(ctx, "忽略未允许的自定义参数", "key", key)
+			return
+		}
+		if v.variables == nil {
+			v.variables = make(map[string]string)
+		}
+		v.variables[name] = value
+	}
+
+	for key, value := range metadata {
+		setVariable(key, value)
+	}
+
+	labels := make(map[string][]string)
+	var order []string
+	for key, values := range header {
+		switch {
+		case strings.HasPrefix(key, headerVarPrefix) && len(values) > 0:
+			// 使用"*"时无法还原请求头原本的大小写，统一为小写
+			setVariable(strings.ToLower(key[len(headerVarPrefix):]), values[0])
+		case strings.HasPrefix(key, headerLabelPrefix):
+			raw := strings.ToLower(key[len(headerLabelPrefix):])
+			name, ok := h.cfg.AllowedLabels.match(raw)
+			if !ok {
+				logger.DebugContext(ctx, "忽略未允许的访客标签", "key", raw)
+				continue
+			}
+			if _, seen := labels[name]; !seen {
+				order = append(order, name)
+			}
+			for _, value := range values {
+				for _, item := range strings.Split(value, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						labels[name] = append(labels[name], item)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(order)
+	for _, name := range order {
+		v.labels = append(v.labels, adp.VisitorLabel{Name: name, Values: labels[name]})
+	}
+	return v
+}
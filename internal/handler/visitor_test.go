@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	got := ParseAllowlist(" region, ,tier,")
+	if !reflect.DeepEqual(got, Allowlist{"region", "tier"}) {
+		t.Errorf("ParseAllowlist = %q", got)
+	}
+	if got := ParseAllowlist(""); got != nil {
+		t.Errorf("ParseAllowlist(\"\") = %q, want nil", got)
+	}
+}
+
+func TestParseVisitor(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables Allowlist
+		labels    Allowlist
+		headers   map[string][]string
+		user      string
+		metadata  map[string]string
+		want      visitor
+	}{
+		{
+			name: "user as visitor id",
+			user: "user-1",
+			want: visitor{bizID: "user-1"},
+		},
+		{
+			name:    "header takes precedence over user",
+			headers: map[string][]string{"X-Adp-Visitor-Id": {"visitor-9"}},
+			user:    "user-1",
+			want:    visitor{bizID: "visitor-9"},
+		},
+		{
+			name:    "empty header falls back to user",
+			headers: map[string][]string{"X-Adp-Visitor-Id": {""}},
+			user:    "user-1",
+			want:    visitor{bizID: "user-1"},
+		},
+		{
+			name:     "nothing allowed",
+			headers:  map[string][]string{"X-Adp-Var-Region": {"cn"}, "X-Adp-Label-Tier": {"gold"}},
+			metadata: map[string]string{"region": "us"},
+			want:     visitor{},
+		},
+		{
+			name:      "variables filtered by allowlist",
+			variables: Allowlist{"Region"},
+			headers:   map[string][]string{"X-Adp-Var-Region": {"cn"}, "X-Adp-Var-Branch": {"admin"}},
+			metadata:  map[string]string{"region": "us", "debug": "1"},
+			// 请求头优先于metadata，键名以配置中的写法为准
+			want: visitor{variables: map[string]string{"Region": "cn"}},
+		},
+		{
+			name:      "metadata only",
+			variables: Allowlist{"region"},
+			metadata:  map[string]string{"region": "us"},
+			want:      visitor{variables: map[string]string{"region": "us"}},
+		},
+		{
+			name:      "wildcard variables are lowercased",
+			variables: Allowlist{"*"},
+			headers:   map[string][]string{"X-Adp-Var-Userlevel": {"vip", "ignored"}},
+			metadata:  map[string]string{"Plan": "pro"},
+			want:      visitor{variables: map[string]string{"userlevel": "vip", "Plan": "pro"}},
+		},
+		{
+			name:   "labels filtered, split and sorted",
+			labels: Allowlist{"tier", "City"},
+			headers: map[string][]string{
+				"X-Adp-Label-Tier":  {"gold, silver", "bronze"},
+				"X-Adp-Label-City":  {"beijing,,"},
+				"X-Adp-Label-Admin": {"true"},
+			},
+			want: visitor{labels: []adp.VisitorLabel{
+				{Name: "City", Values: []string{"beijing"}},
+				{Name: "tier", Values: []string{"gold", "silver", "bronze"}},
+			}},
+		},
+		{
+			name:    "labels are not variables",
+			labels:  Allowlist{"tier"},
+			headers: map[string][]string{"X-Adp-Var-Tier": {"gold"}},
+			want:    visitor{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewOpenAIHandler(nil, Config{AllowedVariables: tt.variables, AllowedLabels: tt.labels})
+			header := http.Header{}
+			for key, values := range tt.headers {
+				for _, value := range values {
+					header.Add(key, value)
+				}
+			}
+			got := h.parseVisitor(context.Background(), header, tt.user, tt.metadata)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVisitor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisitorApply(t *testing.T) {
+	v := visitor{
+		bizID:     "visitor-9",
+		variables: map[string]string{"region": "cn"},
+		labels:    []adp.VisitorLabel{{Name: "tier", Values: []string{"gold"}}},
+	}
+	opts := v.apply(adp.ChatOptions{SessionID: "s"})
+	if opts.SessionID != "s" || opts.VisitorBizID != "visitor-9" ||
+		opts.CustomVariables["region"] != "cn" || len(opts.VisitorLabels) != 1 {
+		t.Errorf("apply() = %+v", opts)
+	}
+}
@@ -0,0 +1,453 @@
+// Package jsonschema 实现结构化输出校验所需的JSON Schema子集
+//
+// 支持 type、enum、const、properties、required、additionalProperties、
+// items、min/maxItems、min/maxLength、pattern、minimum/maximum、
+// anyOf/oneOf/allOf 以及本地 $ref（#/$defs/...、#/definitions/...）
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema 已解析的Schema
+type Schema struct {
+	root map[string]interface{}
+	// patterns 预编译的pattern，以原始字符串为键
+	patterns map[string]*regexp.Regexp
+}
+
+// Compile 解析Schema，预编译所有pattern并检查$ref能否解析
+func Compile(raw []byte) (*Schema, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("schema must be a JSON object: %w", err)
+	}
+	s := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compile(root, "#", make(map[string]bool), 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile 递归检查子Schema，只进入包含Schema的关键字，enum、const等取值中的对象不会被误判
+func (s *Schema) compile(schema map[string]interface{}, path string, refs map[string]bool, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%s: schema nesting too deep", path)
+	}
+
+	if p, ok := schema["pattern"]; ok {
+		str, ok := p.(string)
+		if !ok {
+			return fmt.Errorf("%s/pattern: must be a string", path)
+		}
+		if _, done := s.patterns[str]; !done {
+			re, err := regexp.Compile(str)
+			if err != nil {
+				return fmt.Errorf("%s/pattern: invalid regular expression %q: %v", path, str, err)
+			}
+			s.patterns[str] = re
+		}
+	}
+
+	if ref, ok := schema["$ref"].(string); ok && !refs[ref] {
+		v := &validator{root: s.root}
+		target, err := v.resolve(ref)
+		if err != nil {
+			return fmt.Errorf("%s/$ref: %v", path, err)
+		}
+		if s.refCycle(target, ref, make(map[string]bool), 0) {
+			return fmt.Errorf("%s/$ref: %q refers back to itself without descending into properties or items", path, ref)
+		}
+		// 引用的位置可能不在$defs中，同样需要检查，每个$ref只检查一次以免循环引用
+		refs[ref] = true
+		if err := s.compile(target, ref, refs, depth+1); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range []string{"items", "additionalProperties"} {
+		if sub, ok := schema[key].(map[string]interface{}); ok {
+			if err := s.compile(sub, path+"/"+key, refs, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		subs, _ := schema[key].(map[string]interface{})
+		names := make([]string, 0, len(subs))
+		for name := range subs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := subs[name].(map[string]interface{}); ok {
+				if err := s.compile(sub, path+"/"+key+"/"+name, refs, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[key].([]interface{})
+		for i, item := range subs {
+			if sub, ok := item.(map[string]interface{}); ok {
+				if err := s.compile(sub, fmt.Sprintf("%s/%s/%d", path, key, i), refs, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// refCycle 从schema出发只经过$ref和allOf/anyOf/oneOf能否回到start
+// 这样的循环校验时一直作用于同一个值，会无限展开（经过anyOf时呈指数增长），编译时直接拒绝
+func (s *Schema) refCycle(schema map[string]interface{}, start string, seen map[string]bool, depth int) bool {
+	if depth > maxDepth {
+		return false
+	}
+	// 校验时$ref存在则忽略同级的其他关键字
+	if ref, ok := schema["$ref"].(string); ok {
+		if ref == start {
+			return true
+		}
+		if seen[ref] {
+			return false
+		}
+		seen[ref] = true
+		target, err := (&validator{root: s.root}).resolve(ref)
+		if err != nil {
+			return false
+		}
+		return s.refCycle(target, start, seen, depth+1)
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[key].([]interface{})
+		for _, item := range subs {
+			if sub, ok := item.(map[string]interface{}); ok && s.refCycle(sub, start, seen, depth+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidationError 校验失败，Errors按路径列出每个问题
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Errors, "; ")
+}
+
+// Validate 校验JSON文本
+func (s *Schema) Validate(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return &ValidationError{Errors: []string{"invalid JSON: " + err.Error()}}
+	}
+
+	v := &validator{root: s.root, patterns: s.patterns, budget: &budget{}}
+	v.validate(s.root, value, "$", 0)
+	if v.budget.exceeded {
+		return &ValidationError{Errors: []string{"$: schema too complex to validate"}}
+	}
+	if len(v.errors) > 0 {
+		return &ValidationError{Errors: v.errors}
+	}
+	return nil
+}
+
+const (
+	// maxDepth 防止循环$ref导致无限递归
+	maxDepth = 64
+	// maxSteps 一次校验最多访问的(子Schema, 值)组合数，anyOf/oneOf嵌套会使访问次数成倍增长
+	maxSteps = 100000
+)
+
+// budget 一次校验（包括anyOf/oneOf的试探）共享的步数
+type budget struct {
+	steps    int
+	exceeded bool
+}
+
+type validator struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+	budget   *budget
+	errors   []string
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) validate(schema map[string]interface{}, value interface{}, path string, depth int) {
+	if depth > maxDepth {
+		v.fail(path, "schema nesting too deep")
+		return
+	}
+	if v.budget.steps++; v.budget.steps > maxSteps {
+		v.budget.exceeded = true
+		v.fail(path, "schema too complex to validate")
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		v.validate(target, value, path, depth+1)
+		return
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		v.fail(path, "expected %s, got %s", describeType(t), typeName(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the allowed enum values")
+		}
+	}
+
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		v.fail(path, "value must be %v", c)
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, val, path, depth)
+	case []interface{}:
+		v.validateArray(schema, val, path, depth)
+	case string:
+		if n, ok := number(schema["minLength"]); ok && float64(len([]rune(val))) < n {
+			v.fail(path, "string shorter than %v", n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && float64(len([]rune(val))) > n {
+			v.fail(path, "string longer than %v", n)
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			if re := v.patterns[p]; re != nil && !re.MatchString(val) {
+				v.fail(path, "string does not match pattern %q", p)
+			}
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && val < n {
+			v.fail(path, "must be >= %v", n)
+		}
+		if n, ok := number(schema["maximum"]); ok && val > n {
+			v.fail(path, "must be <= %v", n)
+		}
+		if n, ok := number(schema["exclusiveMinimum"]); ok && val <= n {
+			v.fail(path, "must be > %v", n)
+		}
+		if n, ok := number(schema["exclusiveMaximum"]); ok && val >= n {
+			v.fail(path, "must be < %v", n)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if m, ok := sub.(map[string]interface{}); ok {
+				v.validate(m, value, path, depth+1)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && v.countMatches(anyOf, value, path, depth) == 0 {
+		v.fail(path, "value does not match any schema in anyOf")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if n := v.countMatches(oneOf, value, path, depth); n != 1 {
+			v.fail(path, "value must match exactly one schema in oneOf, matched %d", n)
+		}
+	}
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, depth int) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, exists := obj[name]; !exists {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	props, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "." + k
+		if sub, ok := props[k].(map[string]interface{}); ok {
+			v.validate(sub, obj[k], childPath, depth+1)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				v.fail(path, "unexpected property %q", k)
+			}
+		case map[string]interface{}:
+			v.validate(extra, obj[k], childPath, depth+1)
+		}
+	}
+}
+
+func (v *validator) validateArray(schema map[string]interface{}, arr []interface{}, path string, depth int) {
+	if n, ok := number(schema["minItems"]); ok && float64(len(arr)) < n {
+		v.fail(path, "array has fewer than %v items", n)
+	}
+	if n, ok := number(schema["maxItems"]); ok && float64(len(arr)) > n {
+		v.fail(path, "array has more than %v items", n)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range arr {
+			v.validate(items, item, fmt.Sprintf("%s[%d]", path, i), depth+1)
+		}
+	}
+}
+
+// countMatches 统计value满足的子Schema数量，不记录子Schema的错误
+func (v *validator) countMatches(schemas []interface{}, value interface{}, path string, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		m, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if v.budget.exceeded {
+			break
+		}
+		probe := &validator{root: v.root, patterns: v.patterns, budget: v.budget}
+		probe.validate(m, value, path, depth+1)
+		if len(probe.errors) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// resolve 解析本地$ref
+func (v *validator) resolve(ref string) (map[string]interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q, only local references are allowed", ref)
+	}
+
+	var node interface{} = v.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve $ref %q", ref)
+		}
+		node = m[part]
+	}
+
+	target, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot resolve $ref %q", ref)
+	}
+	return target, nil
+}
+
+// matchesType 判断value是否满足type（字符串或字符串数组）
+func matchesType(t interface{}, value interface{}) bool {
+	switch tv := t.(type) {
+	case string:
+		return matchesSingleType(tv, value)
+	case []interface{}:
+		for _, item := range tv {
+			if s, ok := item.(string); ok && matchesSingleType(s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(t string, value interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func describeType(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			names = append(names, fmt.Sprint(item))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return "unknown"
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
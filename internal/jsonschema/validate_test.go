@@ -0,0 +1,229 @@
+package jsonschema
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{"not an object", `[]`, "schema must be a JSON object"},
+		{"invalid pattern", `{"type":"string","pattern":"[a-"}`, "#/pattern: invalid regular expression"},
+		{"non-string pattern", `{"pattern":1}`, "#/pattern: must be a string"},
+		{"nested invalid pattern", `{"properties":{"code":{"pattern":"(x"}}}`, "#/properties/code/pattern"},
+		{"invalid pattern in anyOf", `{"anyOf":[{"type":"number"},{"pattern":"*"}]}`, "#/anyOf/1/pattern"},
+		{"invalid pattern in $defs", `{"$defs":{"id":{"pattern":"\\"}}}`, "#/$defs/id/pattern"},
+		{"invalid pattern behind $ref", `{"$ref":"#/x/y","x":{"y":{"pattern":"("}}}`, "#/x/y/pattern"},
+		{"unresolvable $ref", `{"properties":{"a":{"$ref":"#/$defs/missing"}}}`, `cannot resolve $ref "#/$defs/missing"`},
+		{"remote $ref", `{"$ref":"https://example.com/schema.json"}`, "only local references are allowed"},
+		{"self $ref", `{"$defs":{"a":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`, "refers back to itself"},
+		{"$ref cycle through anyOf",
+			`{"$defs":{"a":{"anyOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}]}},"$ref":"#/$defs/a"}`, "refers back to itself"},
+		{"$ref cycle through allOf", `{"allOf":[{"type":"object"},{"$ref":"#"}]}`, "refers back to itself"},
+		{"$ref cycle via two defs",
+			`{"$defs":{"a":{"oneOf":[{"$ref":"#/$defs/b"}]},"b":{"anyOf":[{"$ref":"#/$defs/a"}]}},"$ref":"#/$defs/a"}`, "refers back to itself"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Compile() err = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateStepLimit(t *testing.T) {
+	// 没有循环但每层anyOf都有两个分支，展开后约2^25次访问，需要在步数上限内结束
+	var defs []string
+	for i := 0; i < 25; i++ {
+		next := fmt.Sprintf(`{"$ref":"#/$defs/d%d"}`, i+1)
+		defs = append(defs, fmt.Sprintf(`"d%d":{"anyOf":[%s,%s]}`, i, next, next))
+	}
+	defs = append(defs, `"d25":{"type":"string"}`)
+	schema := `{"$defs":{` + strings.Join(defs, ",") + `},"$ref":"#/$defs/d0"}`
+
+	s, err := Compile([]byte(schema))
+	if err != nil {
+		t.Fatalf("Compile() err = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Validate([]byte(`1`)) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "schema too complex") {
+			t.Fatalf("Validate() err = %v, want schema too complex", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Validate() did not stop at the step limit")
+	}
+}
+
+func TestCompileIgnoresPatternInValues(t *testing.T) {
+	// enum和const中的对象不是Schema，其中的pattern不需要是合法正则
+	schema := `{"enum":[{"pattern":"["}],"const":{"pattern":"("},"properties":{"pattern":{"type":"string"}}}`
+	if _, err := Compile([]byte(schema)); err != nil {
+		t.Fatalf("Compile() err = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		value   string
+		wantErr string // 为空表示校验通过
+	}{
+		{
+			name:   "integer",
+			schema: `{"type":"integer"}`,
+			value:  `42`,
+		},
+		{
+			name:   "integer with zero fraction",
+			schema: `{"type":"integer"}`,
+			value:  `3.0`,
+		},
+		{
+			name:    "integer rejects fraction",
+			schema:  `{"type":"integer"}`,
+			value:   `1.5`,
+			wantErr: "$: expected integer, got number",
+		},
+		{
+			name:    "integer range",
+			schema:  `{"type":"integer","minimum":1,"exclusiveMaximum":10}`,
+			value:   `10`,
+			wantErr: "$: must be < 10",
+		},
+		{
+			name:   "type list",
+			schema: `{"type":["string","null"]}`,
+			value:  `null`,
+		},
+		{
+			name:    "required and nested type",
+			schema:  `{"type":"object","properties":{"age":{"type":"integer"}},"required":["name"]}`,
+			value:   `{"age":"ten"}`,
+			wantErr: `$: missing required property "name"; $.age: expected integer, got string`,
+		},
+		{
+			name:    "additionalProperties false",
+			schema:  `{"type":"object","properties":{"a":{}},"additionalProperties":false}`,
+			value:   `{"a":1,"b":2}`,
+			wantErr: `$: unexpected property "b"`,
+		},
+		{
+			name:    "additionalProperties schema",
+			schema:  `{"type":"object","additionalProperties":{"type":"number"}}`,
+			value:   `{"a":1,"b":"x"}`,
+			wantErr: "$.b: expected number, got string",
+		},
+		{
+			name:   "additionalProperties allowed by default",
+			schema: `{"type":"object","properties":{"a":{}}}`,
+			value:  `{"a":1,"b":2}`,
+		},
+		{
+			name:   "$ref to $defs",
+			schema: `{"type":"array","items":{"$ref":"#/$defs/item"},"$defs":{"item":{"type":"object","required":["id"]}}}`,
+			value:  `[{"id":1},{"id":2}]`,
+		},
+		{
+			name:    "$ref to definitions fails",
+			schema:  `{"properties":{"tag":{"$ref":"#/definitions/tag"}},"definitions":{"tag":{"type":"string","pattern":"^[a-z]+$"}}}`,
+			value:   `{"tag":"ABC"}`,
+			wantErr: `$.tag: string does not match pattern "^[a-z]+$"`,
+		},
+		{
+			name:   "recursive $ref",
+			schema: `{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#"}}}}`,
+			value:  `{"children":[{"children":[]}]}`,
+		},
+		{
+			name:    "recursive $ref fails deep",
+			schema:  `{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#"}}}}`,
+			value:   `{"children":[{"children":[1]}]}`,
+			wantErr: "$.children[0].children[0]: expected object, got number",
+		},
+		{
+			name:   "anyOf",
+			schema: `{"anyOf":[{"type":"string"},{"type":"number"}]}`,
+			value:  `1`,
+		},
+		{
+			name:    "anyOf none",
+			schema:  `{"anyOf":[{"type":"string"},{"type":"number"}]}`,
+			value:   `true`,
+			wantErr: "$: value does not match any schema in anyOf",
+		},
+		{
+			name:   "oneOf exactly one",
+			schema: `{"oneOf":[{"type":"integer"},{"type":"string"}]}`,
+			value:  `"a"`,
+		},
+		{
+			name:    "oneOf more than one",
+			schema:  `{"oneOf":[{"type":"integer"},{"type":"number"}]}`,
+			value:   `1`,
+			wantErr: "$: value must match exactly one schema in oneOf, matched 2",
+		},
+		{
+			name:    "oneOf none",
+			schema:  `{"oneOf":[{"type":"integer"},{"type":"string"}]}`,
+			value:   `null`,
+			wantErr: "$: value must match exactly one schema in oneOf, matched 0",
+		},
+		{
+			name:    "allOf",
+			schema:  `{"allOf":[{"minLength":2},{"maxLength":3}]}`,
+			value:   `"abcd"`,
+			wantErr: "$: string longer than 3",
+		},
+		{
+			name:    "enum",
+			schema:  `{"enum":["a","b"]}`,
+			value:   `"c"`,
+			wantErr: "$: value is not one of the allowed enum values",
+		},
+		{
+			name:    "array bounds",
+			schema:  `{"type":"array","minItems":2}`,
+			value:   `[1]`,
+			wantErr: "$: array has fewer than 2 items",
+		},
+		{
+			name:    "pattern",
+			schema:  `{"type":"string","pattern":"^\\d{3}$"}`,
+			value:   `"12a"`,
+			wantErr: `$: string does not match pattern "^\\d{3}$"`,
+		},
+		{
+			name:    "invalid JSON",
+			schema:  `{}`,
+			value:   `{`,
+			wantErr: "invalid JSON",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Compile() err = %v", err)
+			}
+			err = schema.Validate([]byte(tt.value))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() err = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Validate() err = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// ContentMode 用户对话内容（消息、回复、会话记录）的日志级别
+type ContentMode int32
+
+const (
+	// ContentTruncated 截断后输出（默认）
+	ContentTruncated ContentMode = iota
+	// ContentFull 完整输出，仅用于调试
+	ContentFull
+	// ContentOff 不输出内容，只记录长度
+	ContentOff
+)
+
+var contentMode atomic.Int32
+
+// ParseContentMode 解析 LOG_CONTENT：truncated（默认）、full、off
+func ParseContentMode(s string) (ContentMode, error) {
+	switch s {
+	case "", "truncated":
+		return ContentTruncated, nil
+	case "full":
+		return ContentFull, nil
+	case "off":
+		return ContentOff, nil
+	}
+	return ContentTruncated, fmt.Errorf("未知的内容日志级别: %s", s)
+}
+
+// SetContentMode 设置内容日志级别
+func SetContentMode(mode ContentMode) {
+	contentMode.Store(int32(mode))
+}
+
+// Content 按内容日志级别处理用户内容，n为截断长度（字节，不拆分UTF-8字符）
+// 先脱敏再截断，截断后不完整的凭证也不会输出
+func Content(s string, n int) string {
+	switch ContentMode(contentMode.Load()) {
+	case ContentFull:
+		return Redact(s)
+	case ContentOff:
+		return fmt.Sprintf("[已隐藏 %d 字节]", len(s))
+	}
+	s = Redact(s)
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
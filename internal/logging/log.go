@@ -0,0 +1,189 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// Config 日志配置
+type Config struct {
+	// Format 输出格式：text（默认）、json
+	Format string
+	// Level 默认级别
+	Level slog.Level
+	// Levels 按组件覆盖级别，键为组件名（如 adp、token）
+	Levels map[string]slog.Level
+}
+
+// state 当前生效的输出和级别，Setup之前使用默认的文本输出
+type state struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	current.Store(&state{handler: slog.Default().Handler(), level: slog.LevelInfo})
+}
+
+// Setup 设置日志输出，并让标准库log输出到同一处
+func Setup(w io.Writer, cfg Config) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	current.Store(&state{handler: h, level: cfg.Level, levels: cfg.Levels})
+	slog.SetDefault(slog.New(&handler{}))
+}
+
+// ParseLevel 解析日志级别：debug、info、warn、error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("未知的日志级别: %s", s)
+	}
+	return level, nil
+}
+
+// ParseLevels 解析按组件设置的级别，格式为 adp=debug,token=warn
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("组件日志级别格式错误: %s", item)
+		}
+		level, err := ParseLevel(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.TrimSpace(name)] = level
+	}
+	return levels, nil
+}
+
+// Component 返回组件的Logger，日志带有component字段并按组件级别过滤
+// 可以在Setup之前创建（如包级变量），输出和级别以调用时的配置为准
+func Component(name string) *slog.Logger {
+	return slog.New(&handler{component: name})
+}
+
+type ctxKey struct{}
+
+// With 在ctx上附加日志字段（如request_id、session_id），使用该ctx记录的日志都会带上
+func With(ctx context.Context, args ...any) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attrs := append(attrsFrom(ctx), argsToAttrs(args)...)
+	return context.WithValue(ctx, ctxKey{}, attrs)
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	// 复制一份，避免多个子ctx共享底层数组
+	return append([]slog.Attr(nil), attrs...)
+}
+
+func argsToAttrs(args []any) []slog.Attr {
+	var r slog.Record
+	r.Add(args...)
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return attrs
+}
+
+// handler 转发到当前输出，附加组件名和ctx中的字段，并隐藏凭证
+type handler struct {
+	component string
+	// ops Logger.With和WithGroup的调用，输出时依次应用
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	s := current.Load()
+	threshold := s.level
+	if l, ok := s.levels[h.component]; ok {
+		threshold = l
+	}
+	return level >= threshold
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	out := current.Load().handler
+	if h.component != "" {
+		out = out.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	}
+	for _, op := range h.ops {
+		out = op(out)
+	}
+
+	redacted := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	for _, a := range attrsFrom(ctx) {
+		redacted.AddAttrs(redactAttr(a))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return out.Handle(ctx, redacted)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(redacted) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := append(append([]func(slog.Handler) slog.Handler(nil), h.ops...), op)
+	return &handler{component: h.component, ops: ops}
+}
+
+// redactAttr 隐藏字符串字段中的凭证
+func redactAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, len(group))
+		for i, g := range group {
+			redacted[i] = redactAttr(g)
+		}
+		return slog.Group(a.Key, redacted...)
+	}
+	return a
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader 请求ID请求头，客户端可以传入自己的ID，响应中总是返回
+const RequestIDHeader = "X-Request-Id"
+
+// validRequestID 客户端传入的请求ID只接受较短的常见字符，避免日志注入
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+var access = Component("access")
+
+// Middleware 为每个请求分配request_id并附加到请求的ctx，请求结束后输出访问日志
+// 之后的中间件可以通过With向c.Request的ctx追加字段，这些字段也会出现在访问日志中
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(With(c.Request.Context(), "request_id", id))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := access.InfoContext
+		if status >= 500 {
+			level = access.WarnContext
+		}
+		level(c.Request.Context(), "请求完成",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// AddRequestAttrs 向请求的ctx追加日志字段
+func AddRequestAttrs(c *gin.Context, args ...any) {
+	c.Request = c.Request.WithContext(With(c.Request.Context(), args...))
+}
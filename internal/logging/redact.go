@@ -0,0 +1,83 @@
+// Package logging 日志脱敏
+//
+// Writer 在输出前隐藏Token、SecretId/SecretKey、Authorization签名和API Key，
+// Content 按 LOG_CONTENT 控制用户对话内容的输出
+package logging
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// rule 脱敏规则，keep为保留的分组数（保留字段名等上下文，其余部分替换为mask）
+type rule struct {
+	re   *regexp.Regexp
+	keep int
+}
+
+var rules = []rule{
+	// JSON字段："Token":"..."、"TmpSecretKey":"..."、"secret_id":"..."，被截断的内容可能缺少结尾的引号
+	{regexp.MustCompile(`(?i)("(?:[a-z_]*token|[a-z_]*secret_?(?:id|key)|authorization)"\s*:\s*")([^"]*)("?)`), 1},
+	// 请求头和键值形式：Authorization: Bearer ...、X-TC-Token: ...、token=...
+	{regexp.MustCompile(`(?i)((?:authorization|x-tc-token|x-adp-secret-(?:id|key)|x-adp-session-token)\s*[:=]\s*(?:bearer\s+|TC3-HMAC-SHA256\s+)?)([^\s,"]+)`), 1},
+	{regexp.MustCompile(`(?i)(\btoken=)([^\s&,"]+)`), 1},
+	// TC3签名中的SecretId和签名值
+	{regexp.MustCompile(`(Credential=)([^/\s,]+)`), 1},
+	{regexp.MustCompile(`(Signature=)([0-9a-fA-F]+)`), 1},
+	// 单独出现的SecretId和网关API Key
+	{regexp.MustCompile(`()(AKID[0-9A-Za-z]{8,})`), 1},
+	{regexp.MustCompile(`()(sk-adp-[0-9a-f]{8,})`), 1},
+	{regexp.MustCompile(`()(adp-byo-[A-Za-z0-9_-]{8,})`), 1},
+}
+
+// Redact 隐藏s中的凭证，保留前4个字符以便排查
+func Redact(s string) string {
+	for _, r := range rules {
+		if !r.re.MatchString(s) {
+			continue
+		}
+		s = r.re.ReplaceAllStringFunc(s, func(m string) string {
+			groups := r.re.FindStringSubmatch(m)
+			var sb strings.Builder
+			for i := 1; i < len(groups); i++ {
+				if i == r.keep+1 {
+					sb.WriteString(mask(groups[i]))
+				} else {
+					sb.WriteString(groups[i])
+				}
+			}
+			return sb.String()
+		})
+	}
+	return s
+}
+
+func mask(s string) string {
+	if len(s) <= 8 {
+		return "****"
+	}
+	return s[:4] + "****"
+}
+
+// Writer 脱敏后写入下层Writer，用于log.SetOutput
+type Writer struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewWriter 创建脱敏Writer
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+// Write 实现io.Writer，log包每次写入一整行
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := io.WriteString(w.out, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
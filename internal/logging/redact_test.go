@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"json token", `{"Token":"abcdefghijkl","Other":"x"}`, `{"Token":"abcd****","Other":"x"}`},
+		{"json secret key", `{"TmpSecretKey": "abcdefghijkl"}`, `{"TmpSecretKey": "abcd****"}`},
+		{"json secret_id", `{"secret_id":"abcdefghijkl"}`, `{"secret_id":"abcd****"}`},
+		{"json short value", `{"token":"abc"}`, `{"token":"****"}`},
+		{"json truncated", `{"Token":"abcdefghijkl`, `{"Token":"abcd****`},
+		{"bearer header", `Authorization: Bearer abcdefghijkl`, `Authorization: Bearer abcd****`},
+		{"tc token header", `X-TC-Token: abcdefghijkl`, `X-TC-Token: abcd****`},
+		{"query token", `wss://host/?token=abcdefghijkl&x=1`, `wss://host/?token=abcd****&x=1`},
+		{"tc3 signature",
+			`TC3-HMAC-SHA256 Credential=AKIDabcdefghijkl/2019-02-25/cvm/tc3_request, Signature=0123456789abcdef`,
+			`TC3-HMAC-SHA256 Credential=AKID****/2019-02-25/cvm/tc3_request, Signature=0123****`},
+		{"bare secret id", `using AKIDabcdefghijkl`, `using AKID****`},
+		{"api key", `key sk-adp-0123456789abcdef`, `key sk-a****`},
+		{"byo key", `adp-byo-abcdefghijkl`, `adp-****`},
+		{"plain text", `hello world`, `hello world`},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("%s: Redact(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestContent(t *testing.T) {
+	t.Cleanup(func() { SetContentMode(ContentTruncated) })
+
+	SetContentMode(ContentTruncated)
+	if got := Content("hello", 10); got != "hello" {
+		t.Errorf("short content = %q", got)
+	}
+	if got := Content("hello world", 5); got != "hello..." {
+		t.Errorf("truncated content = %q", got)
+	}
+
+	// 截断位置落在多字节字符中间时退回到字符边界
+	if got := Content("你好世界", 4); got != "你..." {
+		t.Errorf("truncated UTF-8 content = %q, want %q", got, "你...")
+	}
+
+	// 先脱敏再截断：截断位置落在凭证中间时也不输出凭证
+	secret := `{"Token":"abcd` + strings.Repeat("LEAKED", 10) + `"}`
+	if got := Content(secret, 16); strings.Contains(got, "LEAKED") {
+		t.Errorf("truncated content leaks the token: %q", got)
+	}
+
+	SetContentMode(ContentFull)
+	if got := Content(secret, 16); got != `{"Token":"abcd****"}` {
+		t.Errorf("full content = %q", got)
+	}
+
+	SetContentMode(ContentOff)
+	if got := Content("hello", 10); got != "[已隐藏 5 字节]" {
+		t.Errorf("hidden content = %q", got)
+	}
+}
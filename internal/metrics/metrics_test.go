@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testRegistry 返回独立的Registry，测试中的指标不注册到Default
+func testRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func output(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d bytes, wrote %d", n, buf.Len())
+	}
+	return buf.String()
+}
+
+func TestCounterExposition(t *testing.T) {
+	r := testRegistry()
+	c := &Counter{desc: desc{name: "test_requests_total", help: "Requests.\nSecond line with \\.", kind: "counter", labels: []string{"path", "status"}}}
+	r.register(c.name, c)
+
+	c.Inc("/b", "200")
+	c.Add(2.5, "/a", "500")
+	c.Inc("/b", "200")
+	c.Inc(`quote"back\slash`+"\n", "200")
+
+	want := `# HELP test_requests_total Requests.\nSecond line with \\.
+# TYPE test_requests_total counter
+test_requests_total{path="/a",status="500"} 2.5
+test_requests_total{path="/b",status="200"} 2
+test_requests_total{path="quote\"back\\slash\n",status="200"} 1
+`
+	if got := output(t, r); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeExposition(t *testing.T) {
+	r := testRegistry()
+	g := &Gauge{desc: desc{name: "test_in_flight", help: "In flight.", kind: "gauge"}}
+	r.register(g.name, g)
+
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(-3)
+
+	want := "# HELP test_in_flight In flight.\n# TYPE test_in_flight gauge\ntest_in_flight -2\n"
+	if got := output(t, r); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+
+	g.Set(7)
+	if got := output(t, r); !strings.HasSuffix(got, "test_in_flight 7\n") {
+		t.Errorf("output after Set =\n%s", got)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	r := testRegistry()
+	h := &Histogram{desc: desc{name: "test_duration_seconds", help: "Duration.", kind: "histogram", labels: []string{"endpoint"}},
+		buckets: []float64{0.5, 1, 2.5}}
+	r.register(h.name, h)
+
+	for _, v := range []float64{0.1, 0.5, 2, 10} {
+		h.Observe(v, "chat")
+	}
+
+	// 分桶为累计计数，上限等于观测值时计入该桶，超过最大上限的只计入+Inf
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{endpoint="chat",le="0.5"} 2
+test_duration_seconds_bucket{endpoint="chat",le="1"} 2
+test_duration_seconds_bucket{endpoint="chat",le="2.5"} 3
+test_duration_seconds_bucket{endpoint="chat",le="+Inf"} 4
+test_duration_seconds_sum{endpoint="chat"} 12.6
+test_duration_seconds_count{endpoint="chat"} 4
+`
+	if got := output(t, r); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEmptyMetricWritesHeader(t *testing.T) {
+	r := testRegistry()
+	c := &Counter{desc: desc{name: "test_empty_total", help: "Empty.", kind: "counter", labels: []string{"code"}}}
+	r.register(c.name, c)
+
+	want := "# HELP test_empty_total Empty.\n# TYPE test_empty_total counter\n"
+	if got := output(t, r); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	r := testRegistry()
+	r.register("test_total", &Counter{})
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate registration did not panic")
+		}
+	}()
+	r.register("test_total", &Counter{})
+}
+
+func TestLabelCountPanics(t *testing.T) {
+	c := &Counter{desc: desc{name: "test_total", kind: "counter", labels: []string{"a", "b"}}}
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong number of label values did not panic")
+		}
+	}()
+	c.Inc("only-one")
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+	}{
+		{name: "no token configured", status: http.StatusOK},
+		{name: "missing token", token: "secret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", header: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "not bearer", token: "secret", header: "Basic secret", status: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", header: "Bearer secret", status: http.StatusOK},
+		{name: "case-insensitive scheme", token: "secret", header: "bearer secret", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		Handler(tt.token).ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status == http.StatusOK {
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+				t.Errorf("%s: Content-Type = %q", tt.name, ct)
+			}
+			if !strings.Contains(w.Body.String(), "# TYPE adp_gateway_upstream_errors_total counter") {
+				t.Errorf("%s: body does not contain the default registry", tt.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import "strings"
+
+// upstreamErrors 上游返回的错误，source为 adp（WebSocket错误事件）或 tencentcloud（云API错误码）
+var upstreamErrors = NewCounter("adp_gateway_upstream_errors_total",
+	"Errors returned by upstream services by source and error code class.", "source", "code")
+
+// errorClasses 腾讯云API公共错误码的第一段
+var errorClasses = map[string]bool{
+	"AuthFailure":               true,
+	"DryRunOperation":           true,
+	"FailedOperation":           true,
+	"InternalError":             true,
+	"InvalidAction":             true,
+	"InvalidParameter":          true,
+	"InvalidParameterValue":     true,
+	"InvalidRequest":            true,
+	"IpInBlacklist":             true,
+	"IpNotInWhitelist":          true,
+	"LimitExceeded":             true,
+	"MissingParameter":          true,
+	"NoSuchProduct":             true,
+	"NoSuchVersion":             true,
+	"OperationDenied":           true,
+	"RequestLimitExceeded":      true,
+	"RequestSizeLimitExceeded":  true,
+	"ResourceInUse":             true,
+	"ResourceInsufficient":      true,
+	"ResourceNotFound":          true,
+	"ResourceUnavailable":       true,
+	"ResponseSizeLimitExceeded": true,
+	"ServiceUnavailable":        true,
+	"UnauthorizedOperation":     true,
+	"UnknownParameter":          true,
+	"UnsupportedOperation":      true,
+	"UnsupportedProtocol":       true,
+	"UnsupportedRegion":         true,
+}
+
+// UpstreamError 记录一次上游错误，code为上游返回的原始错误码
+func UpstreamError(source, code string) {
+	upstreamErrors.Inc(source, errorClass(code))
+}
+
+// errorClass 把错误码归并为固定的取值，避免上游返回的错误码让标签数量不受控制：
+// 腾讯云错误码取第一段（AuthFailure.SignatureExpire 记为 AuthFailure），
+// 没有错误码记为 unknown，其他（包括ADP错误事件的数字错误码）记为 other
+func errorClass(code string) string {
+	if code == "" || code == "unknown" {
+		return "unknown"
+	}
+	class, _, _ := strings.Cut(code, ".")
+	if errorClasses[class] {
+		return class
+	}
+	return "other"
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import "testing"
+
+func TestErrorClass(t *testing.T) {
+	tests := map[string]string{
+		"":                             "unknown",
+		"unknown":                      "unknown",
+		"AuthFailure.SignatureExpire":  "AuthFailure",
+		"InvalidParameterValue.Foo":    "InvalidParameterValue",
+		"InternalError":                "InternalError",
+		"RequestLimitExceeded":         "RequestLimitExceeded",
+		"460011":                       "other",
+		"SomethingNew.Detail":          "other",
+		"authfailure.signatureexpired": "other",
+	}
+	for code, want := range tests {
+		if got := errorClass(code); got != want {
+			t.Errorf("errorClass(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
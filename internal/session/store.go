@@ -0,0 +1,199 @@
+// Package session 管理客户端创建的命名会话
+//
+// 每个会话对应一个ADP session_id，ADP据此保留多轮对话的上下文。
+// 会话只保存在内存中，按最后使用时间过期，并且只能由创建它的API Key访问
+package session
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session 会话
+type Session struct {
+	ID           string
+	Name         string
+	Owner        string
+	ADPSessionID string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// purgeInterval 后台清理过期会话的间隔
+const purgeInterval = time.Minute
+
+const (
+	// MaxSessionsPerOwner 每个API Key最多同时保留的会话数
+	MaxSessionsPerOwner = 1000
+	// MaxSessions 所有API Key合计最多同时保留的会话数
+	MaxSessions = 100000
+)
+
+var (
+	// ErrOwnerLimit 会话数达到单个API Key的上限
+	ErrOwnerLimit = errors.New("会话数已达到该API Key的上限")
+	// ErrLimit 会话总数达到上限
+	ErrLimit = errors.New("会话总数已达到上限")
+)
+
+// Store 内存会话存储
+type Store struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	sessions map[string]*Session
+	// owners 每个API Key的会话数
+	owners   map[string]int
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	maxSessions int
+	maxPerOwner int
+}
+
+// NewStore 创建会话存储，ttl为会话空闲多久后过期
+// 过期会话由后台定时清理，不再使用时调用Close停止
+func NewStore(ttl time.Duration) *Store {
+	return newStore(ttl, purgeInterval)
+}
+
+func newStore(ttl, interval time.Duration) *Store {
+	s := &Store{
+		ttl:         ttl,
+		sessions:    make(map[string]*Session),
+		owners:      make(map[string]int),
+		stop:        make(chan struct{}),
+		maxSessions: MaxSessions,
+		maxPerOwner: MaxSessionsPerOwner,
+	}
+	go s.purgeLoop(interval)
+	return s
+}
+
+// purgeLoop 定时清理过期会话，不依赖新会话的创建
+func (s *Store) purgeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.purge()
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close 停止后台清理
+func (s *Store) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Create 创建会话，达到数量上限时返回ErrOwnerLimit或ErrLimit
+func (s *Store) Create(owner, name string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.owners[owner] >= s.maxPerOwner || len(s.sessions) >= s.maxSessions {
+		// 先清理过期会话再判断
+		s.purge()
+	}
+	if s.owners[owner] >= s.maxPerOwner {
+		return Session{}, ErrOwnerLimit
+	}
+	if len(s.sessions) >= s.maxSessions {
+		return Session{}, ErrLimit
+	}
+
+	now := time.Now()
+	sess := &Session{
+		ID:           "sess_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Name:         name,
+		Owner:        owner,
+		ADPSessionID: uuid.New().String(),
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(s.ttl),
+	}
+	s.sessions[sess.ID] = sess
+	s.owners[owner]++
+	return *sess, nil
+}
+
+// Get 获取会话并延长有效期，会话不存在、已过期或不属于owner时返回false
+func (s *Store) Get(owner, id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.lookup(owner, id)
+	if !ok {
+		return Session{}, false
+	}
+	sess.ExpiresAt = time.Now().Add(s.ttl)
+	return *sess, true
+}
+
+// Reset 为会话分配新的ADP session_id，清空ADP侧的上下文
+func (s *Store) Reset(owner, id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.lookup(owner, id)
+	if !ok {
+		return Session{}, false
+	}
+	sess.ADPSessionID = uuid.New().String()
+	sess.ExpiresAt = time.Now().Add(s.ttl)
+	return *sess, true
+}
+
+// Delete 删除会话
+func (s *Store) Delete(owner, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(owner, id); !ok {
+		return false
+	}
+	s.remove(id)
+	return true
+}
+
+// lookup 查找有效的会话，调用方需持有锁
+func (s *Store) lookup(owner, id string) (*Session, bool) {
+	sess, ok := s.sessions[id]
+	if !ok || sess.Owner != owner {
+		return nil, false
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		s.remove(id)
+		return nil, false
+	}
+	return sess, true
+}
+
+// purge 清理过期会话，调用方需持有锁
+func (s *Store) purge() {
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if now.After(sess.ExpiresAt) {
+			s.remove(id)
+		}
+	}
+}
+
+// remove 删除会话并更新计数，调用方需持有锁
+func (s *Store) remove(id string) {
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	delete(s.sessions, id)
+	if s.owners[sess.Owner]--; s.owners[sess.Owner] <= 0 {
+		delete(s.owners, sess.Owner)
+	}
+}
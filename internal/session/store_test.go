@@ -0,0 +1,100 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreOwner(t *testing.T) {
+	s := NewStore(time.Hour)
+	defer s.Close()
+
+	sess, err := s.Create("key:a", "chat")
+	if err != nil {
+		t.Fatalf("Create() err = %v", err)
+	}
+	if _, ok := s.Get("key:a", sess.ID); !ok {
+		t.Fatal("owner cannot get its session")
+	}
+	if _, ok := s.Get("key:b", sess.ID); ok {
+		t.Error("other owner can get the session")
+	}
+	if s.Delete("key:b", sess.ID) {
+		t.Error("other owner can delete the session")
+	}
+	reset, ok := s.Reset("key:a", sess.ID)
+	if !ok || reset.ADPSessionID == sess.ADPSessionID {
+		t.Errorf("Reset = %+v, %v, want a new ADP session id", reset, ok)
+	}
+	if !s.Delete("key:a", sess.ID) {
+		t.Error("owner cannot delete its session")
+	}
+}
+
+func TestStorePurgesInBackground(t *testing.T) {
+	s := newStore(10*time.Millisecond, 5*time.Millisecond)
+	defer s.Close()
+	s.Create("", "a")
+	s.Create("", "b")
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		n := len(s.sessions)
+		s.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("expired sessions were not purged without new sessions")
+}
+
+func TestStoreOwnerLimit(t *testing.T) {
+	s := NewStore(time.Hour)
+	defer s.Close()
+	s.maxPerOwner = 2
+
+	var first Session
+	for i := 0; i < 2; i++ {
+		sess, err := s.Create("key:a", "")
+		if err != nil {
+			t.Fatalf("Create() #%d err = %v", i, err)
+		}
+		if i == 0 {
+			first = sess
+		}
+	}
+	if _, err := s.Create("key:a", ""); !errors.Is(err, ErrOwnerLimit) {
+		t.Fatalf("Create() over limit err = %v, want ErrOwnerLimit", err)
+	}
+	if _, err := s.Create("key:b", ""); err != nil {
+		t.Fatalf("other owner Create() err = %v", err)
+	}
+	// 删除后释放名额
+	s.Delete("key:a", first.ID)
+	if _, err := s.Create("key:a", ""); err != nil {
+		t.Fatalf("Create() after Delete err = %v", err)
+	}
+}
+
+func TestStoreLimit(t *testing.T) {
+	s := newStore(20*time.Millisecond, time.Hour)
+	defer s.Close()
+	s.maxSessions = 2
+
+	s.Create("key:a", "")
+	s.Create("key:b", "")
+	if _, err := s.Create("key:c", ""); !errors.Is(err, ErrLimit) {
+		t.Fatalf("Create() over limit err = %v, want ErrLimit", err)
+	}
+	// 过期会话在达到上限时先被清理
+	time.Sleep(30 * time.Millisecond)
+	if _, err := s.Create("key:c", ""); err != nil {
+		t.Fatalf("Create() after expiry err = %v", err)
+	}
+	if n := s.owners["key:a"] + s.owners["key:b"]; n != 0 {
+		t.Errorf("expired owners still counted: %d", n)
+	}
+}
@@ -0,0 +1,148 @@
+package tenant
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+)
+
+// 通过请求头传递凭证：
+//
+//	X-ADP-Bot-App-Key: <BotAppKey>
+//	X-ADP-Secret-Id:   <SecretId>   可选，与SecretKey同时提供
+//	X-ADP-Secret-Key:  <SecretKey>
+//	X-ADP-Session-Token: <Token>  可选，使用临时凭证时提供
+//	X-ADP-Bot-Biz-Id:  <BotBizId>   可选
+//
+// 或者未启用网关鉴权时，把凭证编码为API Key：
+//
+//	Authorization: Bearer adp-byo-<base64url({"bot_app_key": ..., "secret_id": ..., "secret_key": ..., "session_token": ..., "bot_biz_id": ...})>
+const (
+	headerBotAppKey = "X-Adp-Bot-App-Key"
+	headerSecretID  = "X-Adp-Secret-Id"
+	headerSecretKey = "X-Adp-Secret-Key"
+	headerToken     = "X-Adp-Session-Token"
+	headerBotBizID  = "X-Adp-Bot-Biz-Id"
+
+	// KeyPrefix 编码了凭证的API Key前缀
+	KeyPrefix = "adp-byo-"
+)
+
+// contextKey 租户客户端的gin上下文键
+const contextKey = "tenant.client"
+
+// encodedCredentials 编码在API Key中的凭证
+type encodedCredentials struct {
+	BotAppKey    string `json:"bot_app_key"`
+	SecretID     string `json:"secret_id"`
+	SecretKey    string `json:"secret_key"`
+	SessionToken string `json:"session_token"`
+	BotBizID     string `json:"bot_biz_id"`
+}
+
+// Middleware 请求携带凭证时使用对应的租户客户端，否则使用网关默认客户端
+// pool为nil表示未开启BYO，此时携带凭证的请求返回400，避免误用网关自身的应用
+func Middleware(pool *Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		creds, ok, err := parseCredentials(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorBody(err.Error(), "invalid_request_error", "invalid_credentials"))
+			return
+		}
+		if !ok {
+			c.Next()
+			return
+		}
+		if pool == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorBody("This gateway does not accept ADP credentials in requests.", "invalid_request_error", "byo_credentials_disabled"))
+			return
+		}
+
+		client, release, err := pool.Acquire(creds)
+		if err != nil {
+			logger.WarnContext(c.Request.Context(), "获取租户客户端失败", "tenant", creds.label(), "error", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, errorBody("Too many distinct ADP credentials are in use, please retry later.", "server_error", "tenant_pool_full"))
+			return
+		}
+		defer release()
+
+		c.Set(contextKey, client)
+		logging.AddRequestAttrs(c, "tenant", creds.label())
+		c.Next()
+	}
+}
+
+// FromContext 返回请求使用的租户客户端，未携带凭证时返回false
+func FromContext(c *gin.Context) (*adp.Client, bool) {
+	v, ok := c.Get(contextKey)
+	if !ok {
+		return nil, false
+	}
+	client, ok := v.(*adp.Client)
+	return client, ok
+}
+
+// parseCredentials 从请求头或编码的API Key解析凭证，请求头优先
+func parseCredentials(r *http.Request) (Credentials, bool, error) {
+	creds := Credentials{
+		BotAppKey:    strings.TrimSpace(r.Header.Get(headerBotAppKey)),
+		SecretID:     strings.TrimSpace(r.Header.Get(headerSecretID)),
+		SecretKey:    strings.TrimSpace(r.Header.Get(headerSecretKey)),
+		SessionToken: strings.TrimSpace(r.Header.Get(headerToken)),
+		BotBizID:     strings.TrimSpace(r.Header.Get(headerBotBizID)),
+	}
+	if creds == (Credentials{}) {
+		token := bearerToken(r.Header.Get("Authorization"))
+		if !strings.HasPrefix(token, KeyPrefix) {
+			return Credentials{}, false, nil
+		}
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(token[len(KeyPrefix):], "="))
+		if err != nil {
+			return Credentials{}, false, errors.New("The " + KeyPrefix + " API key is not valid base64url.")
+		}
+		var encoded encodedCredentials
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return Credentials{}, false, errors.New("The " + KeyPrefix + " API key does not contain valid JSON.")
+		}
+		creds = Credentials(encoded)
+	}
+
+	if creds.BotAppKey == "" {
+		return Credentials{}, false, errors.New("ADP credentials must include a bot app key.")
+	}
+	if (creds.SecretID == "") != (creds.SecretKey == "") {
+		return Credentials{}, false, errors.New("ADP secret id and secret key must be provided together.")
+	}
+	if creds.SessionToken != "" && creds.SecretID == "" {
+		return Credentials{}, false, errors.New("ADP session token requires a secret id and secret key.")
+	}
+	return creds, true, nil
+}
+
+// bearerToken 解析Authorization请求头
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// errorBody OpenAI格式的错误响应
+func errorBody(message, errType, code string) gin.H {
+	return gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    code,
+		},
+	}
+}
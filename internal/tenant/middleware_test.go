@@ -0,0 +1,107 @@
+package tenant
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	encode := func(s string) string {
+		return KeyPrefix + base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Credentials
+		wantOK  bool
+		wantErr string
+	}{
+		{
+			name: "no credentials",
+		},
+		{
+			name:    "gateway key",
+			headers: map[string]string{"Authorization": "Bearer sk-gateway"},
+		},
+		{
+			name:    "headers",
+			headers: map[string]string{headerBotAppKey: " app ", headerSecretID: "id", headerSecretKey: "key", headerBotBizID: "biz"},
+			want:    Credentials{BotAppKey: "app", SecretID: "id", SecretKey: "key", BotBizID: "biz"},
+			wantOK:  true,
+		},
+		{
+			name:    "encoded key",
+			headers: map[string]string{"Authorization": "Bearer " + encode(`{"bot_app_key":"app","secret_id":"id","secret_key":"key","session_token":"tok"}`)},
+			want:    Credentials{BotAppKey: "app", SecretID: "id", SecretKey: "key", SessionToken: "tok"},
+			wantOK:  true,
+		},
+		{
+			name:    "encoded key with padding",
+			headers: map[string]string{"Authorization": "bearer " + KeyPrefix + base64.URLEncoding.EncodeToString([]byte(`{"bot_app_key":"a"}`))},
+			want:    Credentials{BotAppKey: "a"},
+			wantOK:  true,
+		},
+		{
+			name: "headers take precedence",
+			headers: map[string]string{
+				headerBotAppKey: "from-header",
+				"Authorization": "Bearer " + encode(`{"bot_app_key":"from-key"}`),
+			},
+			want:   Credentials{BotAppKey: "from-header"},
+			wantOK: true,
+		},
+		{
+			name:    "malformed base64",
+			headers: map[string]string{"Authorization": "Bearer " + KeyPrefix + "!!!"},
+			wantErr: "not valid base64url",
+		},
+		{
+			name:    "malformed JSON",
+			headers: map[string]string{"Authorization": "Bearer " + encode(`{"bot_app_key":`)},
+			wantErr: "does not contain valid JSON",
+		},
+		{
+			name:    "missing bot app key",
+			headers: map[string]string{headerSecretID: "id", headerSecretKey: "key"},
+			wantErr: "must include a bot app key",
+		},
+		{
+			name:    "encoded key missing bot app key",
+			headers: map[string]string{"Authorization": "Bearer " + encode(`{}`)},
+			wantErr: "must include a bot app key",
+		},
+		{
+			name:    "secret id without key",
+			headers: map[string]string{headerBotAppKey: "app", headerSecretID: "id"},
+			wantErr: "must be provided together",
+		},
+		{
+			name:    "session token without secret",
+			headers: map[string]string{headerBotAppKey: "app", headerToken: "tok"},
+			wantErr: "requires a secret id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			got, ok, err := parseCredentials(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseCredentials() err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCredentials() err = %v", err)
+			}
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseCredentials() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
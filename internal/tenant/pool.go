@@ -0,0 +1,185 @@
+// Package tenant 支持请求携带自有的ADP凭证（BYO）
+//
+// 每组凭证对应独立的token.Service和adp.Client，WebSocket连接互不共享。
+// 客户端按凭证缓存复用，数量有上限，空闲超时后断开
+package tenant
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/adp"
+	"github.com/brinkmai/adp-openai-gateway/internal/credential"
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+var logger = logging.Component("tenant")
+
+// ErrPoolFull 租户客户端已达上限且都在使用中
+var ErrPoolFull = errors.New("租户客户端数量已达上限")
+
+// Credentials 请求携带的ADP凭证
+type Credentials struct {
+	BotAppKey string
+	// SecretID和SecretKey为空时使用网关的腾讯云凭证
+	SecretID  string
+	SecretKey string
+	// SessionToken 临时凭证的Token
+	SessionToken string
+	// BotBizID 开启文档上传和图片输入
+	BotBizID string
+}
+
+// cacheKey 凭证的摘要，避免以明文作为map键
+func (c Credentials) cacheKey() string {
+	sum := sha256.Sum256([]byte(c.BotAppKey + "\x00" + c.SecretID + "\x00" + c.SecretKey + "\x00" + c.SessionToken + "\x00" + c.BotBizID))
+	return hex.EncodeToString(sum[:])
+}
+
+// label 日志中标识租户，只保留BotAppKey的前几位
+func (c Credentials) label() string {
+	return c.BotAppKey[:min(8, len(c.BotAppKey))] + "..."
+}
+
+// entry 缓存的租户客户端
+type entry struct {
+	client   *adp.Client
+	label    string
+	inUse    int
+	lastUsed time.Time
+}
+
+// Pool 租户客户端缓存
+type Pool struct {
+	credentials tencentcloud.CredentialProvider
+	size        int
+	idleTTL     time.Duration
+	setup       func(client *adp.Client, creds Credentials)
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// NewPool 创建租户客户端缓存
+// credentials为网关自身的腾讯云凭证，size为最多缓存的客户端数，
+// idleTTL为空闲多久后断开，setup用于配置新建的客户端（如上传）
+func NewPool(credentials tencentcloud.CredentialProvider, size int, idleTTL time.Duration, setup func(client *adp.Client, creds Credentials)) *Pool {
+	return &Pool{
+		credentials: credentials,
+		size:        size,
+		idleTTL:     idleTTL,
+		setup:       setup,
+		entries:     make(map[string]*entry),
+	}
+}
+
+// Acquire 获取凭证对应的客户端，使用完毕后必须调用release
+func (p *Pool) Acquire(creds Credentials) (*adp.Client, func(), error) {
+	e, removed, err := p.acquire(creds)
+	// Disconnect可能等待进行中的连接，不在持有锁时调用
+	disconnect(removed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			e.inUse--
+			e.lastUsed = time.Now()
+		})
+	}
+	return e.client, release, nil
+}
+
+// acquire 查找或创建客户端并标记为使用中，返回需要断开的客户端
+func (p *Pool) acquire(creds Credentials) (*entry, []*entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	removed := p.purge()
+
+	key := creds.cacheKey()
+	e, ok := p.entries[key]
+	if !ok {
+		if len(p.entries) >= p.size {
+			oldest := p.evict()
+			if oldest == nil {
+				return nil, removed, ErrPoolFull
+			}
+			removed = append(removed, oldest)
+		}
+		e = &entry{client: p.newClient(creds), label: creds.label()}
+		p.entries[key] = e
+		logger.Info("创建租户客户端", "tenant", e.label, "clients", len(p.entries))
+	}
+	e.inUse++
+	e.lastUsed = time.Now()
+	return e, removed, nil
+}
+
+// newClient 创建租户客户端，未提供腾讯云凭证时使用网关的
+func (p *Pool) newClient(creds Credentials) *adp.Client {
+	provider := p.credentials
+	if creds.SecretID != "" {
+		provider = credential.NewStatic(creds.SecretID, creds.SecretKey, creds.SessionToken)
+	}
+	client := adp.NewClient(provider, creds.BotAppKey)
+	if p.setup != nil {
+		p.setup(client, creds)
+	}
+	return client
+}
+
+// evict 移除最久未使用的空闲客户端并返回，没有空闲客户端时返回nil，调用方需持有锁
+func (p *Pool) evict() *entry {
+	var oldestKey string
+	var oldest *entry
+	for key, e := range p.entries {
+		if e.inUse == 0 && (oldest == nil || e.lastUsed.Before(oldest.lastUsed)) {
+			oldestKey, oldest = key, e
+		}
+	}
+	if oldest != nil {
+		delete(p.entries, oldestKey)
+	}
+	return oldest
+}
+
+// purge 移除并返回空闲超时的客户端，调用方需持有锁
+func (p *Pool) purge() []*entry {
+	var removed []*entry
+	now := time.Now()
+	for key, e := range p.entries {
+		if e.inUse == 0 && now.Sub(e.lastUsed) > p.idleTTL {
+			delete(p.entries, key)
+			removed = append(removed, e)
+		}
+	}
+	return removed
+}
+
+// Close 断开所有客户端
+func (p *Pool) Close() {
+	p.mu.Lock()
+	removed := make([]*entry, 0, len(p.entries))
+	for key, e := range p.entries {
+		delete(p.entries, key)
+		removed = append(removed, e)
+	}
+	p.mu.Unlock()
+	disconnect(removed)
+}
+
+// disconnect 断开已从缓存移除的客户端，调用方不能持有锁
+func disconnect(entries []*entry) {
+	for _, e := range entries {
+		e.client.Disconnect()
+		logger.Info("断开租户客户端", "tenant", e.label)
+	}
+}
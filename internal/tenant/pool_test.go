@@ -0,0 +1,96 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPoolReusesClientPerCredentials(t *testing.T) {
+	p := NewPool(nil, 4, time.Hour, nil)
+	defer p.Close()
+
+	a1, release, err := p.Acquire(Credentials{BotAppKey: "app-a"})
+	if err != nil {
+		t.Fatalf("Acquire() err = %v", err)
+	}
+	release()
+	a2, release, _ := p.Acquire(Credentials{BotAppKey: "app-a"})
+	release()
+	if a1 != a2 {
+		t.Error("same credentials got different clients")
+	}
+
+	for _, creds := range []Credentials{
+		{BotAppKey: "app-b"},
+		{BotAppKey: "app-a", SecretID: "id", SecretKey: "key"},
+		{BotAppKey: "app-a", BotBizID: "biz"},
+	} {
+		c, release, err := p.Acquire(creds)
+		if err != nil {
+			t.Fatalf("Acquire(%+v) err = %v", creds, err)
+		}
+		release()
+		if c == a1 {
+			t.Errorf("Acquire(%+v) shares the client of app-a", creds)
+		}
+	}
+}
+
+func TestPoolEvictsLeastRecentlyUsed(t *testing.T) {
+	p := NewPool(nil, 2, time.Hour, nil)
+	defer p.Close()
+
+	a, releaseA, _ := p.Acquire(Credentials{BotAppKey: "app-a"})
+	releaseA()
+	_, releaseB, _ := p.Acquire(Credentials{BotAppKey: "app-b"})
+	// b仍在使用中，只能淘汰a
+	_, releaseC, err := p.Acquire(Credentials{BotAppKey: "app-c"})
+	if err != nil {
+		t.Fatalf("Acquire() err = %v", err)
+	}
+	if _, ok := p.entries[Credentials{BotAppKey: "app-a"}.cacheKey()]; ok {
+		t.Error("idle client app-a was not evicted")
+	}
+
+	// 全部在使用中时拒绝新凭证
+	if _, _, err := p.Acquire(Credentials{BotAppKey: "app-d"}); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("Acquire() with all clients in use err = %v, want ErrPoolFull", err)
+	}
+	releaseB()
+	releaseC()
+
+	a2, release, err := p.Acquire(Credentials{BotAppKey: "app-a"})
+	if err != nil {
+		t.Fatalf("Acquire() after release err = %v", err)
+	}
+	release()
+	if a2 == a {
+		t.Error("evicted client was reused")
+	}
+	if len(p.entries) != 2 {
+		t.Errorf("pool has %d clients, want 2", len(p.entries))
+	}
+}
+
+func TestPoolPurgesIdleClients(t *testing.T) {
+	p := NewPool(nil, 4, 20*time.Millisecond, nil)
+	defer p.Close()
+
+	_, releaseA, _ := p.Acquire(Credentials{BotAppKey: "app-a"})
+	releaseA()
+	_, releaseB, _ := p.Acquire(Credentials{BotAppKey: "app-b"})
+	defer releaseB()
+
+	time.Sleep(30 * time.Millisecond)
+	_, releaseC, _ := p.Acquire(Credentials{BotAppKey: "app-c"})
+	defer releaseC()
+
+	if _, ok := p.entries[Credentials{BotAppKey: "app-a"}.cacheKey()]; ok {
+		t.Error("idle client app-a was not purged")
+	}
+	// 使用中的客户端即使超时也不断开
+	if _, ok := p.entries[Credentials{BotAppKey: "app-b"}.cacheKey()]; !ok {
+		t.Error("in-use client app-b was purged")
+	}
+}
@@ -0,0 +1,181 @@
+// Package tencentcloud 调用腾讯云API 3.0
+//
+// 请求使用TC3-HMAC-SHA256签名，可用于任意产品、接口版本和地域，
+// 凭证由CredentialProvider在每次调用前提供，临时凭证会附带X-TC-Token
+package tencentcloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+	"github.com/brinkmai/adp-openai-gateway/internal/metrics"
+)
+
+var logger = logging.Component("tencentcloud")
+
+// DefaultRegion 默认地域
+const DefaultRegion = "ap-guangzhou"
+
+// Credential 腾讯云凭证
+type Credential struct {
+	SecretID  string
+	SecretKey string
+	// Token 临时凭证的会话Token，固定凭证为空
+	Token string
+	// ExpiresAt 过期时间，零值表示不过期
+	ExpiresAt time.Time
+}
+
+// CredentialProvider 凭证来源，每次签名前调用，实现需支持并发
+type CredentialProvider interface {
+	Credential() (Credential, error)
+}
+
+// Error 接口返回的错误
+type Error struct {
+	Action    string
+	Code      string
+	Message   string
+	RequestID string
+}
+
+// Error 实现error
+func (e *Error) Error() string {
+	return fmt.Sprintf("API错误: %s - %s (RequestId: %s)", e.Code, e.Message, e.RequestID)
+}
+
+// Client 腾讯云API客户端
+type Client struct {
+	// Region 地域，为空时不发送X-TC-Region（部分接口不需要地域）
+	Region string
+	// Endpoint 覆盖请求地址（如本地测试），为空时使用 https://{Host}/
+	Endpoint string
+	// LogResponse 以debug级别输出响应（按内容日志级别截断或隐藏），凭证字段由日志脱敏隐藏
+	LogResponse bool
+
+	service     string
+	host        string
+	version     string
+	credentials CredentialProvider
+	httpClient  *http.Client
+}
+
+// NewClient 创建客户端，service为产品名（如 lke、sts），version为接口版本（如 2023-11-30）
+func NewClient(service, version string, credentials CredentialProvider) *Client {
+	return &Client{
+		Region:      DefaultRegion,
+		service:     service,
+		host:        service + ".tencentcloudapi.com",
+		version:     version,
+		credentials: credentials,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Call 调用接口，out为响应中Response字段对应的结构，可以为nil
+// 返回本次请求的RequestId；接口返回错误时error为*Error
+func (c *Client) Call(action string, payload any, out any) (string, error) {
+	return c.CallContext(context.Background(), action, payload, out)
+}
+
+// CallContext 同Call，ctx用于取消请求和日志字段
+func (c *Client) CallContext(ctx context.Context, action string, payload any, out any) (string, error) {
+	start := time.Now()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + c.host + "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("创建请求失败: %w", err)
+	}
+
+	cred, err := c.credentials.Credential()
+	if err != nil {
+		return "", fmt.Errorf("获取腾讯云凭证失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-TC-Action", action)
+	req.Header.Set("X-TC-Version", c.version)
+	req.Header.Set("X-TC-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	if c.Region != "" {
+		req.Header.Set("X-TC-Region", c.Region)
+	}
+	req.Host = c.host
+	Sign(req, c.service, body, cred)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("读取响应失败: %w", err)
+	}
+	if c.LogResponse {
+		logger.DebugContext(ctx, "响应", "action", action, "body", logging.Content(string(respBody), 1000))
+	}
+
+	var result struct {
+		Response json.RawMessage `json:"Response"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil || result.Response == nil {
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("请求失败: HTTP %d", resp.StatusCode)
+		}
+		return "", fmt.Errorf("解析响应失败: %s", truncate(logging.Redact(string(respBody)), 200))
+	}
+
+	var meta struct {
+		RequestID string `json:"RequestId"`
+		Error     *struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := json.Unmarshal(result.Response, &meta); err != nil {
+		return "", fmt.Errorf("解析响应失败: %w", err)
+	}
+	logger.DebugContext(ctx, "调用接口", "action", c.service+"."+action, "status", resp.StatusCode,
+		"tc_request_id", meta.RequestID, "duration_ms", time.Since(start).Milliseconds())
+	if meta.Error != nil {
+		logger.WarnContext(ctx, "接口返回错误", "action", c.service+"."+action,
+			"code", meta.Error.Code, "tc_request_id", meta.RequestID)
+		metrics.UpstreamError("tencentcloud", meta.Error.Code)
+		return meta.RequestID, &Error{
+			Action:    action,
+			Code:      meta.Error.Code,
+			Message:   meta.Error.Message,
+			RequestID: meta.RequestID,
+		}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(result.Response, out); err != nil {
+			return meta.RequestID, fmt.Errorf("解析响应失败: %w", err)
+		}
+	}
+	return meta.RequestID, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,77 @@
+package tencentcloud
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+)
+
+type staticCredential Credential
+
+func (s staticCredential) Credential() (Credential, error) { return Credential(s), nil }
+
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c := NewClient("lke", "2023-11-30", staticCredential{SecretID: "AKIDtest", SecretKey: "secret"})
+	c.Endpoint = srv.URL
+	return c
+}
+
+func TestCall(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-TC-Action") != "GetWsToken" || r.Header.Get("X-TC-Version") != "2023-11-30" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "TC3-HMAC-SHA256 Credential=AKIDtest/") {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		fmt.Fprint(w, `{"Response":{"Token":"tok","RequestId":"req-1"}}`)
+	})
+
+	var out struct{ Token string }
+	id, err := c.Call("GetWsToken", map[string]any{}, &out)
+	if err != nil || id != "req-1" || out.Token != "tok" {
+		t.Fatalf("Call = %q, %+v, %v", id, out, err)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Response":{"Error":{"Code":"InvalidParameter","Message":"bad"},"RequestId":"req-2"}}`)
+	})
+
+	_, err := c.Call("GetWsToken", map[string]any{}, nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != "InvalidParameter" || apiErr.RequestID != "req-2" {
+		t.Fatalf("err = %#v, want *Error InvalidParameter", err)
+	}
+}
+
+func TestCallLogsRedactedResponse(t *testing.T) {
+	var buf bytes.Buffer
+	logging.Setup(&buf, logging.Config{Level: slog.LevelDebug})
+	t.Cleanup(func() { logging.Setup(&bytes.Buffer{}, logging.Config{}) })
+
+	// Token跨过1000字节的截断位置
+	secret := "LEAKED" + strings.Repeat("y", 100)
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Response":{"Padding":%q,"Token":%q,"RequestId":"req-3"}}`, strings.Repeat("p", 950), secret)
+	})
+	c.LogResponse = true
+
+	if _, err := c.Call("GetWsToken", map[string]any{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "LEAKED") {
+		t.Errorf("response log leaks the token:\n%s", buf.String())
+	}
+}
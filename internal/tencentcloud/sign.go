@@ -0,0 +1,64 @@
+package tencentcloud
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// algorithm 签名算法
+const algorithm = "TC3-HMAC-SHA256"
+
+// signedHeaders 参与签名的请求头
+const signedHeaders = "content-type;host;x-tc-action"
+
+// Sign 计算TC3-HMAC-SHA256签名并设置Authorization
+// 请求需已设置Content-Type、X-TC-Action和X-TC-Timestamp，req.Host为签名使用的域名，payload为请求体
+func Sign(req *http.Request, service string, payload []byte, cred Credential) {
+	timestamp := req.Header.Get("X-TC-Timestamp")
+	ts, _ := strconv.ParseInt(timestamp, 10, 64)
+	date := time.Unix(ts, 0).UTC().Format("2006-01-02")
+
+	credentialScope := fmt.Sprintf("%s/%s/tc3_request", date, service)
+	stringToSign := fmt.Sprintf("%s\n%s\n%s\n%s",
+		algorithm, timestamp, credentialScope, sha256Hex([]byte(canonicalRequest(req, payload))))
+	signature := signature(cred.SecretKey, date, service, stringToSign)
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, cred.SecretID, credentialScope, signedHeaders, signature))
+	if cred.Token != "" {
+		req.Header.Set("X-TC-Token", cred.Token)
+	}
+}
+
+// canonicalRequest 规范请求串，只支持POST和签名content-type、host、x-tc-action
+func canonicalRequest(req *http.Request, payload []byte) string {
+	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-tc-action:%s\n",
+		req.Header.Get("Content-Type"), req.Host, strings.ToLower(req.Header.Get("X-TC-Action")))
+	return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s",
+		req.Method, "/", "", canonicalHeaders, signedHeaders, sha256Hex(payload))
+}
+
+// signature 派生签名密钥并计算签名
+func signature(secretKey, date, service, stringToSign string) string {
+	kDate := hmacSHA256([]byte("TC3"+secretKey), []byte(date))
+	kService := hmacSHA256(kDate, []byte(service))
+	kSigning := hmacSHA256(kService, []byte("tc3_request"))
+	return hex.EncodeToString(hmacSHA256(kSigning, []byte(stringToSign)))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,68 @@
+package tencentcloud
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// 腾讯云API签名v3文档中的示例：https://cloud.tencent.com/document/api/213/30654
+const (
+	exampleSecretID  = "AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******"
+	exampleSecretKey = "Gu5t9xGARNpq86cd98joQYCN3*******"
+	examplePayload   = `{"Limit": 1, "Filters": [{"Values": ["\u672a\u547d\u540d"], "Name": "instance-name"}]}`
+)
+
+func exampleRequest(t *testing.T) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", "https://cvm.tencentcloudapi.com/", strings.NewReader(examplePayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-TC-Action", "DescribeInstances")
+	req.Header.Set("X-TC-Timestamp", "1551113065")
+	req.Header.Set("X-TC-Version", "2017-03-12")
+	req.Header.Set("X-TC-Region", "ap-guangzhou")
+	return req
+}
+
+func TestCanonicalRequest(t *testing.T) {
+	want := "POST\n/\n\n" +
+		"content-type:application/json; charset=utf-8\nhost:cvm.tencentcloudapi.com\nx-tc-action:describeinstances\n\n" +
+		"content-type;host;x-tc-action\n" +
+		"35e9c5b0e3ae67532d3c9f17ead6c90222632e5b1ff7f6e89887f1398934f064"
+	got := canonicalRequest(exampleRequest(t), []byte(examplePayload))
+	if got != want {
+		t.Errorf("canonicalRequest =\n%s\nwant\n%s", got, want)
+	}
+	// 文档中的HashedCanonicalRequest
+	if h := sha256Hex([]byte(got)); h != "7019a55be8395899b900fb5564e4200d984910f34794a27cb3fb7d10ff6a1e84" {
+		t.Errorf("hashed canonical request = %s", h)
+	}
+}
+
+func TestSign(t *testing.T) {
+	req := exampleRequest(t)
+	Sign(req, "cvm", []byte(examplePayload), Credential{SecretID: exampleSecretID, SecretKey: exampleSecretKey})
+
+	// 文档中的SecretKey已打码，公布的签名由未打码的密钥算出，无法复现；
+	// 这里的签名用打码后的密钥以Python hmac独立计算
+	want := "TC3-HMAC-SHA256 Credential=AKIDz8krbsJ5yKBZQpn74WFkmLPx3*******/2019-02-25/cvm/tc3_request, " +
+		"SignedHeaders=content-type;host;x-tc-action, " +
+		"Signature=be4f67d323c78ab9acb7395e43c0dbcf822a9cfac32fea2449a7bc7726b770a3"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+	if req.Header.Get("X-TC-Token") != "" {
+		t.Error("X-TC-Token set for a permanent credential")
+	}
+}
+
+func TestSignToken(t *testing.T) {
+	req := exampleRequest(t)
+	Sign(req, "cvm", []byte(examplePayload), Credential{SecretID: exampleSecretID, SecretKey: exampleSecretKey, Token: "session-token"})
+	if got := req.Header.Get("X-TC-Token"); got != "session-token" {
+		t.Errorf("X-TC-Token = %q, want session-token", got)
+	}
+}
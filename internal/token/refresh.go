@@ -0,0 +1,164 @@
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/metrics"
+)
+
+const (
+	// defaultLifetime 无法得知过期时间时假定的Token有效期
+	defaultLifetime = 4*time.Minute + 30*time.Second
+	// refreshAhead 在过期前多久开始后台刷新
+	refreshAhead = time.Minute
+	// expiryMargin 剩余有效期不足时不再使用缓存Token
+	expiryMargin = 10 * time.Second
+
+	// 刷新失败后的重试间隔
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+var refreshes = metrics.NewCounter("adp_gateway_token_refreshes_total",
+	"WebSocket token fetches by trigger (sync or background) and result (success or failure).", "trigger", "result")
+
+// countRefresh 记录一次Token获取
+func countRefresh(trigger string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	refreshes.Inc(trigger, result)
+}
+
+// tokenExpiry 计算Token过期时间：优先使用接口返回的时间，其次是JWT中的exp，最后按默认有效期
+func tokenExpiry(token string, expiredTime int64, now time.Time) time.Time {
+	if expiredTime > 0 {
+		return time.Unix(expiredTime, 0)
+	}
+	if exp, ok := jwtExpiry(token); ok {
+		return exp
+	}
+	return now.Add(defaultLifetime)
+}
+
+// jwtExpiry 解析JWT格式Token中的exp（不校验签名）
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(data, &claims) != nil || claims.Exp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// refreshDelay 距离下一次后台刷新的时间，有效期较短时在剩余一半时刷新
+func refreshDelay(now, expireTime time.Time) time.Duration {
+	lifetime := expireTime.Sub(now)
+	ahead := refreshAhead
+	if lifetime < 2*refreshAhead {
+		ahead = lifetime / 2
+	}
+	if delay := lifetime - ahead; delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// backoffDelay 第attempt次（从0开始）刷新失败后的重试间隔
+func backoffDelay(attempt int) time.Duration {
+	backoff := minBackoff << min(attempt, 5)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// scheduleRefresh 安排后台刷新，替换已安排的刷新
+func (s *Service) scheduleRefresh(delay time.Duration) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	if s.stopped {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.idle = false
+	s.timer = time.AfterFunc(delay, s.backgroundRefresh)
+}
+
+// markUsed 记录Token被使用，后台刷新因空闲停止时重新安排
+func (s *Service) markUsed(expireTime time.Time) {
+	s.refreshMu.Lock()
+	s.used = true
+	idle := s.idle
+	s.refreshMu.Unlock()
+	if idle {
+		logger.Debug("Token重新被使用，恢复后台刷新")
+		s.scheduleRefresh(refreshDelay(s.now(), expireTime))
+	}
+}
+
+// backgroundRefresh 后台获取下一个Token，失败时按指数退避重试，期间继续使用仍然有效的Token
+// 上次刷新后Token没有被使用过时停止刷新，下次使用时再恢复，避免空闲的网关一直请求Token
+func (s *Service) backgroundRefresh() {
+	s.refreshMu.Lock()
+	if !s.used {
+		s.idle = true
+		s.timer = nil
+		s.refreshMu.Unlock()
+		logger.Info("Token在有效期内未被使用，停止后台刷新")
+		return
+	}
+	s.used = false
+	s.refreshMu.Unlock()
+
+	s.fetchMu.Lock()
+	err := s.fetch()
+	s.fetchMu.Unlock()
+	countRefresh("background", err)
+
+	s.refreshMu.Lock()
+	if err == nil {
+		s.attempt = 0
+		s.refreshMu.Unlock()
+		return
+	}
+	// 重试不受空闲判断影响
+	s.used = true
+	backoff := backoffDelay(s.attempt)
+	s.attempt++
+	attempt := s.attempt
+	s.refreshMu.Unlock()
+
+	if _, _, ok := s.cached(); ok {
+		logger.Warn("后台刷新Token失败，继续使用当前Token", "attempt", attempt, "retry_in", backoff, "error", err)
+	} else {
+		logger.Error("后台刷新Token失败，当前Token已过期", "attempt", attempt, "retry_in", backoff, "error", err)
+	}
+	s.scheduleRefresh(backoff)
+}
+
+// Stop 停止后台刷新，客户端断开后调用
+func (s *Service) Stop() {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	s.stopped = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
@@ -0,0 +1,137 @@
+package token
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
+)
+
+func TestRefreshDelay(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{time.Hour, time.Hour - refreshAhead},
+		{2 * refreshAhead, refreshAhead},
+		// 有效期较短时在剩余一半时刷新
+		{time.Minute, 30 * time.Second},
+		{10 * time.Second, 5 * time.Second},
+		{0, 0},
+		{-time.Minute, 0},
+	}
+	for _, tt := range tests {
+		if got := refreshDelay(now, now.Add(tt.lifetime)); got != tt.want {
+			t.Errorf("refreshDelay(%s) = %s, want %s", tt.lifetime, got, tt.want)
+		}
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	jwt := func(claims string) string {
+		return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	exp := now.Add(10 * time.Minute)
+
+	tests := []struct {
+		name        string
+		token       string
+		expiredTime int64
+		want        time.Time
+	}{
+		{"expired time", jwt(fmt.Sprintf(`{"exp":%d}`, exp.Unix())), now.Add(time.Hour).Unix(), now.Add(time.Hour)},
+		{"jwt exp", jwt(fmt.Sprintf(`{"exp":%d}`, exp.Unix())), 0, exp},
+		{"jwt padded", "h." + base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d} `, exp.Unix()))) + ".sig", 0, exp},
+		{"jwt without exp", jwt(`{"sub":"x"}`), 0, now.Add(defaultLifetime)},
+		{"jwt bad payload", "a.!!!.c", 0, now.Add(defaultLifetime)},
+		{"opaque token", "opaque-token", 0, now.Add(defaultLifetime)},
+	}
+	for _, tt := range tests {
+		if got := tokenExpiry(tt.token, tt.expiredTime, now); !got.Equal(tt.want) {
+			t.Errorf("%s: tokenExpiry = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		maxBackoff, maxBackoff, maxBackoff,
+	}
+	for attempt, w := range want {
+		if got := backoffDelay(attempt); got != w {
+			t.Errorf("backoffDelay(%d) = %s, want %s", attempt, got, w)
+		}
+	}
+	if got := backoffDelay(100); got != maxBackoff {
+		t.Errorf("backoffDelay(100) = %s, want %s", got, maxBackoff)
+	}
+}
+
+type staticCredential tencentcloud.Credential
+
+func (s staticCredential) Credential() (tencentcloud.Credential, error) {
+	return tencentcloud.Credential(s), nil
+}
+
+// testService 返回使用本地GetWsToken接口的Token服务，Token一小时后过期，fetches为请求次数
+func testService(t *testing.T) (*Service, *int32) {
+	t.Helper()
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&fetches, 1)
+		fmt.Fprintf(w, `{"Response":{"Token":"token-%d","ExpiredTime":%d,"RequestId":"r"}}`, n, time.Now().Add(time.Hour).Unix())
+	}))
+	t.Cleanup(srv.Close)
+
+	s := NewService(staticCredential{SecretID: "id", SecretKey: "key"}, "bot-app-key")
+	s.api.Endpoint = srv.URL
+	t.Cleanup(s.Stop)
+	return s, &fetches
+}
+
+func TestIdleStopsRefresh(t *testing.T) {
+	s, fetches := testService(t)
+
+	token, _, err := s.GetWsToken()
+	if err != nil || token != "token-1" {
+		t.Fatalf("GetWsToken = %q, %v", token, err)
+	}
+
+	// Token被使用过，后台刷新获取新Token
+	s.backgroundRefresh()
+	if *fetches != 2 {
+		t.Fatalf("fetches after refresh = %d, want 2", *fetches)
+	}
+
+	// 刷新后没有使用，下一次刷新停止
+	s.backgroundRefresh()
+	if *fetches != 2 {
+		t.Fatalf("idle refresh fetched a token: fetches = %d", *fetches)
+	}
+	s.refreshMu.Lock()
+	idle, timer := s.idle, s.timer
+	s.refreshMu.Unlock()
+	if !idle || timer != nil {
+		t.Fatalf("idle = %v, timer = %v; want refresh stopped", idle, timer)
+	}
+
+	// 再次使用时直接返回缓存Token并恢复后台刷新
+	token, _, err = s.GetWsToken()
+	if err != nil || token != "token-2" {
+		t.Fatalf("GetWsToken after idle = %q, %v", token, err)
+	}
+	s.refreshMu.Lock()
+	idle, timer = s.idle, s.timer
+	s.refreshMu.Unlock()
+	if idle || timer == nil {
+		t.Fatalf("idle = %v, timer = %v; want refresh resumed", idle, timer)
+	}
+}
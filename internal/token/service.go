@@ -1,183 +1,131 @@
 package token
 
 import (
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"strings"
+	"context"
 	"sync"
 	"time"
+
+	"github.com/brinkmai/adp-openai-gateway/internal/logging"
+	"github.com/brinkmai/adp-openai-gateway/internal/tencentcloud"
 )
 
+var logger = logging.Component("token")
+
 // Service 腾讯云ADP Token服务
+// Token在过期前由后台刷新，有效期内的调用不会被阻塞；一个有效期内没有使用时停止刷新
 type Service struct {
-	secretId     string
-	secretKey    string
+	api          *tencentcloud.Client
 	botAppKey    string
 	cachedToken  string
 	expireTime   time.Time
 	mu           sync.RWMutex
+
+	// fetchMu 保证同一时间只有一个获取Token的请求
+	fetchMu   sync.Mutex
+	refreshMu sync.Mutex
+	timer     *time.Timer
+	attempt   int
+	stopped   bool
+	// used 上次后台刷新后Token是否被使用过，idle 因未被使用停止了后台刷新
+	used bool
+	idle bool
+
+	now func() time.Time
 }
 
-// NewService 创建Token服务
-func NewService(secretId, secretKey, botAppKey string) *Service {
+// NewService 创建Token服务，每次调用API前从credentials获取凭证
+func NewService(credentials tencentcloud.CredentialProvider, botAppKey string) *Service {
+	api := tencentcloud.NewClient("lke", "2023-11-30", credentials)
+	api.LogResponse = true
 	return &Service{
-		secretId:  secretId,
-		secretKey: secretKey,
+		api:       api,
 		botAppKey: botAppKey,
+		now:       time.Now,
 	}
 }
 
-// GetWsToken 获取WebSocket Token
-func (s *Service) GetWsToken() (string, error) {
-	s.mu.RLock()
-	if s.cachedToken != "" && time.Now().Add(30*time.Second).Before(s.expireTime) {
-		log.Println("[TokenService] 使用缓存Token")
-		token := s.cachedToken
-		s.mu.RUnlock()
-		return token, nil
-	}
-	s.mu.RUnlock()
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// BotAppKey 返回应用Key
+func (s *Service) BotAppKey() string {
+	return s.botAppKey
+}
 
-	// 再次检查（防止并发）
-	if s.cachedToken != "" && time.Now().Add(30*time.Second).Before(s.expireTime) {
-		return s.cachedToken, nil
-	}
+// SetEndpoint 覆盖云API地址（如本地测试）
+func (s *Service) SetEndpoint(endpoint string) {
+	s.api.Endpoint = endpoint
+}
 
-	action := "GetWsToken"
-	payload := map[string]interface{}{
-		"Type":      5, // API访客模式
-		"BotAppKey": s.botAppKey,
+// GetWsToken 获取WebSocket Token及其过期时间
+// 缓存Token有效时直接返回，否则同步获取（并发调用只发起一次请求）
+func (s *Service) GetWsToken() (string, time.Time, error) {
+	if token, expireTime, ok := s.cached(); ok {
+		logger.Debug("使用缓存Token")
+		s.markUsed(expireTime)
+		return token, expireTime, nil
 	}
 
-	log.Println("[TokenService] ========== 请求Token ==========")
-	log.Printf("[TokenService] Action: %s", action)
-	log.Printf("[TokenService] BotAppKey: %s...", s.botAppKey[:min(8, len(s.botAppKey))])
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", fmt.Errorf("序列化请求失败: %w", err)
+	// 再次检查（等待期间其他请求可能已经获取）
+	if token, expireTime, ok := s.cached(); ok {
+		s.markUsed(expireTime)
+		return token, expireTime, nil
 	}
-
-	req, err := http.NewRequest("POST", "https://lke.tencentcloudapi.com/", bytes.NewReader(body))
+	err := s.fetch()
+	countRefresh("sync", err)
 	if err != nil {
-		return "", fmt.Errorf("创建请求失败: %w", err)
+		return "", time.Time{}, err
 	}
+	token, expireTime, _ := s.cached()
+	s.markUsed(expireTime)
+	return token, expireTime, nil
+}
 
-	// 构建签名
-	timestamp := time.Now().Unix()
-	s.buildHeaders(req, action, body, timestamp)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("请求失败: %w", err)
+// cached 返回仍然有效的缓存Token
+func (s *Service) cached() (string, time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cachedToken != "" && s.now().Add(expiryMargin).Before(s.expireTime) {
+		return s.cachedToken, s.expireTime, true
 	}
-	defer resp.Body.Close()
+	return "", time.Time{}, false
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("读取响应失败: %w", err)
+// fetch 请求新Token并安排下一次后台刷新，调用方需持有fetchMu
+func (s *Service) fetch() error {
+	payload := map[string]interface{}{
+		"Type":      5, // API访客模式
+		"BotAppKey": s.botAppKey,
 	}
 
-	log.Printf("[TokenService] HTTP状态码: %d", resp.StatusCode)
-	log.Printf("[TokenService] 响应: %s", string(respBody))
+	logger.Info("请求Token", "bot_app_key", s.botAppKey[:min(8, len(s.botAppKey))]+"...")
 
 	var result struct {
-		Response struct {
-			Token string `json:"Token"`
-			Error *struct {
-				Code    string `json:"Code"`
-				Message string `json:"Message"`
-			} `json:"Error"`
-		} `json:"Response"`
-	}
-
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("解析响应失败: %w", err)
+		Token string `json:"Token"`
+		// ExpiredTime 过期时间（Unix秒），未返回时按Token内容或默认有效期计算
+		ExpiredTime int64 `json:"ExpiredTime"`
 	}
-
-	if result.Response.Error != nil {
-		return "", fmt.Errorf("API错误: %s - %s", result.Response.Error.Code, result.Response.Error.Message)
+	if err := s.Call(context.Background(), "GetWsToken", payload, &result); err != nil {
+		return err
 	}
 
-	s.cachedToken = result.Response.Token
-	s.expireTime = time.Now().Add(4*time.Minute + 30*time.Second)
-
-	log.Printf("[TokenService] Token获取成功, 长度: %d", len(s.cachedToken))
-	return s.cachedToken, nil
-}
-
-// buildHeaders 构建请求头（TC3签名）
-func (s *Service) buildHeaders(req *http.Request, action string, payload []byte, timestamp int64) {
-	service := "lke"
-	host := "lke.tencentcloudapi.com"
-	region := "ap-guangzhou"
-	version := "2023-11-30"
-	contentType := "application/json"
-
-	date := time.Unix(timestamp, 0).UTC().Format("2006-01-02")
-
-	// CanonicalRequest
-	httpRequestMethod := "POST"
-	canonicalUri := "/"
-	canonicalQueryString := ""
-	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-tc-action:%s\n",
-		contentType, host, strings.ToLower(action))
-	signedHeaders := "content-type;host;x-tc-action"
-	hashedPayload := sha256Hash(payload)
-	canonicalRequest := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s",
-		httpRequestMethod, canonicalUri, canonicalQueryString, canonicalHeaders, signedHeaders, hashedPayload)
-
-	// StringToSign
-	algorithm := "TC3-HMAC-SHA256"
-	credentialScope := fmt.Sprintf("%s/%s/tc3_request", date, service)
-	hashedCanonicalRequest := sha256Hash([]byte(canonicalRequest))
-	stringToSign := fmt.Sprintf("%s\n%d\n%s\n%s",
-		algorithm, timestamp, credentialScope, hashedCanonicalRequest)
-
-	// Signature
-	signature := s.sign(date, service, stringToSign)
-
-	// Authorization
-	authorization := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
-		algorithm, s.secretId, credentialScope, signedHeaders, signature)
-
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("Authorization", authorization)
-	req.Header.Set("X-TC-Action", action)
-	req.Header.Set("X-TC-Version", version)
-	req.Header.Set("X-TC-Timestamp", fmt.Sprintf("%d", timestamp))
-	req.Header.Set("X-TC-Region", region)
-}
+	now := s.now()
+	expireTime := tokenExpiry(result.Token, result.ExpiredTime, now)
 
-// sign 生成TC3签名
-func (s *Service) sign(date, service, stringToSign string) string {
-	kDate := hmacSHA256([]byte("TC3"+s.secretKey), []byte(date))
-	kService := hmacSHA256(kDate, []byte(service))
-	kSigning := hmacSHA256(kService, []byte("tc3_request"))
-	return hex.EncodeToString(hmacSHA256(kSigning, []byte(stringToSign)))
-}
+	s.mu.Lock()
+	s.cachedToken = result.Token
+	s.expireTime = expireTime
+	s.mu.Unlock()
 
-func sha256Hash(data []byte) string {
-	h := sha256.New()
-	h.Write(data)
-	return hex.EncodeToString(h.Sum(nil))
+	logger.Info("Token获取成功", "length", len(result.Token), "expire", expireTime.Format(time.RFC3339))
+	s.scheduleRefresh(refreshDelay(now, expireTime))
+	return nil
 }
 
-func hmacSHA256(key, data []byte) []byte {
-	h := hmac.New(sha256.New, key)
-	h.Write(data)
-	return h.Sum(nil)
+// Call 调用LKE云API，out为响应中Response字段对应的结构，ctx用于取消请求和日志字段
+func (s *Service) Call(ctx context.Context, action string, payload any, out any) error {
+	_, err := s.api.CallContext(ctx, action, payload, out)
+	return err
 }
 
 func min(a, b int) int {